@@ -32,6 +32,7 @@ func main() {
 	// Initialize the agent
 	agent.Init(
 		agent.WithDebug(true),
+		agent.WithManageSignals(true),
 	)
 	defer agent.Shutdown()
 
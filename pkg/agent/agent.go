@@ -2,59 +2,135 @@ package agent
 
 import (
 	"context"
-	"fmt"
-	"log"
+	"crypto/tls"
 	"os"
 	"os/signal"
+	"runtime"
+	"runtime/debug"
 	"sync"
-	"syscall"
+	"sync/atomic"
+	"time"
 
 	"github.com/aivorynet/agent-go/pkg/breakpoint"
 	"github.com/aivorynet/agent-go/pkg/capture"
+	"github.com/aivorynet/agent-go/pkg/internal/logging"
 	"github.com/aivorynet/agent-go/pkg/transport"
 )
 
 // Agent is the main AIVory Monitor agent.
 type Agent struct {
-	config        *Config
-	connection    *transport.Connection
-	breakpointMgr *breakpoint.Manager
-	started       bool
-	mu            sync.RWMutex
+	config         *Config
+	log            *logging.Logger
+	transport      transport.Transport
+	connection     *transport.Connection
+	fileSink       *transport.FileSink
+	breakpointMgr  *breakpoint.Manager
+	build          *capture.BuildInfo
+	release        string
+	trimPathPrefix string
+	process        *capture.ProcessContext
+	host           *capture.HostInfo
+	started        bool
+	paused         bool
+	suppressed     int
+	firstSent      atomic.Bool
+	mu             sync.RWMutex
 
 	// Custom context
 	customContext map[string]interface{}
 	user          map[string]string
+	breadcrumbs   *breadcrumbTrail
+	recentLogs    *logBuffer
+	featureFlags  map[string]bool
+	tags          map[string]string
+	dedup         *dedupCache
+	limiter       *fingerprintLimiter
+	workers       *workerPool
+
+	// signalsDone ends the signal handler when the agent is stopped.
+	signalsDone chan struct{}
 }
 
 var (
-	globalAgent *Agent
-	globalOnce  sync.Once
+	globalAgent   *Agent
+	globalInitErr error
+	globalOnce    sync.Once
 )
 
-// Init initializes the global agent with the given options.
+// Init initializes the global agent with the given options. Only the
+// first call has an effect; the package-level functions use this agent.
+// If the configuration is invalid, the error is logged and the agent is
+// nil, which turns the package-level functions into no-ops.
 func Init(options ...ConfigOption) *Agent {
+	agent, err := InitE(options...)
+	if err != nil {
+		NewConfig(options...).logger().Errorf("%v", err)
+	}
+	return agent
+}
+
+// InitE is like Init but returns the configuration error, if any, so
+// programs that must not run unmonitored can fail at startup. Later calls
+// return the result of the first.
+func InitE(options ...ConfigOption) (*Agent, error) {
 	globalOnce.Do(func() {
-		config := NewConfig(options...)
+		globalAgent, globalInitErr = newAgent(NewConfig(options...))
+	})
 
-		if config.APIKey == "" {
-			log.Println("[AIVory Monitor] API key is required. Set AIVORY_API_KEY or use WithAPIKey option.")
-			return
+	return globalAgent, globalInitErr
+}
+
+// New creates and starts an agent that is independent of the global agent,
+// e.g. one per tenant with its own API key and environment. Use its methods
+// instead of the package-level functions, and Stop it when done. It logs
+// the error and returns nil if the agent is enabled but its configuration
+// is invalid; see Config.Validate.
+func New(options ...ConfigOption) *Agent {
+	config := NewConfig(options...)
+	a, err := newAgent(config)
+	if err != nil {
+		config.logger().Errorf("%v", err)
+		return nil
+	}
+	return a
+}
+
+// newAgent creates and, if enabled, starts an agent.
+func newAgent(config *Config) (*Agent, error) {
+	if config.Enabled {
+		if err := config.Validate(); err != nil {
+			return nil, err
 		}
+	}
 
-		globalAgent = &Agent{
-			config:        config,
-			customContext: make(map[string]interface{}),
-			user:          make(map[string]string),
+	a := &Agent{
+		config:        config,
+		log:           config.logger(),
+		customContext: make(map[string]interface{}),
+		user:          make(map[string]string),
+		breadcrumbs:   newBreadcrumbTrail(config.MaxBreadcrumbs),
+		recentLogs:    newLogBuffer(config.MaxRecentLogLines, config.MaxRecentLogBytes, config.RedactKeys, config.RedactFunc),
+		dedup:         newDedupCache(config.DedupWindow),
+		limiter:       newFingerprintLimiter(config.FingerprintLimit, config.FingerprintLimitWindow),
+	}
+	a.tags = a.mergeTags(config.Tags, nil)
+	a.workers = newWorkerPool(config.AsyncWorkers, func(ev *event) { a.captureSampled(ev) })
+
+	if !config.Enabled {
+		if config.disabledReason != "" {
+			a.log.Infof("Agent disabled: %s; use WithEnabled(true) to enable it", config.disabledReason)
+		} else {
+			a.log.Infof("Agent disabled")
 		}
+		return a, nil
+	}
 
-		globalAgent.Start()
+	a.Start()
 
-		log.Printf("[AIVory Monitor] Agent v1.0.0 initialized")
-		log.Printf("[AIVory Monitor] Environment: %s", config.Environment)
-	})
+	a.log.Infof("Agent v1.0.0 initialized")
+	a.log.Infof("Environment: %s", config.Environment)
 
-	return globalAgent
+	return a, nil
 }
 
 // GetAgent returns the global agent instance.
@@ -67,34 +143,171 @@ func (a *Agent) Start() {
 	a.mu.Lock()
 	defer a.mu.Unlock()
 
-	if a.started {
+	if a.started || !a.config.Enabled {
+		return
+	}
+
+	// Handle shutdown signals once started, whatever the transport.
+	defer func() {
+		if a.started && a.config.ManageSignals {
+			a.signalsDone = make(chan struct{})
+			go a.handleSignals(a.signalsDone)
+		}
+	}()
+
+	a.build = readBuildInfo()
+	a.trimPathPrefix = a.config.TrimPathPrefix
+	if a.trimPathPrefix == "" {
+		a.trimPathPrefix = detectModuleRoot()
+	}
+	a.release = a.config.Release
+	if a.release == "" && a.build != nil {
+		a.release = a.build.Version
+	}
+	if a.config.CaptureProcessContext {
+		a.process = capture.NewProcessContext(a.config.RedactKeys, a.config.RedactFunc)
+	}
+	a.host = capture.NewHostInfo(a.config.Hostname)
+	if a.workers != nil {
+		a.workers.start()
+	}
+
+	if a.config.FileSinkPath != "" {
+		sink, err := transport.NewFileSink(a.config.FileSinkPath, a.config.FileSinkMaxBytes)
+		if err != nil {
+			a.log.Errorf("%v", err)
+		} else {
+			sink.SetLogger(a.config.Logger)
+			a.fileSink = sink
+		}
+	}
+	if a.config.fileSinkOnly() {
+		if a.fileSink == nil {
+			return
+		}
+		a.transport, a.fileSink = a.fileSink, nil
+		a.started = true
+
+		if a.config.Debug {
+			a.log.Debugf("Agent started with a file sink, writing captures to %s", a.config.FileSinkPath)
+		}
+		return
+	}
+
+	if a.config.Transport != nil {
+		a.transport = a.config.Transport
+		a.started = true
+
+		if a.config.Debug {
+			a.log.Debugf("Agent started with a custom transport")
+		}
+		return
+	}
+
+	if a.config.SentryDSN != "" {
+		sentry, err := transport.NewSentryTransport(a.config.SentryDSN, a.config.Debug)
+		if err != nil {
+			a.log.Errorf("%v", err)
+			return
+		}
+		sentry.SetLogger(a.config.Logger)
+		a.transport = sentry
+		a.started = true
+
+		if a.config.Debug {
+			a.log.Debugf("Agent started in Sentry compatibility mode")
+		}
 		return
 	}
 
 	// Initialize connection
-	a.connection = transport.NewConnection(a.config.BackendURL, a.config.APIKey, a.config.Debug)
+	registerInfo := make(map[string]interface{})
+	if a.build != nil {
+		registerInfo["build"] = a.build
+	}
+	if a.process != nil {
+		registerInfo["process"] = a.process
+	}
+	if a.host != nil {
+		registerInfo["host"] = a.host
+	}
+	if a.release != "" {
+		registerInfo["release"] = a.release
+	}
+	opts := []transport.Option{
+		transport.WithRegisterInfo(registerInfo),
+		transport.WithSendTimeout(a.config.SendTimeout),
+		transport.WithBatchInterval(a.config.BatchInterval),
+		transport.WithBatchSize(a.config.BatchSize),
+		transport.WithCompression(a.config.Compression),
+		transport.WithReliableDelivery(a.config.ReliableDelivery),
+		transport.WithMaxMessageBytes(a.config.MaxMessageBytes),
+		transport.WithHandshakeHeaders(a.config.HandshakeHeaders),
+		transport.WithReconnect(a.config.ReconnectDelay, a.config.MaxReconnectDelay, a.config.MaxReconnectAttempts),
+		transport.WithHeartbeatInterval(a.config.HeartbeatInterval),
+		transport.WithReadTimeout(a.config.ReadTimeout),
+		transport.WithOfflineBuffer(a.config.OfflineBuffer),
+		transport.WithLogger(a.config.Logger),
+	}
+	if a.config.DiskQueueDir != "" {
+		opts = append(opts, transport.WithDiskQueue(a.config.DiskQueueDir, a.config.DiskQueueMaxBytes))
+	}
+	if tlsConfig := a.tlsConfig(); tlsConfig != nil {
+		opts = append(opts, transport.WithTLSConfig(tlsConfig))
+	}
+	if a.config.ConnectionStateHandler != nil {
+		opts = append(opts, transport.WithConnectionStateHandler(a.config.ConnectionStateHandler))
+	}
+	a.connection = transport.NewConnection(a.config.BackendURL, a.config.APIKey, a.config.Debug, opts...)
+	a.transport = a.connection
 
 	// Initialize breakpoint support
 	if a.config.EnableBreakpoints {
-		a.breakpointMgr = breakpoint.NewManager(a.config.Debug, a.connection)
+		a.breakpointMgr = breakpoint.NewManager(a.config.Debug, a.connection,
+			breakpoint.WithCaptureOptions(a.captureOptions()),
+			breakpoint.WithLogger(a.config.Logger),
+		)
 		a.connection.SetBreakpointCallback(a.breakpointMgr.HandleCommand)
 	}
 
 	// Connect to backend
 	go a.connection.Connect(context.Background())
 
-	// Handle shutdown signals
-	go a.handleSignals()
-
 	a.started = true
 
 	if a.config.Debug {
-		log.Println("[AIVory Monitor] Agent started")
+		a.log.Debugf("Agent started")
+	}
+}
+
+// tlsConfig returns the TLS configuration for the backend connection, or
+// nil to use the defaults.
+func (a *Agent) tlsConfig() *tls.Config {
+	if !a.config.InsecureSkipVerify {
+		return a.config.TLSConfig
+	}
+
+	a.log.Warnf("WARNING: TLS certificate verification is disabled; do not use this in production")
+
+	config := &tls.Config{}
+	if a.config.TLSConfig != nil {
+		config = a.config.TLSConfig.Clone()
 	}
+	config.InsecureSkipVerify = true
+	return config
 }
 
-// Stop stops the agent.
+// defaultFlushTimeout bounds how long Stop waits for queued captures.
+const defaultFlushTimeout = 2 * time.Second
+
+// Stop flushes queued captures, waiting up to 2 seconds, and stops the
+// agent. It is safe to call more than once and from several goroutines.
 func (a *Agent) Stop() {
+	a.Flush(defaultFlushTimeout)
+	if a.workers != nil {
+		a.workers.stop()
+	}
+
 	a.mu.Lock()
 	defer a.mu.Unlock()
 
@@ -102,41 +315,329 @@ func (a *Agent) Stop() {
 		return
 	}
 
-	if a.connection != nil {
-		a.connection.Disconnect()
+	if a.transport != nil {
+		a.transport.Disconnect()
+	}
+	if a.fileSink != nil {
+		a.fileSink.Disconnect()
+		a.fileSink = nil
+	}
+	if a.breakpointMgr != nil {
+		a.breakpointMgr.Close()
+	}
+	if a.signalsDone != nil {
+		close(a.signalsDone)
+		a.signalsDone = nil
 	}
 
 	a.started = false
 
 	if a.config.Debug {
-		log.Println("[AIVory Monitor] Agent stopped")
+		a.log.Debugf("Agent stopped")
+	}
+}
+
+// Flush blocks until all queued captures have been sent or the timeout
+// elapses, and returns true if everything was sent. Call it before exiting
+// short-lived programs such as CLI tools and serverless functions.
+func (a *Agent) Flush(timeout time.Duration) bool {
+	deadline := time.Now().Add(timeout)
+	if !a.workers.wait(timeout) {
+		return false
+	}
+
+	a.mu.RLock()
+	t := a.transport
+	a.mu.RUnlock()
+
+	if f, ok := t.(flusher); ok {
+		return f.Flush(time.Until(deadline))
+	}
+	return true
+}
+
+// flusher is implemented by transports that queue captures.
+type flusher interface {
+	Flush(timeout time.Duration) bool
+}
+
+// Stats returns a snapshot of the transport's counters of sent messages,
+// messages dropped because the queue was full or the agent was
+// disconnected, and reconnects. Captures dropped because the async capture
+// queue was full count as dropped because the queue was full. The
+// transport counters are zero if the transport does not keep them.
+func (a *Agent) Stats() transport.Stats {
+	a.mu.RLock()
+	t := a.transport
+	a.mu.RUnlock()
+
+	var stats transport.Stats
+	if s, ok := t.(statser); ok {
+		stats = s.Stats()
+	}
+	if a.workers != nil {
+		stats.DroppedQueueFull += a.workers.dropped.Load()
+	}
+	return stats
+}
+
+// statser is implemented by transports that count messages.
+type statser interface {
+	Stats() transport.Stats
+}
+
+// IsConnected returns true if captures can currently be delivered to the
+// backend. Health checks can use it to report that monitoring is down; it
+// is false for an agent that is disabled or not started.
+func (a *Agent) IsConnected() bool {
+	a.mu.RLock()
+	t := a.transport
+	a.mu.RUnlock()
+
+	return t != nil && t.IsConnected()
+}
+
+// ConnectionInfo returns diagnostics of the connection to the backend:
+// its URL and state, the current reconnect attempt and the time of the
+// last successful send. Transports other than the WebSocket connection
+// only report whether they are connected.
+func (a *Agent) ConnectionInfo() ConnectionInfo {
+	a.mu.RLock()
+	t := a.transport
+	a.mu.RUnlock()
+
+	if c, ok := t.(infoer); ok {
+		return c.Info()
+	}
+	connected := t != nil && t.IsConnected()
+	info := ConnectionInfo{Connected: connected, Authenticated: connected}
+	if connected {
+		info.State = StateAuthenticated
+	}
+	return info
+}
+
+// infoer is implemented by transports that report connection diagnostics.
+type infoer interface {
+	Info() transport.ConnectionInfo
+}
+
+// Pause temporarily suppresses all captures until Resume is called.
+// Useful around planned noisy operations that are expected to fail.
+func (a *Agent) Pause() {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	if a.paused {
+		return
+	}
+	a.paused = true
+	a.suppressed = 0
+
+	if a.config.Debug {
+		a.log.Debugf("Capture paused")
+	}
+}
+
+// Resume re-enables captures after Pause and returns the number of
+// captures that were suppressed while paused.
+func (a *Agent) Resume() int {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	if !a.paused {
+		return 0
+	}
+	suppressed := a.suppressed
+	a.paused = false
+	a.suppressed = 0
+
+	a.log.Infof("Capture resumed, %d captures suppressed while paused", suppressed)
+	return suppressed
+}
+
+// IsPaused returns true if captures are currently suppressed.
+func (a *Agent) IsPaused() bool {
+	a.mu.RLock()
+	defer a.mu.RUnlock()
+	return a.paused
+}
+
+// suppressIfPaused returns true and counts the capture if the agent is paused.
+func (a *Agent) suppressIfPaused() bool {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	if !a.paused {
+		return false
 	}
+	a.suppressed++
+	return true
 }
 
 // CaptureError captures an error with optional context.
 func (a *Agent) CaptureError(err error, ctx ...map[string]interface{}) {
-	if !a.started || !a.config.ShouldSample() {
-		return
+	ev := &event{
+		err:         err,
+		level:       LevelError,
+		breadcrumbs: a.globalBreadcrumbs(),
+	}
+	if len(ctx) > 0 {
+		ev.context = ctx[0]
+	}
+
+	a.captureEvent(ev)
+}
+
+// CaptureErrorWithResult captures an error like CaptureError and returns
+// the capture that was sent, so callers can inspect fields such as the
+// Fingerprint. It returns nil if the error was not captured (agent not
+// started, paused, not sampled or dropped by the before-send hook). The
+// capture is made on the caller's goroutine even with WithAsyncWorkers.
+func (a *Agent) CaptureErrorWithResult(err error, ctx ...map[string]interface{}) *capture.ExceptionCapture {
+	ev := &event{
+		err:         err,
+		level:       LevelError,
+		breadcrumbs: a.globalBreadcrumbs(),
+		wait:        true,
+	}
+	if len(ctx) > 0 {
+		ev.context = ctx[0]
 	}
 
-	var context map[string]interface{}
+	return a.captureEvent(ev)
+}
+
+// CaptureErrorWithLevel captures an error at the given severity level,
+// one of "fatal", "error", "warning", "info" or "debug". Unknown levels
+// fall back to "error".
+func (a *Agent) CaptureErrorWithLevel(err error, level Level, ctx ...map[string]interface{}) {
+	ev := &event{
+		err:         err,
+		level:       level,
+		breadcrumbs: a.globalBreadcrumbs(),
+	}
 	if len(ctx) > 0 {
-		context = ctx[0]
+		ev.context = ctx[0]
+	}
+
+	a.captureEvent(ev)
+}
+
+// CaptureOptions holds per-capture settings for CaptureErrorWithOptions.
+type CaptureOptions struct {
+	// Level is the severity of the capture. Defaults to error.
+	Level Level
+
+	// Tags are attached to this capture only, overriding global tags with
+	// the same key.
+	Tags map[string]string
+
+	// Context is added to the capture context.
+	Context map[string]interface{}
+
+	// Fingerprint, if set, replaces the computed fingerprint with the hash
+	// of its parts, grouping captures from different call sites together
+	// or splitting one error by a discriminator. A DefaultFingerprint part
+	// stands for the computed fingerprint:
+	//
+	//	Fingerprint: []string{agent.DefaultFingerprint, tenantID}
+	Fingerprint []string
+}
+
+// CaptureErrorWithOptions captures an error with per-capture settings and
+// returns the capture that was sent, or nil if the error was not captured.
+// Like CaptureErrorWithResult, it captures on the caller's goroutine even
+// with WithAsyncWorkers.
+func (a *Agent) CaptureErrorWithOptions(err error, opts CaptureOptions) *capture.ExceptionCapture {
+	return a.captureEvent(&event{
+		err:         err,
+		level:       opts.Level,
+		context:     opts.Context,
+		breadcrumbs: a.globalBreadcrumbs(),
+		tags:        opts.Tags,
+		fingerprint: opts.Fingerprint,
+		wait:        true,
+	})
+}
+
+// event carries the per-capture inputs through the capture pipeline.
+type event struct {
+	err         error
+	level       Level
+	context     map[string]interface{}
+	breadcrumbs []capture.Breadcrumb
+
+	// scope holds context-scoped values added to the capture context
+	// without being captured as variables.
+	scope map[string]interface{}
+
+	// featureFlags holds context-scoped flags that override global ones.
+	featureFlags map[string]bool
+
+	// tags holds per-capture tags that override global ones.
+	tags map[string]string
+
+	// fingerprint, if set, overrides the computed fingerprint.
+	fingerprint []string
+
+	// joinIndex is the 1-based position of err among the errors of an
+	// exploded errors.Join, or zero.
+	joinIndex int
+
+	// callers is the stack the event was captured at, recorded for
+	// panics.
+	callers []uintptr
+
+	// captured holds the stack and variables captured on the caller's
+	// goroutine for the async workers to complete.
+	captured *capture.ExceptionCapture
+
+	// sync marks an event captured by CaptureErrorSync: it bypasses the
+	// async workers and is returned undelivered by captureSampled.
+	sync bool
+
+	// wait marks an event whose capture is returned to the caller: it
+	// bypasses the async workers but is dispatched as usual.
+	wait bool
+}
+
+// captureEvent builds, enriches and sends a capture for the event.
+func (a *Agent) captureEvent(ev *event) *capture.ExceptionCapture {
+	ev.level = a.normalizeLevel(ev.level)
+
+	if a.config.ExplodeJoinedErrors && ev.joinIndex == 0 && !ev.sync {
+		if errs := joinedErrors(ev.err); len(errs) > 1 {
+			return a.captureJoined(ev, errs)
+		}
+	}
+
+	if !a.started || a.suppressIfPaused() || !a.config.ShouldSampleLevel(ev.level) {
+		return nil
+	}
+
+	// Fatal events are captured right away as the program is likely to
+	// exit next.
+	if a.workers != nil && ev.level != LevelFatal && !ev.sync && !ev.wait {
+		a.captureAsync(ev)
+		return nil
 	}
+	return a.captureSampled(ev)
+}
 
-	captured := capture.CaptureError(err, a.config.MaxCaptureDepth, context)
-	captured.AgentID = a.config.AgentID
-	captured.Environment = a.config.Environment
-	captured.Runtime = "go"
-	ri := a.config.GetRuntimeInfo()
-	captured.RuntimeInfo = capture.RuntimeInfo{
-		Runtime:        ri.Runtime,
-		RuntimeVersion: ri.RuntimeVersion,
-		Platform:       ri.Platform,
-		Arch:           ri.Arch,
-		NumCPU:         ri.NumCPU,
-		NumGoroutine:   ri.NumGoroutine,
+// captureSampled captures an event that passed sampling and dispatches it.
+func (a *Agent) captureSampled(ev *event) *capture.ExceptionCapture {
+	captured := ev.captured
+	if captured == nil {
+		captured = a.captureValues(ev)
 	}
+	captured.Level = ev.level
+	captured.Breadcrumbs = ev.breadcrumbs
+	captured.RecentLogs = a.recentLogs.snapshot()
+	captured.FeatureFlags = mergeFeatureFlags(ev.featureFlags, a.globalFeatureFlags())
+	captured.Tags = a.mergeTags(ev.tags, a.globalTags())
+	a.markSentinels(captured, ev.err)
+	a.stamp(captured)
 
 	// Add custom context
 	a.mu.RLock()
@@ -147,31 +648,132 @@ func (a *Agent) CaptureError(err error, ctx ...map[string]interface{}) {
 		captured.Context["user"] = a.user
 	}
 	a.mu.RUnlock()
+	for k, v := range ev.scope {
+		captured.Context[k] = v
+	}
+	if ev.joinIndex > 0 {
+		markJoined(captured, ev.joinIndex-1)
+	}
+	if len(ev.fingerprint) > 0 {
+		overrideFingerprint(captured, ev.fingerprint)
+	}
 
-	if a.connection != nil {
-		a.connection.SendException(captured)
+	if ev.sync {
+		return a.prepare(captured, true)
+	}
+	return a.dispatch(captured)
+}
+
+// captureValues captures the stack and variables of an event.
+func (a *Agent) captureValues(ev *event) *capture.ExceptionCapture {
+	opts := a.captureOptions()
+	opts.Callers = ev.callers
+	return capture.CaptureErrorWithOptions(ev.err, opts, ev.context)
+}
+
+// captureOptions builds the capture options from the agent configuration.
+func (a *Agent) captureOptions() capture.Options {
+	return capture.Options{
+		MaxDepth:             a.config.MaxCaptureDepth,
+		MaxLocalVariables:    a.config.MaxLocalVariables,
+		MaxStringLength:      a.config.MaxStringLength,
+		MaxCollectionSize:    a.config.MaxCollectionSize,
+		RedactKeys:           a.config.RedactKeys,
+		RedactFunc:           a.config.RedactFunc,
+		UseJSONMarshaler:     a.config.UseJSONMarshaler,
+		RespectJSONTags:      a.config.RespectJSONTags,
+		TopFrameSource:       a.config.TopFrameSource,
+		CaptureSource:        a.config.CaptureSource,
+		IDGenerator:          a.config.IDGenerator,
+		MaxStructFields:      a.config.MaxStructFields,
+		MaxErrorChainDepth:   a.config.MaxErrorChainDepth,
+		FingerprintMode:      a.config.FingerprintMode,
+		CaptureAllGoroutines: a.config.CaptureAllGoroutines,
+		Fingerprinter:        a.config.Fingerprinter,
+		StableFingerprint:    a.config.StableFingerprint,
+		TrimPathPrefix:       a.trimPathPrefix,
+		CaptureUnexported:    a.config.CaptureUnexported,
+		MaxPayloadBytes:      a.config.MaxPayloadBytes,
+		MaxStackFrames:       a.config.MaxStackFrames,
+		IncludeRuntimeFrames: a.config.IncludeRuntimeFrames,
 	}
 }
 
-// handlePanic handles a recovered panic value (internal use).
+// handlePanic captures a recovered panic value that is re-panicked next,
+// which is likely to terminate the process (internal use).
 func (a *Agent) handlePanic(r interface{}) {
-	var err error
-	switch v := r.(type) {
+	if a.suppressIfPaused() {
+		return
+	}
+	a.sendFatal(a.panicEvent(r, map[string]interface{}{"panic": true}, nil))
+}
+
+// sendFatal captures a fatal event and delivers it synchronously, waiting
+// up to the panic send timeout, so that it is not lost when the process
+// exits right after. Used before re-panicking.
+func (a *Agent) sendFatal(ev *event) {
+	ev.sync = true
+	if c := a.captureEvent(ev); c != nil {
+		a.sendSync(c, a.config.PanicSendTimeout)
+	}
+}
+
+// capturePanic captures a recovered panic value as a fatal capture with
+// the given context and scope.
+func (a *Agent) capturePanic(r interface{}, ctx, scope map[string]interface{}) {
+	if a.suppressIfPaused() {
+		return
+	}
+	a.captureEvent(a.panicEvent(r, ctx, scope))
+}
+
+// panicEvent returns the fatal event for a recovered panic value. It must
+// be called while the panic is being recovered, to record its stack.
+func (a *Agent) panicEvent(r interface{}, ctx, scope map[string]interface{}) *event {
+	err, ok := r.(error)
+	if !ok {
+		err = &capture.PanicError{Value: r}
+	}
+
+	panicScope := make(map[string]interface{}, len(scope)+1)
+	for k, v := range scope {
+		panicScope[k] = v
+	}
+	panicScope["panic_kind"] = panicKind(r)
+
+	return &event{
+		err:         err,
+		level:       LevelFatal,
+		context:     ctx,
+		breadcrumbs: a.globalBreadcrumbs(),
+		scope:       panicScope,
+		callers:     capture.PanicCallers(),
+	}
+}
+
+// panicKind classifies a recovered panic value for the panic_kind context
+// entry: a runtime error such as a nil dereference, another error, a
+// string, or any other value.
+func panicKind(r interface{}) string {
+	switch r.(type) {
+	case runtime.Error:
+		return "runtime_error"
 	case error:
-		err = v
+		return "error"
 	case string:
-		err = fmt.Errorf("%s", v)
+		return "string"
 	default:
-		err = fmt.Errorf("%v", v)
+		return "value"
 	}
-
-	a.CaptureError(err, map[string]interface{}{"panic": true})
 }
 
 // CapturePanic captures a panic value with recovery.
 // IMPORTANT: Must be called directly as a deferred function because
 // recover() only works when called directly by a deferred function.
 // Use: defer agent.CapturePanic()
+//
+// The capture is sent synchronously, waiting up to the panic send timeout,
+// before the panic is re-raised with its original value.
 func (a *Agent) CapturePanic() {
 	if r := recover(); r != nil {
 		a.handlePanic(r)
@@ -208,17 +810,45 @@ func (a *Agent) SetUser(id, email, username string) {
 	}
 }
 
+// Ready returns a channel that is closed once the agent is fully
+// operational: connected, registered with the backend and done replaying
+// buffered captures. The channel never closes if the agent is not started.
+// In Sentry compatibility mode, with a custom transport and when writing
+// to a file sink only, it is closed as soon as the agent starts.
+func (a *Agent) Ready() <-chan struct{} {
+	a.mu.RLock()
+	defer a.mu.RUnlock()
+
+	if a.connection != nil {
+		return a.connection.Ready()
+	}
+	if a.transport == nil {
+		return make(chan struct{})
+	}
+	ready := make(chan struct{})
+	close(ready)
+	return ready
+}
+
 // Config returns the agent configuration.
 func (a *Agent) Config() *Config {
 	return a.config
 }
 
-func (a *Agent) handleSignals() {
+// handleSignals stops the agent on one of shutdownSignals. It leaves
+// terminating the process to the application. It returns when done is
+// closed.
+func (a *Agent) handleSignals(done <-chan struct{}) {
 	sigChan := make(chan os.Signal, 1)
-	signal.Notify(sigChan, syscall.SIGINT, syscall.SIGTERM)
+	signal.Notify(sigChan, shutdownSignals...)
+	defer signal.Stop(sigChan)
 
-	<-sigChan
-	a.Stop()
+	select {
+	case <-sigChan:
+		// Stop flushes queued captures first.
+		a.Stop()
+	case <-done:
+	}
 }
 
 // Package-level convenience functions
@@ -230,6 +860,32 @@ func CaptureError(err error, ctx ...map[string]interface{}) {
 	}
 }
 
+// CaptureErrorWithResult captures an error using the global agent and
+// returns the capture, or nil if nothing was captured.
+func CaptureErrorWithResult(err error, ctx ...map[string]interface{}) *capture.ExceptionCapture {
+	if globalAgent != nil {
+		return globalAgent.CaptureErrorWithResult(err, ctx...)
+	}
+	return nil
+}
+
+// CaptureErrorWithLevel captures an error at the given severity level
+// using the global agent.
+func CaptureErrorWithLevel(err error, level Level, ctx ...map[string]interface{}) {
+	if globalAgent != nil {
+		globalAgent.CaptureErrorWithLevel(err, level, ctx...)
+	}
+}
+
+// CaptureErrorWithOptions captures an error with per-capture settings
+// using the global agent.
+func CaptureErrorWithOptions(err error, opts CaptureOptions) *capture.ExceptionCapture {
+	if globalAgent != nil {
+		return globalAgent.CaptureErrorWithOptions(err, opts)
+	}
+	return nil
+}
+
 // CapturePanic captures a panic using the global agent.
 // IMPORTANT: recover() must be called directly in the deferred function,
 // so we call recover() here and pass the value to handlePanic.
@@ -244,6 +900,65 @@ func CapturePanic() {
 	}
 }
 
+// InstallPanicHandler sets up a last-resort panic reporter for the calling
+// goroutine, normally main. It enables debug.SetPanicOnFault so that
+// memory faults such as a nil dereference through unsafe memory or a bad
+// mmap access panic instead of crashing, and returns a handler that must
+// be deferred directly:
+//
+//	func main() {
+//		agent.Init()
+//		defer agent.Shutdown()
+//		defer agent.InstallPanicHandler()()
+//		...
+//	}
+//
+// A panic that nothing recovers is captured as fatal and sent
+// synchronously, waiting up to the panic send timeout. The handler then
+// restores the previous SetPanicOnFault setting and re-panics with the
+// original value, so the process still crashes with the original panic.
+//
+// It catches panics and memory faults on the goroutine that deferred it.
+// It cannot catch panics on other goroutines, which should be started with
+// Go, or run in HTTP handlers wrapped with HTTPMiddleware, nor fatal
+// runtime errors such as concurrent map writes, deadlocks, out-of-memory or
+// stack exhaustion, which terminate the process without unwinding.
+func (a *Agent) InstallPanicHandler() func() {
+	return panicHandler(func() *Agent { return a })
+}
+
+// InstallPanicHandler sets up a last-resort panic reporter for the calling
+// goroutine using the global agent.
+func InstallPanicHandler() func() {
+	return panicHandler(func() *Agent { return globalAgent })
+}
+
+// InstallGlobalHandler sets up panic capture for the calling goroutine in
+// one call. It is InstallPanicHandler with the global agent.
+func InstallGlobalHandler() func() {
+	return InstallPanicHandler()
+}
+
+// panicHandler enables SetPanicOnFault and returns the handler of
+// InstallPanicHandler, capturing with the agent returned by current at the
+// time of the panic, if any.
+func panicHandler(current func() *Agent) func() {
+	previous := debug.SetPanicOnFault(true)
+
+	return func() {
+		r := recover()
+		debug.SetPanicOnFault(previous)
+		if r == nil {
+			return
+		}
+		if a := current(); a != nil {
+			a.handlePanic(r)
+		}
+		// Re-panic to maintain normal behavior
+		panic(r)
+	}
+}
+
 // SetContext sets custom context using the global agent.
 func SetContext(ctx map[string]interface{}) {
 	if globalAgent != nil {
@@ -260,14 +975,68 @@ func SetUser(id, email, username string) {
 
 // Breakpoint triggers a non-breaking breakpoint capture using the global agent.
 // Only captures if the breakpoint ID has been registered by the backend.
-// Place this call at locations where you want to capture context.
-func Breakpoint(id string) {
+// Place this call at locations where you want to capture context, passing
+// the local variables to record, if any:
+//
+//	agent.Breakpoint("checkout-total", map[string]interface{}{"cart": cart})
+func Breakpoint(id string, locals ...map[string]interface{}) {
 	if globalAgent != nil && globalAgent.breakpointMgr != nil {
-		globalAgent.breakpointMgr.Hit(id)
+		globalAgent.breakpointMgr.Hit(id, locals...)
+	}
+}
+
+// Ready returns a channel that is closed once the global agent is fully
+// operational.
+func Ready() <-chan struct{} {
+	if globalAgent != nil {
+		return globalAgent.Ready()
+	}
+	return make(chan struct{})
+}
+
+// Pause suppresses captures on the global agent until Resume is called.
+func Pause() {
+	if globalAgent != nil {
+		globalAgent.Pause()
+	}
+}
+
+// Resume re-enables captures on the global agent and returns the number
+// of captures suppressed while paused.
+func Resume() int {
+	if globalAgent != nil {
+		return globalAgent.Resume()
+	}
+	return 0
+}
+
+// Flush blocks until all captures queued by the global agent have been
+// sent or the timeout elapses, and returns true if everything was sent.
+func Flush(timeout time.Duration) bool {
+	if globalAgent != nil {
+		return globalAgent.Flush(timeout)
+	}
+	return true
+}
+
+// Stats returns a snapshot of the global agent's message counters.
+func Stats() transport.Stats {
+	if globalAgent != nil {
+		return globalAgent.Stats()
+	}
+	return transport.Stats{}
+}
+
+// IsConnected returns true if the global agent can currently deliver
+// captures to the backend.
+func IsConnected() bool {
+	if globalAgent != nil {
+		return globalAgent.IsConnected()
 	}
+	return false
 }
 
-// Shutdown stops the global agent.
+// Shutdown flushes and stops the global agent.
 func Shutdown() {
 	if globalAgent != nil {
 		globalAgent.Stop()
@@ -0,0 +1,235 @@
+package agent_test
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"sync"
+	"testing"
+
+	"github.com/aivorynet/agent-go/pkg/agent"
+	"github.com/aivorynet/agent-go/pkg/agent/agenttest"
+)
+
+// newTestAgent starts an agent recording its captures in memory. It is
+// enabled even in CI, does not deduplicate and logs nothing.
+func newTestAgent(t *testing.T, options ...agent.ConfigOption) (*agent.Agent, *agenttest.Transport) {
+	t.Helper()
+
+	tr := agenttest.NewTransport()
+	options = append([]agent.ConfigOption{
+		agent.WithTransport(tr),
+		agent.WithEnabled(true),
+		agent.WithDedupWindow(0),
+		agent.WithLogger(agent.LoggerFunc(func(level, msg string) {})),
+	}, options...)
+
+	a := agent.New(options...)
+	if a == nil {
+		t.Fatal("New returned nil")
+	}
+	t.Cleanup(a.Stop)
+	return a, tr
+}
+
+// initTestAgent initializes the global agent like newTestAgent and resets
+// it when the test ends.
+func initTestAgent(t *testing.T, options ...agent.ConfigOption) *agenttest.Transport {
+	t.Helper()
+
+	tr := agenttest.NewTransport()
+	agent.Init(append([]agent.ConfigOption{
+		agent.WithTransport(tr),
+		agent.WithEnabled(true),
+		agent.WithDedupWindow(0),
+		agent.WithLogger(agent.LoggerFunc(func(level, msg string) {})),
+	}, options...)...)
+	t.Cleanup(agenttest.Reset)
+	return tr
+}
+
+func TestPauseSuppressesCaptures(t *testing.T) {
+	a, tr := newTestAgent(t)
+
+	a.Pause()
+	if !a.IsPaused() {
+		t.Fatal("IsPaused = false after Pause")
+	}
+	for i := 0; i < 3; i++ {
+		a.CaptureError(errors.New("while paused"))
+	}
+	if got := len(tr.Captures()); got != 0 {
+		t.Fatalf("captured %d errors while paused, want 0", got)
+	}
+
+	if got := a.Resume(); got != 3 {
+		t.Errorf("Resume = %d, want 3 suppressed", got)
+	}
+	if a.IsPaused() {
+		t.Error("IsPaused = true after Resume")
+	}
+
+	a.CaptureError(errors.New("after resume"))
+	if got := len(tr.Captures()); got != 1 {
+		t.Errorf("captured %d errors after Resume, want 1", got)
+	}
+	if got := a.Resume(); got != 0 {
+		t.Errorf("second Resume = %d, want 0", got)
+	}
+}
+
+func TestCaptureErrorWithResultFingerprint(t *testing.T) {
+	a, tr := newTestAgent(t)
+
+	var fingerprints []string
+	for i := 0; i < 2; i++ {
+		c := a.CaptureErrorWithResult(errors.New("lookup failed"))
+		if c == nil {
+			t.Fatal("CaptureErrorWithResult returned nil")
+		}
+		fingerprints = append(fingerprints, c.Fingerprint)
+	}
+
+	if fingerprints[0] == "" {
+		t.Fatal("empty fingerprint")
+	}
+	if fingerprints[0] != fingerprints[1] {
+		t.Errorf("fingerprints differ for the same error and call site: %q, %q", fingerprints[0], fingerprints[1])
+	}
+	if sent := tr.Captures(); len(sent) != 2 || sent[0].Fingerprint != fingerprints[0] {
+		t.Errorf("returned fingerprint does not match the sent capture")
+	}
+}
+
+func TestIDGenerator(t *testing.T) {
+	n := 0
+	a, tr := newTestAgent(t, agent.WithIDGenerator(func() string {
+		n++
+		return fmt.Sprintf("capture-%d", n)
+	}))
+
+	for i := 0; i < 3; i++ {
+		a.CaptureError(errors.New("boom"))
+	}
+
+	captures := tr.Captures()
+	if len(captures) != 3 {
+		t.Fatalf("got %d captures, want 3", len(captures))
+	}
+	for i, c := range captures {
+		if want := fmt.Sprintf("capture-%d", i+1); c.ID != want {
+			t.Errorf("capture %d ID = %q, want %q", i, c.ID, want)
+		}
+	}
+}
+
+type wideError struct {
+	A, B, C, D, E int
+}
+
+func (e *wideError) Error() string { return "wide error" }
+
+func TestMaxStructFields(t *testing.T) {
+	a, tr := newTestAgent(t, agent.WithMaxStructFields(3))
+
+	a.CaptureError(&wideError{1, 2, 3, 4, 5}, map[string]interface{}{
+		"value": wideError{1, 2, 3, 4, 5},
+	})
+	c := tr.Captures()[0]
+
+	for _, name := range []string{"err.0.A", "err.0.B", "err.0.C"} {
+		if _, ok := c.LocalVariables[name]; !ok {
+			t.Errorf("%s missing", name)
+		}
+	}
+	if _, ok := c.LocalVariables["err.0.D"]; ok {
+		t.Error("err.0.D captured beyond the cap")
+	}
+	if c.OmittedFields != 2 {
+		t.Errorf("OmittedFields = %d, want 2", c.OmittedFields)
+	}
+
+	value := c.LocalVariables["value"]
+	if len(value.Children) != 3 || value.OmittedFields != 2 {
+		t.Errorf("value has %d fields and %d omitted, want 3 and 2", len(value.Children), value.OmittedFields)
+	}
+	if value.TotalFields == nil || *value.TotalFields != 5 {
+		t.Errorf("value TotalFields = %v, want 5", value.TotalFields)
+	}
+}
+
+func TestCaptureProcessContext(t *testing.T) {
+	a, tr := newTestAgent(t, agent.WithCaptureProcessContext())
+	a.CaptureError(errors.New("boom"))
+
+	p := tr.Captures()[0].Process
+	if p == nil || len(p.Args) != len(os.Args) || p.WorkingDir == "" {
+		t.Errorf("Process = %+v, want the command line and working directory", p)
+	}
+}
+
+func TestFingerprintModeRecorded(t *testing.T) {
+	a, tr := newTestAgent(t, agent.WithFingerprintMode(agent.FingerprintMessageOnly))
+
+	a.CaptureError(errors.New("timeout"))
+	a.CaptureError(errors.New("timeout"))
+
+	captures := tr.Captures()
+	if captures[0].FingerprintMode != agent.FingerprintMessageOnly {
+		t.Errorf("FingerprintMode = %q, want message_only", captures[0].FingerprintMode)
+	}
+	if captures[0].Fingerprint != captures[1].Fingerprint {
+		t.Error("the same message from different call sites grouped apart")
+	}
+}
+
+func TestIndependentInstances(t *testing.T) {
+	tenantA, trA := newTestAgent(t, agent.WithEnvironment("tenant-a"))
+	tenantB, trB := newTestAgent(t, agent.WithEnvironment("tenant-b"))
+	global := initTestAgent(t, agent.WithEnvironment("global"))
+
+	tenantA.SetUser("u-a", "", "")
+	tenantA.CaptureError(errors.New("a"))
+	tenantB.CaptureError(errors.New("b"))
+	agent.CaptureError(errors.New("global"))
+
+	if c := trA.Captures(); len(c) != 1 || c[0].Environment != "tenant-a" || c[0].Context["user"] == nil {
+		t.Errorf("tenant A captures = %v", c)
+	}
+	if c := trB.Captures(); len(c) != 1 || c[0].Environment != "tenant-b" || c[0].Context["user"] != nil {
+		t.Errorf("tenant B captures = %v", c)
+	}
+	if c := global.Captures(); len(c) != 1 || c[0].Environment != "global" {
+		t.Errorf("global captures = %v", c)
+	}
+
+	tenantA.Stop()
+	tenantB.CaptureError(errors.New("after stop"))
+	if got := len(trB.Captures()); got != 2 {
+		t.Errorf("tenant B has %d captures after stopping tenant A, want 2", got)
+	}
+}
+
+func TestShutdownTwice(t *testing.T) {
+	// The default transport, so that Shutdown disconnects a real connection.
+	agent.Init(
+		agent.WithEnabled(true),
+		agent.WithAPIKey("key"),
+		agent.WithBackendURL("ws://127.0.0.1:1/monitor/agent"),
+		agent.WithLogger(agent.LoggerFunc(func(level, msg string) {})),
+	)
+	t.Cleanup(agenttest.Reset)
+
+	agent.Shutdown()
+	agent.Shutdown()
+
+	var wg sync.WaitGroup
+	for i := 0; i < 4; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			agent.Shutdown()
+		}()
+	}
+	wg.Wait()
+}
@@ -0,0 +1,76 @@
+// Package agenttest provides helpers for testing code that uses the AIVory
+// Monitor agent.
+package agenttest
+
+import (
+	"sync"
+
+	"github.com/aivorynet/agent-go/pkg/capture"
+	"github.com/aivorynet/agent-go/pkg/internal/hooks"
+
+	// The agent package sets the hooks when it is initialized.
+	_ "github.com/aivorynet/agent-go/pkg/agent"
+)
+
+// Reset stops the global agent, if any, and resets it so the next call to
+// agent.Init initializes a new agent. Call it between tests that configure
+// the global agent differently, e.g. with t.Cleanup(agenttest.Reset). It
+// must not be called concurrently with other uses of the global agent.
+func Reset() {
+	hooks.ResetGlobal()
+}
+
+// Transport is an in-memory transport that records captures instead of
+// sending them. Pass it to agent.WithTransport to assert on captures:
+//
+//	tr := agenttest.NewTransport()
+//	agent.Init(agent.WithTransport(tr))
+//	t.Cleanup(agenttest.Reset)
+//	...
+//	if got := tr.Captures(); len(got) != 1 { ... }
+type Transport struct {
+	mu           sync.Mutex
+	captures     []*capture.ExceptionCapture
+	disconnected bool
+}
+
+// NewTransport creates an empty in-memory transport.
+func NewTransport() *Transport {
+	return &Transport{}
+}
+
+// SendException records a capture.
+func (t *Transport) SendException(exc *capture.ExceptionCapture) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.captures = append(t.captures, exc)
+}
+
+// IsConnected returns true until the transport is disconnected.
+func (t *Transport) IsConnected() bool {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	return !t.disconnected
+}
+
+// Disconnect marks the transport as disconnected. Captures are still
+// recorded afterwards.
+func (t *Transport) Disconnect() {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.disconnected = true
+}
+
+// Captures returns the captures recorded so far, oldest first.
+func (t *Transport) Captures() []*capture.ExceptionCapture {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	return append([]*capture.ExceptionCapture(nil), t.captures...)
+}
+
+// Clear discards the recorded captures.
+func (t *Transport) Clear() {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.captures = nil
+}
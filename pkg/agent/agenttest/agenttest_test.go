@@ -0,0 +1,57 @@
+package agenttest_test
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/aivorynet/agent-go/pkg/agent"
+	"github.com/aivorynet/agent-go/pkg/agent/agenttest"
+)
+
+func initAgent(env string) *agenttest.Transport {
+	tr := agenttest.NewTransport()
+	agent.Init(
+		agent.WithTransport(tr),
+		agent.WithEnabled(true),
+		agent.WithEnvironment(env),
+		agent.WithLogger(agent.LoggerFunc(func(level, msg string) {})),
+	)
+	return tr
+}
+
+func TestResetAllowsInitAgain(t *testing.T) {
+	t.Cleanup(agenttest.Reset)
+
+	first := initAgent("first")
+	agent.CaptureError(errors.New("one"))
+
+	agenttest.Reset()
+	if first.IsConnected() {
+		t.Error("Reset did not stop the previous agent")
+	}
+
+	second := initAgent("second")
+	agent.CaptureError(errors.New("two"))
+
+	if got := len(first.Captures()); got != 1 {
+		t.Errorf("first transport has %d captures, want 1", got)
+	}
+	if c := second.Captures(); len(c) != 1 || c[0].Environment != "second" {
+		t.Errorf("second transport captures = %v, want one from the new agent", c)
+	}
+}
+
+func TestResetWithoutAgent(t *testing.T) {
+	agenttest.Reset()
+	agenttest.Reset()
+	agent.CaptureError(errors.New("ignored"))
+}
+
+func TestTransportClear(t *testing.T) {
+	tr := agenttest.NewTransport()
+	tr.SendException(nil)
+	tr.Clear()
+	if got := len(tr.Captures()); got != 0 {
+		t.Errorf("%d captures after Clear", got)
+	}
+}
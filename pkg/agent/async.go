@@ -0,0 +1,159 @@
+package agent
+
+import (
+	"encoding/json"
+	"maps"
+	"slices"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/aivorynet/agent-go/pkg/capture"
+)
+
+// asyncQueueSize bounds the number of events waiting for an async worker.
+const asyncQueueSize = 1000
+
+// workerPool captures events on background goroutines; see
+// WithAsyncWorkers.
+type workerPool struct {
+	size    int
+	process func(*event)
+	jobs    chan *event
+
+	// pending counts events queued or being captured; dropped counts
+	// events dropped because the queue was full.
+	pending atomic.Int64
+	dropped atomic.Int64
+
+	mu   sync.Mutex
+	quit chan struct{}
+	wg   sync.WaitGroup
+}
+
+func newWorkerPool(size int, process func(*event)) *workerPool {
+	if size <= 0 {
+		return nil
+	}
+	return &workerPool{
+		size:    size,
+		process: process,
+		jobs:    make(chan *event, asyncQueueSize),
+	}
+}
+
+// start starts the workers if they are not running.
+func (p *workerPool) start() {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	if p.quit != nil {
+		return
+	}
+	p.quit = make(chan struct{})
+	for i := 0; i < p.size; i++ {
+		p.wg.Add(1)
+		go p.run(p.quit)
+	}
+}
+
+// stop stops the workers once they have captured the queued events.
+func (p *workerPool) stop() {
+	p.mu.Lock()
+	quit := p.quit
+	p.quit = nil
+	p.mu.Unlock()
+
+	if quit == nil {
+		return
+	}
+	close(quit)
+	p.wg.Wait()
+}
+
+func (p *workerPool) run(quit chan struct{}) {
+	defer p.wg.Done()
+	for {
+		select {
+		case ev := <-p.jobs:
+			p.handle(ev)
+		case <-quit:
+			for {
+				select {
+				case ev := <-p.jobs:
+					p.handle(ev)
+				default:
+					return
+				}
+			}
+		}
+	}
+}
+
+func (p *workerPool) handle(ev *event) {
+	defer p.pending.Add(-1)
+	p.process(ev)
+}
+
+// submit queues an event and returns false if the queue is full or the
+// workers are stopped. It holds the lock stop takes, so no event is queued
+// once stop has begun, which no worker would capture.
+func (p *workerPool) submit(ev *event) bool {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	if p.quit == nil {
+		return false
+	}
+	p.pending.Add(1)
+	select {
+	case p.jobs <- ev:
+		return true
+	default:
+		p.pending.Add(-1)
+		p.dropped.Add(1)
+		return false
+	}
+}
+
+// wait blocks until all queued events have been captured or the timeout
+// elapses, and returns true if the queue was drained.
+func (p *workerPool) wait(timeout time.Duration) bool {
+	if p == nil {
+		return true
+	}
+	deadline := time.Now().Add(timeout)
+	for p.pending.Load() > 0 {
+		if time.Now().After(deadline) {
+			return false
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	return true
+}
+
+// captureAsync captures the stack and variables of ev, copies the maps of
+// ev, and queues ev for the async workers. Anything referring to values
+// of the caller is done here, as the caller may go on to modify them.
+func (a *Agent) captureAsync(ev *event) {
+	ev.captured = a.captureValues(ev)
+	encodeContext(ev.captured)
+	ev.tags = maps.Clone(ev.tags)
+	ev.fingerprint = slices.Clone(ev.fingerprint)
+
+	if !a.workers.submit(ev) && a.config.Debug {
+		a.log.Debugf("Async capture queue full or stopped, dropping capture")
+	}
+}
+
+// encodeContext replaces the context values of c, which are kept as the
+// caller passed them, with their JSON encoding, so that they are not read
+// on a worker while the caller changes them. Values that cannot be encoded
+// are kept, to fail the send as they would synchronously.
+func encodeContext(c *capture.ExceptionCapture) {
+	for k, v := range c.Context {
+		if data, err := json.Marshal(v); err == nil {
+			c.Context[k] = json.RawMessage(data)
+		}
+	}
+}
@@ -0,0 +1,63 @@
+package agent_test
+
+import (
+	"encoding/json"
+	"errors"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/aivorynet/agent-go/pkg/agent"
+)
+
+func TestAsyncWorkersReturnCapture(t *testing.T) {
+	a, tr := newTestAgent(t, agent.WithAsyncWorkers(2))
+
+	if c := a.CaptureErrorWithResult(errors.New("with result")); c == nil {
+		t.Error("CaptureErrorWithResult returned nil with async workers")
+	}
+	if c := a.CaptureErrorWithOptions(errors.New("with options"), agent.CaptureOptions{}); c == nil {
+		t.Error("CaptureErrorWithOptions returned nil with async workers")
+	}
+	if got := len(tr.Captures()); got != 2 {
+		t.Errorf("%d captures sent before returning, want 2", got)
+	}
+
+	a.CaptureError(errors.New("queued"))
+	if !a.Flush(time.Second) {
+		t.Fatal("Flush timed out")
+	}
+	if got := len(tr.Captures()); got != 3 {
+		t.Errorf("%d captures sent after Flush, want 3", got)
+	}
+}
+
+func TestAsyncWorkersCaptureValuesAtCall(t *testing.T) {
+	a, tr := newTestAgent(t, agent.WithAsyncWorkers(1))
+
+	type order struct{ Status string }
+	o := &order{Status: "pending"}
+	items := map[string]int{"apple": 1}
+	a.CaptureError(errors.New("boom"), map[string]interface{}{"order": o, "items": items})
+
+	// The caller goes on to change the values it passed.
+	o.Status = "shipped"
+	items["pear"] = 2
+
+	if !a.Flush(time.Second) {
+		t.Fatal("Flush timed out")
+	}
+	c := tr.Captures()[0]
+	if got := c.LocalVariables["order"].Children["Status"].Value; got != "pending" {
+		t.Errorf("order.Status = %q, want the value at the call", got)
+	}
+	if got := len(c.LocalVariables["items"].Children); got != 1 {
+		t.Errorf("items has %d entries, want the 1 at the call", got)
+	}
+	if got := string(c.Context["order"].(json.RawMessage)); got != `{"Status":"pending"}` {
+		t.Errorf("context order = %s, want the value at the call", got)
+	}
+	if top := c.StackTrace[0].Function; !strings.HasSuffix(top, "TestAsyncWorkersCaptureValuesAtCall") {
+		t.Errorf("top frame = %s, want the caller", top)
+	}
+}
@@ -0,0 +1,133 @@
+package agent
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	"github.com/aivorynet/agent-go/pkg/capture"
+)
+
+const defaultRequestBreadcrumbs = 50
+
+// breadcrumbTrail is a bounded, goroutine-safe list of breadcrumbs that
+// drops the oldest entry when full.
+type breadcrumbTrail struct {
+	mu    sync.Mutex
+	max   int
+	items []capture.Breadcrumb
+}
+
+func newBreadcrumbTrail(max int) *breadcrumbTrail {
+	return &breadcrumbTrail{max: max}
+}
+
+func (t *breadcrumbTrail) add(b capture.Breadcrumb) {
+	if t.max <= 0 {
+		return
+	}
+
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	if len(t.items) >= t.max {
+		copy(t.items, t.items[1:])
+		t.items = t.items[:len(t.items)-1]
+	}
+	t.items = append(t.items, b)
+}
+
+func (t *breadcrumbTrail) snapshot() []capture.Breadcrumb {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	if len(t.items) == 0 {
+		return nil
+	}
+	items := make([]capture.Breadcrumb, len(t.items))
+	copy(items, t.items)
+	return items
+}
+
+func newBreadcrumb(category, message string, data map[string]interface{}) capture.Breadcrumb {
+	return capture.Breadcrumb{
+		Timestamp: time.Now().UTC().Format(time.RFC3339Nano),
+		Category:  category,
+		Message:   message,
+		Data:      data,
+	}
+}
+
+// AddBreadcrumb records a breadcrumb in the agent's global trail. It is
+// attached to captures that are not made through a context carrying its
+// own trail.
+func (a *Agent) AddBreadcrumb(category, message string, data map[string]interface{}) {
+	if a.breadcrumbs != nil {
+		a.breadcrumbs.add(newBreadcrumb(category, message, data))
+	}
+}
+
+// globalBreadcrumbs returns a snapshot of the global breadcrumb trail.
+func (a *Agent) globalBreadcrumbs() []capture.Breadcrumb {
+	if a.breadcrumbs == nil {
+		return nil
+	}
+	return a.breadcrumbs.snapshot()
+}
+
+// ContextWithBreadcrumbs returns a context carrying an empty breadcrumb
+// trail. Install it once per request (e.g. in middleware) so breadcrumbs
+// added further down the call chain are visible to CaptureErrorCtx.
+func (a *Agent) ContextWithBreadcrumbs(ctx context.Context) context.Context {
+	return context.WithValue(ctx, breadcrumbsKey, newBreadcrumbTrail(a.config.MaxRequestBreadcrumbs))
+}
+
+// AddBreadcrumbCtx records a breadcrumb in the trail carried by ctx,
+// keeping it isolated from other requests. If ctx has no trail yet, one is
+// created and the returned context must be used from then on.
+func (a *Agent) AddBreadcrumbCtx(ctx context.Context, category, message string, data map[string]interface{}) context.Context {
+	return addBreadcrumbCtx(ctx, a.config.MaxRequestBreadcrumbs, newBreadcrumb(category, message, data))
+}
+
+func addBreadcrumbCtx(ctx context.Context, max int, b capture.Breadcrumb) context.Context {
+	trail, ok := ctx.Value(breadcrumbsKey).(*breadcrumbTrail)
+	if !ok {
+		trail = newBreadcrumbTrail(max)
+		ctx = context.WithValue(ctx, breadcrumbsKey, trail)
+	}
+	trail.add(b)
+	return ctx
+}
+
+// contextBreadcrumbs returns the breadcrumbs carried by ctx.
+func contextBreadcrumbs(ctx context.Context) ([]capture.Breadcrumb, bool) {
+	trail, ok := ctx.Value(breadcrumbsKey).(*breadcrumbTrail)
+	if !ok {
+		return nil, false
+	}
+	return trail.snapshot(), true
+}
+
+// AddBreadcrumb records a breadcrumb using the global agent.
+func AddBreadcrumb(category, message string, data map[string]interface{}) {
+	if globalAgent != nil {
+		globalAgent.AddBreadcrumb(category, message, data)
+	}
+}
+
+// ContextWithBreadcrumbs returns a context carrying an empty breadcrumb
+// trail, sized by the global agent's configuration.
+func ContextWithBreadcrumbs(ctx context.Context) context.Context {
+	if globalAgent != nil {
+		return globalAgent.ContextWithBreadcrumbs(ctx)
+	}
+	return context.WithValue(ctx, breadcrumbsKey, newBreadcrumbTrail(defaultRequestBreadcrumbs))
+}
+
+// AddBreadcrumbCtx records a breadcrumb in the trail carried by ctx.
+func AddBreadcrumbCtx(ctx context.Context, category, message string, data map[string]interface{}) context.Context {
+	if globalAgent != nil {
+		return globalAgent.AddBreadcrumbCtx(ctx, category, message, data)
+	}
+	return addBreadcrumbCtx(ctx, defaultRequestBreadcrumbs, newBreadcrumb(category, message, data))
+}
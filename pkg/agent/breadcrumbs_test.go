@@ -0,0 +1,50 @@
+package agent_test
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+	"sync"
+	"testing"
+)
+
+func TestBreadcrumbsPerRequest(t *testing.T) {
+	a, tr := newTestAgent(t)
+	a.AddBreadcrumb("global", "startup", nil)
+
+	var wg sync.WaitGroup
+	for _, request := range []string{"a", "b"} {
+		wg.Add(1)
+		go func(request string) {
+			defer wg.Done()
+			ctx := a.ContextWithBreadcrumbs(context.Background())
+			for i := 0; i < 20; i++ {
+				a.AddBreadcrumbCtx(ctx, "step", fmt.Sprintf("%s-%d", request, i), nil)
+			}
+			a.CaptureErrorCtx(ctx, errors.New(request))
+		}(request)
+	}
+	wg.Wait()
+
+	captures := tr.Captures()
+	if len(captures) != 2 {
+		t.Fatalf("got %d captures, want 2", len(captures))
+	}
+	for _, c := range captures {
+		if len(c.Breadcrumbs) == 0 {
+			t.Errorf("capture %s has no breadcrumbs", c.Message)
+		}
+		for _, b := range c.Breadcrumbs {
+			if !strings.HasPrefix(b.Message, c.Message+"-") {
+				t.Errorf("capture %s carries breadcrumb %q", c.Message, b.Message)
+			}
+		}
+	}
+
+	a.CaptureError(errors.New("outside a request"))
+	captures = tr.Captures()
+	if last := captures[len(captures)-1]; len(last.Breadcrumbs) != 1 || last.Breadcrumbs[0].Message != "startup" {
+		t.Errorf("breadcrumbs outside a request = %+v, want the global trail", last.Breadcrumbs)
+	}
+}
@@ -0,0 +1,135 @@
+package agent
+
+import (
+	"path"
+	"runtime"
+	"runtime/debug"
+	"sort"
+	"strings"
+
+	"github.com/aivorynet/agent-go/pkg/capture"
+)
+
+// Build metadata stamped at link time. When set, these take precedence
+// over the information embedded by the Go toolchain. For example:
+//
+//	go build -ldflags "\
+//	  -X github.com/aivorynet/agent-go/pkg/agent.BuildVersion=1.4.2 \
+//	  -X github.com/aivorynet/agent-go/pkg/agent.BuildCommit=$(git rev-parse HEAD) \
+//	  -X github.com/aivorynet/agent-go/pkg/agent.BuildTime=$(date -u +%Y-%m-%dT%H:%M:%SZ)"
+var (
+	BuildVersion string
+	BuildCommit  string
+	BuildTime    string
+)
+
+// maxBuildDependencies caps the dependencies listed in the build info.
+const maxBuildDependencies = 50
+
+// readBuildInfo returns the build metadata, preferring the ldflags
+// variables and falling back to the embedded build info. It returns nil if
+// there is none, e.g. for a binary built without module support.
+func readBuildInfo() *capture.BuildInfo {
+	info := &capture.BuildInfo{
+		Version: BuildVersion,
+		Commit:  BuildCommit,
+		Time:    BuildTime,
+	}
+
+	if bi, ok := debug.ReadBuildInfo(); ok {
+		if info.Version == "" && bi.Main.Version != "" && bi.Main.Version != "(devel)" {
+			info.Version = bi.Main.Version
+		}
+		// go run with file arguments builds a synthetic main module.
+		if bi.Main.Path != "command-line-arguments" {
+			info.ModulePath = bi.Main.Path
+		}
+		info.GoVersion = bi.GoVersion
+		for _, setting := range bi.Settings {
+			switch setting.Key {
+			case "vcs":
+				info.VCS = setting.Value
+			case "vcs.revision":
+				if info.Commit == "" {
+					info.Commit = setting.Value
+				}
+			case "vcs.time":
+				if info.Time == "" {
+					info.Time = setting.Value
+				}
+			case "vcs.modified":
+				info.Modified = setting.Value == "true"
+			}
+		}
+		info.Dependencies, info.OmittedDependencies = buildDependencies(bi.Deps)
+	}
+
+	if info.Version == "" && info.Commit == "" && info.Time == "" && info.GoVersion == "" {
+		return nil
+	}
+	return info
+}
+
+// buildDependencies lists deps sorted by path, capped at
+// maxBuildDependencies, and returns the number left out.
+func buildDependencies(deps []*debug.Module) ([]capture.ModuleInfo, int) {
+	modules := make([]capture.ModuleInfo, 0, len(deps))
+	for _, dep := range deps {
+		if dep == nil {
+			continue
+		}
+		module := capture.ModuleInfo{Path: dep.Path, Version: dep.Version}
+		if dep.Replace != nil {
+			module.Replace = dep.Replace.Path
+			module.Version = dep.Replace.Version
+		}
+		modules = append(modules, module)
+	}
+	sort.Slice(modules, func(i, j int) bool { return modules[i].Path < modules[j].Path })
+
+	if len(modules) > maxBuildDependencies {
+		return modules[:maxBuildDependencies], len(modules) - maxBuildDependencies
+	}
+	return modules, 0
+}
+
+// detectModuleRoot returns the directory the main module was built from,
+// found from the first frame of a main module package on the calling
+// goroutine's stack, or "" if there is none or the binary was built with
+// -trimpath.
+func detectModuleRoot() string {
+	bi, ok := debug.ReadBuildInfo()
+	if !ok || bi.Main.Path == "" || bi.Main.Path == "command-line-arguments" {
+		return ""
+	}
+
+	pcs := make([]uintptr, 64)
+	frames := runtime.CallersFrames(pcs[:runtime.Callers(2, pcs)])
+	for more := true; more; {
+		var frame runtime.Frame
+		frame, more = frames.Next()
+
+		pkg := functionPackage(frame.Function)
+		if pkg == "main" {
+			pkg = bi.Path
+		}
+		rel, ok := strings.CutPrefix(pkg, bi.Main.Path)
+		if !ok || (rel != "" && rel[0] != '/') || !path.IsAbs(frame.File) {
+			continue
+		}
+		if dir := path.Dir(frame.File); strings.HasSuffix(dir, rel) {
+			return strings.TrimSuffix(dir, rel)
+		}
+	}
+	return ""
+}
+
+// functionPackage returns the import path of the package a function, as
+// named by the runtime, belongs to.
+func functionPackage(function string) string {
+	slash := strings.LastIndex(function, "/")
+	if dot := strings.Index(function[slash+1:], "."); dot >= 0 {
+		return function[:slash+1+dot]
+	}
+	return function
+}
@@ -0,0 +1,35 @@
+package agent_test
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/aivorynet/agent-go/pkg/agent"
+)
+
+func TestBuildVariablesInCaptures(t *testing.T) {
+	defer func(version, commit, built string) {
+		agent.BuildVersion, agent.BuildCommit, agent.BuildTime = version, commit, built
+	}(agent.BuildVersion, agent.BuildCommit, agent.BuildTime)
+	agent.BuildVersion = "1.4.2"
+	agent.BuildCommit = "0123456789abcdef0123456789abcdef01234567"
+	agent.BuildTime = "2026-01-02T03:04:05Z"
+
+	a, tr := newTestAgent(t)
+	a.CaptureError(errors.New("boom"))
+
+	captures := tr.Captures()
+	if len(captures) != 1 {
+		t.Fatalf("got %d captures, want 1", len(captures))
+	}
+	build := captures[0].Build
+	if build == nil {
+		t.Fatal("capture has no build info")
+	}
+	if build.Version != agent.BuildVersion || build.Commit != agent.BuildCommit || build.Time != agent.BuildTime {
+		t.Errorf("build = %+v, want the ldflags variables", build)
+	}
+	if captures[0].Release != agent.BuildVersion {
+		t.Errorf("Release = %q, want the build version %q", captures[0].Release, agent.BuildVersion)
+	}
+}
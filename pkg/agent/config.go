@@ -3,12 +3,19 @@ package agent
 
 import (
 	"crypto/rand"
+	"crypto/tls"
+	"encoding/binary"
 	"encoding/hex"
 	"fmt"
+	"net/http"
 	"os"
 	"runtime"
 	"strconv"
 	"time"
+
+	"github.com/aivorynet/agent-go/pkg/capture"
+	"github.com/aivorynet/agent-go/pkg/internal/logging"
+	"github.com/aivorynet/agent-go/pkg/transport"
 )
 
 // Config holds the agent configuration.
@@ -16,14 +23,227 @@ type Config struct {
 	APIKey            string
 	BackendURL        string
 	Environment       string
+	Release           string
 	SamplingRate      float64
 	MaxCaptureDepth   int
 	MaxStringLength   int
 	MaxCollectionSize int
+	MaxLocalVariables int
+	MaxBreadcrumbs    int
+	MaxStructFields   int
 	Debug             bool
+	Enabled           bool
 	EnableBreakpoints bool
 	Hostname          string
 	AgentID           string
+
+	// DisabledEnvironments lists environments in which the agent is
+	// disabled unless enabled explicitly, as it is in CI.
+	DisabledEnvironments []string
+
+	// enabledSet records that Enabled was set explicitly, with WithEnabled
+	// or AIVORY_ENABLED, so it is not overridden by DisabledEnvironments
+	// or CI detection; disabledReason explains an automatic disable.
+	enabledSet     bool
+	disabledReason string
+
+	// SamplingByLevel overrides SamplingRate for the given levels.
+	SamplingByLevel map[Level]float64
+
+	// MaxRequestBreadcrumbs bounds the breadcrumb trail stored in a
+	// context.Context by AddBreadcrumbCtx.
+	MaxRequestBreadcrumbs int
+
+	// RedactKeys are the variable, map key, struct field, flag and log
+	// line key names whose values are redacted. Defaults to
+	// capture.DefaultRedactKeys.
+	RedactKeys []string
+
+	// RedactFunc is called for every captured scalar value after the
+	// built-in rules and may replace it.
+	RedactFunc func(name, typ, value string) (string, bool)
+
+	// UseJSONMarshaler captures values implementing json.Marshaler from
+	// their marshaled JSON.
+	UseJSONMarshaler bool
+
+	// RespectJSONTags captures struct fields according to their json tags.
+	RespectJSONTags bool
+
+	// TopFrameSource is the number of top in-app frames that carry source
+	// snippets. Zero disables source capture.
+	TopFrameSource int
+
+	// CaptureSource attaches source snippets to all frames with source.
+	CaptureSource bool
+
+	// SendTimeout bounds each WebSocket message write. Zero disables it.
+	SendTimeout time.Duration
+
+	// Compression gzip-compresses large messages to the backend.
+	Compression bool
+
+	// ReliableDelivery re-sends exceptions until the backend acknowledges
+	// them.
+	ReliableDelivery bool
+
+	// MaxMessageBytes bounds the size of a single message to the backend.
+	// Zero disables the limit.
+	MaxMessageBytes int
+
+	// HandshakeHeaders are added to the WebSocket upgrade request.
+	HandshakeHeaders http.Header
+
+	// DiskQueueDir, if set, spools undeliverable exceptions to files in
+	// this directory, bounded by DiskQueueMaxBytes.
+	DiskQueueDir      string
+	DiskQueueMaxBytes int64
+
+	// FileSinkPath, if set, writes every capture as a JSON line to this
+	// file, rotated at FileSinkMaxBytes.
+	FileSinkPath     string
+	FileSinkMaxBytes int64
+
+	// OfflineBuffer is the number of exceptions held in memory while the
+	// agent is not connected, when there is no disk queue. Zero or less
+	// drops them.
+	OfflineBuffer int
+
+	// ReconnectDelay, MaxReconnectDelay and MaxReconnectAttempts control
+	// reconnection; MaxReconnectAttempts <= 0 retries forever.
+	ReconnectDelay       time.Duration
+	MaxReconnectDelay    time.Duration
+	MaxReconnectAttempts int
+
+	// HeartbeatInterval is the interval between heartbeats; ReadTimeout
+	// is how long to wait for data from the backend before reconnecting.
+	// A zero ReadTimeout defaults to twice HeartbeatInterval.
+	HeartbeatInterval time.Duration
+	ReadTimeout       time.Duration
+
+	// TLSConfig is used to dial the backend; InsecureSkipVerify disables
+	// certificate verification.
+	TLSConfig          *tls.Config
+	InsecureSkipVerify bool
+
+	// ConnectionStateHandler is called on every connection state change.
+	ConnectionStateHandler func(ConnectionState)
+
+	// BatchInterval and BatchSize enable sending exceptions in batches.
+	BatchInterval time.Duration
+	BatchSize     int
+
+	// IDGenerator generates capture IDs. Defaults to UUID v4.
+	IDGenerator func() string
+
+	// MaxRecentLogLines and MaxRecentLogBytes bound the log lines kept by
+	// LogWriter and attached to captures.
+	MaxRecentLogLines int
+	MaxRecentLogBytes int
+
+	// CaptureProcessContext attaches os.Args, the working directory and
+	// the effective UID to the registration and every capture.
+	CaptureProcessContext bool
+
+	// CaptureMemStats attaches a runtime.MemStats snapshot to every
+	// capture.
+	CaptureMemStats bool
+
+	// MaxErrorChainDepth caps how many errors of the Unwrap chain have
+	// their fields extracted.
+	MaxErrorChainDepth int
+
+	// CaptureAllGoroutines attaches the stacks of all goroutines to each
+	// capture.
+	CaptureAllGoroutines bool
+
+	// FingerprintMode selects how captures are grouped; Fingerprinter is
+	// used in FingerprintCustom mode.
+	FingerprintMode FingerprintMode
+	Fingerprinter   capture.Fingerprinter
+
+	// StableFingerprint leaves line numbers out of stack fingerprints.
+	StableFingerprint bool
+
+	// CaptureUnexported captures unexported struct fields.
+	CaptureUnexported bool
+
+	// MaxStackFrames caps the frames of a stack trace; zero uses the
+	// default of 50. IncludeRuntimeFrames keeps Go runtime frames.
+	MaxStackFrames       int
+	IncludeRuntimeFrames bool
+
+	// MaxPayloadBytes, if positive, caps the encoded size of captures.
+	MaxPayloadBytes int
+
+	// TrimPathPrefix is stripped from stack frame file paths. Empty
+	// detects the main module's directory.
+	TrimPathPrefix string
+
+	// PanicSendTimeout bounds how long a panic that is re-raised waits for
+	// its capture to be delivered.
+	PanicSendTimeout time.Duration
+
+	// PrioritySendTimeout, if positive, sends the first capture and fatal
+	// captures ahead of the queue and waits up to this long for them.
+	PrioritySendTimeout time.Duration
+
+	// SentryDSN, if set, sends captures to a Sentry-compatible endpoint
+	// instead of the AIVory backend.
+	SentryDSN string
+
+	// GoRepanic re-panics panics recovered by Go after capturing them.
+	GoRepanic bool
+
+	// ManageSignals stops the agent on SIGINT and SIGTERM, or the console
+	// control events on Windows.
+	ManageSignals bool
+
+	// Logger, if set, receives the agent's log lines instead of the
+	// standard logger.
+	Logger Logger
+
+	// ExplodeJoinedErrors captures each error of an errors.Join
+	// separately.
+	ExplodeJoinedErrors bool
+
+	// Tags are attached to every capture; see Agent.SetTags.
+	Tags map[string]string
+
+	// KnownErrors names sentinel errors; captured errors matching them
+	// with errors.Is list the names in MatchedSentinels.
+	KnownErrors map[string]error
+
+	// Transport, if set, replaces the WebSocket connection to the backend.
+	Transport Transport
+
+	// ContextKeys are the context.Context keys whose values
+	// CaptureErrorCtx adds to the capture context.
+	ContextKeys []interface{}
+
+	// HTTPHeaders lists the request headers HTTPMiddleware includes in
+	// captures. Nil includes all headers.
+	HTTPHeaders []string
+
+	// DedupWindow collapses captures with the same fingerprint repeated
+	// within the window. Zero disables deduplication.
+	DedupWindow time.Duration
+
+	// FingerprintLimit, if positive, limits the captures sent per
+	// fingerprint to FingerprintLimit per FingerprintLimitWindow.
+	FingerprintLimit       int
+	FingerprintLimitWindow time.Duration
+
+	// AsyncWorkers, if positive, is the number of goroutines captures
+	// are made on instead of the caller's goroutine.
+	AsyncWorkers int
+
+	// Sampler, if set, decides whether a finished capture is sent.
+	Sampler Sampler
+
+	// BeforeSend, if set, is called with each capture right before it is
+	// sent and may modify or replace it, or drop it by returning nil.
+	BeforeSend func(*capture.ExceptionCapture) *capture.ExceptionCapture
 }
 
 // NewConfig creates a new configuration with defaults from environment variables.
@@ -32,14 +252,37 @@ func NewConfig(options ...ConfigOption) *Config {
 		APIKey:            getEnvOrDefault("AIVORY_API_KEY", ""),
 		BackendURL:        getEnvOrDefault("AIVORY_BACKEND_URL", "wss://api.aivory.net/monitor/agent"),
 		Environment:       getEnvOrDefault("AIVORY_ENVIRONMENT", "production"),
+		Release:           getEnvOrDefault("AIVORY_RELEASE", ""),
 		SamplingRate:      getEnvFloatOrDefault("AIVORY_SAMPLING_RATE", 1.0),
 		MaxCaptureDepth:   getEnvIntOrDefault("AIVORY_MAX_DEPTH", 10),
 		MaxStringLength:   getEnvIntOrDefault("AIVORY_MAX_STRING_LENGTH", 1000),
 		MaxCollectionSize: getEnvIntOrDefault("AIVORY_MAX_COLLECTION_SIZE", 100),
+		MaxLocalVariables: getEnvIntOrDefault("AIVORY_MAX_LOCAL_VARIABLES", 0),
+		MaxBreadcrumbs:    getEnvIntOrDefault("AIVORY_MAX_BREADCRUMBS", 100),
+		MaxStructFields:   getEnvIntOrDefault("AIVORY_MAX_STRUCT_FIELDS", 100),
 		Debug:             getEnvOrDefault("AIVORY_DEBUG", "false") == "true",
+		Enabled:           getEnvOrDefault("AIVORY_ENABLED", "true") == "true",
 		EnableBreakpoints: getEnvOrDefault("AIVORY_ENABLE_BREAKPOINTS", "true") == "true",
+
+		MaxRequestBreadcrumbs: defaultRequestBreadcrumbs,
+		RedactKeys:            capture.DefaultRedactKeys,
+		SendTimeout:           10 * time.Second,
+		DedupWindow:           5 * time.Second,
+		ReconnectDelay:        time.Second,
+		MaxReconnectDelay:     60 * time.Second,
+		MaxReconnectAttempts:  10,
+		HeartbeatInterval:     30 * time.Second,
+		MaxRecentLogLines:     50,
+		MaxRecentLogBytes:     8 * 1024,
+		MaxErrorChainDepth:    10,
+		OfflineBuffer:         100,
+		FileSinkMaxBytes:      10 << 20,
+		PanicSendTimeout:      2 * time.Second,
 	}
 
+	cfg.enabledSet = os.Getenv("AIVORY_ENABLED") != ""
+	cfg.DisabledEnvironments = splitList(os.Getenv("AIVORY_DISABLED_ENVIRONMENTS"))
+
 	// Generate hostname
 	hostname, err := os.Hostname()
 	if err != nil {
@@ -55,9 +298,68 @@ func NewConfig(options ...ConfigOption) *Config {
 		opt(cfg)
 	}
 
+	if cfg.Enabled && !cfg.enabledSet {
+		if cfg.disabledReason = cfg.autoDisableReason(); cfg.disabledReason != "" {
+			cfg.Enabled = false
+		}
+	}
+
+	// Accept http(s) URLs, a common mistake, by upgrading them to ws(s).
+	// Invalid URLs are reported by Validate.
+	if normalized, err := transport.NormalizeURL(cfg.BackendURL); err == nil && normalized != cfg.BackendURL {
+		if cfg.Debug {
+			cfg.logger().Debugf("Backend URL %s is not a WebSocket URL, using %s", cfg.BackendURL, normalized)
+		}
+		cfg.BackendURL = normalized
+	}
+
 	return cfg
 }
 
+// fileSinkOnly returns true if captures go to the file sink only, because
+// there is no API key, custom transport or Sentry DSN.
+func (c *Config) fileSinkOnly() bool {
+	return c.FileSinkPath != "" && c.APIKey == "" && c.Transport == nil && c.SentryDSN == ""
+}
+
+// logger returns a Logger writing to the configured Logger.
+func (c *Config) logger() *logging.Logger {
+	return logging.New(c.Logger)
+}
+
+// Validate returns an error describing the first setting that keeps the
+// agent from working. The API key and backend URL are only checked when
+// the agent connects to the AIVory backend rather than a custom transport
+// or Sentry, and the API key may be left out when captures go to a file
+// sink only.
+func (c *Config) Validate() error {
+	if c.Transport == nil && c.SentryDSN == "" && !c.fileSinkOnly() {
+		if c.APIKey == "" {
+			return fmt.Errorf("API key is required: set AIVORY_API_KEY or use the WithAPIKey option")
+		}
+		if _, err := transport.NormalizeURL(c.BackendURL); err != nil {
+			return err
+		}
+	}
+	if err := validateRate(c.SamplingRate); err != nil {
+		return err
+	}
+	for level, rate := range c.SamplingByLevel {
+		if err := validateRate(rate); err != nil {
+			return fmt.Errorf("%s level: %v", level, err)
+		}
+	}
+	return nil
+}
+
+// validateRate returns an error if a sampling rate is outside [0, 1].
+func validateRate(rate float64) error {
+	if rate < 0 || rate > 1 {
+		return fmt.Errorf("invalid sampling rate %v: must be between 0 and 1", rate)
+	}
+	return nil
+}
+
 // ConfigOption is a function that modifies Config.
 type ConfigOption func(*Config)
 
@@ -82,6 +384,16 @@ func WithEnvironment(env string) ConfigOption {
 	}
 }
 
+// WithRelease sets the application release attached to every capture, to
+// correlate errors with deploys. Defaults to the AIVORY_RELEASE environment
+// variable, then to the build version (see BuildVersion), which for
+// binaries built from a module at a tagged version is the module version.
+func WithRelease(release string) ConfigOption {
+	return func(c *Config) {
+		c.Release = release
+	}
+}
+
 // WithSamplingRate sets the sampling rate.
 func WithSamplingRate(rate float64) ConfigOption {
 	return func(c *Config) {
@@ -89,6 +401,593 @@ func WithSamplingRate(rate float64) ConfigOption {
 	}
 }
 
+// WithMaxLocalVariables caps the number of top-level local variables
+// captured per exception. Zero means no limit.
+func WithMaxLocalVariables(n int) ConfigOption {
+	return func(c *Config) {
+		c.MaxLocalVariables = n
+	}
+}
+
+// WithMaxBreadcrumbs sets how many global breadcrumbs are kept.
+func WithMaxBreadcrumbs(n int) ConfigOption {
+	return func(c *Config) {
+		c.MaxBreadcrumbs = n
+	}
+}
+
+// WithBufferedBreadcrumbsPerRequest sets how many breadcrumbs are kept in
+// each context-scoped trail created by AddBreadcrumbCtx.
+func WithBufferedBreadcrumbsPerRequest(n int) ConfigOption {
+	return func(c *Config) {
+		c.MaxRequestBreadcrumbs = n
+	}
+}
+
+// WithMaxStructFields caps the number of exported fields captured per
+// struct, for both captured values and error fields.
+func WithMaxStructFields(n int) ConfigOption {
+	return func(c *Config) {
+		c.MaxStructFields = n
+	}
+}
+
+// WithMaxErrorChainDepth caps how many errors of the Unwrap chain have
+// their fields extracted. Fields are named by chain position, e.g.
+// err.0.Code for the captured error and err.1.Code for the one it wraps.
+func WithMaxErrorChainDepth(n int) ConfigOption {
+	return func(c *Config) {
+		c.MaxErrorChainDepth = n
+	}
+}
+
+// WithRedactKeys replaces the default set of sensitive names ("password",
+// "secret", "token", "authorization", "api_key"). A variable, map key or
+// struct field whose name contains one of keys, ignoring case, is captured
+// as "[REDACTED]", as are the values of matching key=value and JSON pairs
+// in recent log lines. Pass an empty slice to disable name-based
+// redaction.
+func WithRedactKeys(keys []string) ConfigOption {
+	return func(c *Config) {
+		c.RedactKeys = keys
+	}
+}
+
+// WithRedactFunc sets a custom redaction function that is called for every
+// captured scalar value. It receives the variable name, type and rendered
+// value and returns the replacement value and whether to redact.
+func WithRedactFunc(fn func(name, typ, value string) (string, bool)) ConfigOption {
+	return func(c *Config) {
+		c.RedactFunc = fn
+	}
+}
+
+// WithJSONMarshaler enables capturing errors and values that implement
+// json.Marshaler from their marshaled JSON rather than by reflecting over
+// exported fields.
+func WithJSONMarshaler(enable bool) ConfigOption {
+	return func(c *Config) {
+		c.UseJSONMarshaler = enable
+	}
+}
+
+// WithRespectJSONTags captures structs the way they serialize to JSON:
+// fields tagged `json:"-"` are skipped, fields are named by their JSON
+// name, and values implementing json.Marshaler are captured from their
+// marshaled form. Use it to keep captures consistent with what is already
+// considered safe to serialize.
+func WithRespectJSONTags(enable bool) ConfigOption {
+	return func(c *Config) {
+		c.RespectJSONTags = enable
+	}
+}
+
+// WithTopFrameSource attaches a few lines of source code around the culprit
+// line to the top n in-app stack frames of each capture. Only those frames
+// are read from disk, which bounds the file I/O per capture.
+func WithTopFrameSource(n int) ConfigOption {
+	return func(c *Config) {
+		c.TopFrameSource = n
+	}
+}
+
+// WithCaptureSource attaches three lines of source code before and after
+// the culprit line to every stack frame whose source file is available,
+// including standard library frames. Each file is read at most once per
+// capture, and files that no longer exist are skipped. It takes precedence
+// over WithTopFrameSource, which limits the cost to the top frames.
+func WithCaptureSource(enable bool) ConfigOption {
+	return func(c *Config) {
+		c.CaptureSource = enable
+	}
+}
+
+// WithSendTimeout bounds each message write to the backend. If a write
+// does not complete in time the connection is treated as dead and
+// re-established, so a stuck backend cannot stall all captures.
+func WithSendTimeout(d time.Duration) ConfigOption {
+	return func(c *Config) {
+		c.SendTimeout = d
+	}
+}
+
+// WithCompression gzip-compresses messages to the backend that are larger
+// than 1 KiB, such as captures with deep variable trees.
+func WithCompression(enable bool) ConfigOption {
+	return func(c *Config) {
+		c.Compression = enable
+	}
+}
+
+// WithReliableDelivery tags each exception sent to the backend with a
+// message ID and re-sends it after a reconnect, or after 30s on a live
+// connection, until the backend acknowledges it. Acknowledgements and
+// re-sends are counted in Stats.
+func WithReliableDelivery(enable bool) ConfigOption {
+	return func(c *Config) {
+		c.ReliableDelivery = enable
+	}
+}
+
+// WithMaxMessageBytes bounds the size of a single message to the backend,
+// measured before compression. A capture that would exceed it is sent as a
+// placeholder with the same ID and fingerprint, its message and top stack
+// frames, instead of risking the backend closing the connection.
+func WithMaxMessageBytes(n int) ConfigOption {
+	return func(c *Config) {
+		c.MaxMessageBytes = n
+	}
+}
+
+// WithHandshakeHeaders adds headers to the WebSocket upgrade request to
+// the backend, e.g. a tenant header required by an ingress. The
+// Authorization header always carries the API key and cannot be replaced.
+func WithHandshakeHeaders(h http.Header) ConfigOption {
+	return func(c *Config) {
+		c.HandshakeHeaders = h
+	}
+}
+
+// WithDiskQueue spools exceptions that cannot be delivered, e.g. because
+// the backend is unreachable, to JSON files in dir. They are replayed
+// oldest first once the agent connects, also after a restart, and the
+// oldest files are evicted to keep the spool under maxBytes. With
+// WithReliableDelivery, a replayed file is kept until the backend
+// acknowledges its message, so it is not lost to a restart meanwhile.
+func WithDiskQueue(dir string, maxBytes int64) ConfigOption {
+	return func(c *Config) {
+		c.DiskQueueDir = dir
+		c.DiskQueueMaxBytes = maxBytes
+	}
+}
+
+// WithFileSink writes every capture to the file at path as a line of JSON,
+// for inspecting captures locally. Without an API key, custom transport or
+// Sentry DSN the file is the only destination, so the agent runs without a
+// backend; otherwise captures are written to the file as well. The file is
+// rotated to path + ".1" at 10 MiB; see WithFileSinkMaxBytes.
+func WithFileSink(path string) ConfigOption {
+	return func(c *Config) {
+		c.FileSinkPath = path
+	}
+}
+
+// WithFileSinkMaxBytes sets the size at which the file of WithFileSink is
+// rotated. Zero or less disables rotation.
+func WithFileSinkMaxBytes(n int64) ConfigOption {
+	return func(c *Config) {
+		c.FileSinkMaxBytes = n
+	}
+}
+
+// WithOfflineBuffer sets how many exceptions are held in memory while the
+// agent is not connected to the backend, e.g. before the first connection
+// is established or during a reconnect. They are sent in capture order
+// once the agent is registered; the oldest is dropped when the buffer is
+// full. A disk queue set with WithDiskQueue takes precedence. Zero or less
+// drops such exceptions. The default is 100.
+func WithOfflineBuffer(n int) ConfigOption {
+	return func(c *Config) {
+		c.OfflineBuffer = n
+	}
+}
+
+// WithReconnect configures reconnection to the backend: the delay before
+// the first retry, doubling after each failed attempt up to max, and the
+// number of attempts before giving up. maxAttempts <= 0 retries forever,
+// which suits long-running servers. Delays are randomized by up to half so
+// agents do not reconnect in lockstep after a backend restart.
+func WithReconnect(base, max time.Duration, maxAttempts int) ConfigOption {
+	return func(c *Config) {
+		c.ReconnectDelay = base
+		c.MaxReconnectDelay = max
+		c.MaxReconnectAttempts = maxAttempts
+	}
+}
+
+// WithHeartbeatInterval sets the interval at which heartbeats and pings
+// are sent to the backend. Defaults to 30s.
+func WithHeartbeatInterval(d time.Duration) ConfigOption {
+	return func(c *Config) {
+		c.HeartbeatInterval = d
+	}
+}
+
+// WithReadTimeout sets how long the agent waits for any data or pong from
+// the backend before treating the connection as dead and reconnecting.
+// Defaults to twice the heartbeat interval.
+func WithReadTimeout(d time.Duration) ConfigOption {
+	return func(c *Config) {
+		c.ReadTimeout = d
+	}
+}
+
+// WithTLSConfig sets the TLS configuration used to connect to the backend,
+// e.g. with RootCAs holding the internal CA of a self-hosted backend.
+func WithTLSConfig(config *tls.Config) ConfigOption {
+	return func(c *Config) {
+		c.TLSConfig = config
+	}
+}
+
+// WithInsecureSkipVerify disables verification of the backend's TLS
+// certificate. This is for development only; it leaves the connection
+// open to interception.
+func WithInsecureSkipVerify(skip bool) ConfigOption {
+	return func(c *Config) {
+		c.InsecureSkipVerify = skip
+	}
+}
+
+// WithConnectionStateHandler sets a function called whenever the
+// connection to the backend changes state, e.g. to report agent health.
+// It is called from the connection's goroutines and should return quickly.
+func WithConnectionStateHandler(fn func(ConnectionState)) ConfigOption {
+	return func(c *Config) {
+		c.ConnectionStateHandler = fn
+	}
+}
+
+// WithBatchInterval sends exceptions in batches, accumulating them for up
+// to d and then sending them as one message. Batching is off by default.
+func WithBatchInterval(d time.Duration) ConfigOption {
+	return func(c *Config) {
+		c.BatchInterval = d
+	}
+}
+
+// WithBatchSize sends exceptions in batches of up to n. Combined with
+// WithBatchInterval, whichever limit is reached first sends the batch.
+func WithBatchSize(n int) ConfigOption {
+	return func(c *Config) {
+		c.BatchSize = n
+	}
+}
+
+// WithIDGenerator replaces the UUID v4 generator used for capture IDs,
+// e.g. with a ULID generator or a deterministic counter in tests.
+func WithIDGenerator(fn func() string) ConfigOption {
+	return func(c *Config) {
+		c.IDGenerator = fn
+	}
+}
+
+// WithRecentLogLimits bounds the log lines kept by LogWriter by line count
+// and total bytes.
+func WithRecentLogLimits(lines, bytes int) ConfigOption {
+	return func(c *Config) {
+		c.MaxRecentLogLines = lines
+		c.MaxRecentLogBytes = bytes
+	}
+}
+
+// WithCaptureProcessContext attaches the command-line arguments, working
+// directory and effective UID to the registration and every capture.
+// Values of sensitive flags such as --password=... are redacted.
+func WithCaptureProcessContext() ConfigOption {
+	return func(c *Config) {
+		c.CaptureProcessContext = true
+	}
+}
+
+// WithCaptureMemStats attaches a snapshot of the Go memory statistics
+// (heap, stack, GC) to every capture. Reading them briefly stops the
+// world, so it is off by default. The host block (PID, executable,
+// container ID, start time and uptime) is attached regardless.
+func WithCaptureMemStats(enable bool) ConfigOption {
+	return func(c *Config) {
+		c.CaptureMemStats = enable
+	}
+}
+
+// WithContextKeys registers context.Context keys, such as those holding
+// request or trace IDs, whose values CaptureErrorCtx adds to the capture
+// context. Each value is stored under the key formatted with %v, so string
+// based key types give the most readable names.
+func WithContextKeys(keys []interface{}) ConfigOption {
+	return func(c *Config) {
+		c.ContextKeys = keys
+	}
+}
+
+// WithHTTPHeaders limits the request headers HTTPMiddleware includes in
+// captures to the given names, matched case-insensitively. By default all
+// headers are included. Sensitive headers such as Authorization are
+// redacted either way.
+func WithHTTPHeaders(headers ...string) ConfigOption {
+	return func(c *Config) {
+		c.HTTPHeaders = append([]string{}, headers...)
+	}
+}
+
+// WithDedupWindow sets the window within which captures with the same
+// fingerprint are collapsed: only the first is sent, and the next one sent
+// after the window reports the suppressed repeats in OccurrenceCount. The
+// default is 5 seconds; zero disables deduplication. Fatal captures and
+// those sent synchronously, e.g. for a crashing panic, are always sent.
+func WithDedupWindow(d time.Duration) ConfigOption {
+	return func(c *Config) {
+		c.DedupWindow = d
+	}
+}
+
+// WithPerFingerprintLimit limits the captures sent for each fingerprint to
+// n per window using a token bucket, so a frequent error cannot use up the
+// volume that rarer errors need. Captures over the limit are dropped, and
+// the next capture sent for the fingerprint reports them in
+// OccurrenceCount. The limit applies after deduplication and, like it,
+// not to fatal or synchronously sent captures; zero disables it, which is
+// the default.
+func WithPerFingerprintLimit(n int, window time.Duration) ConfigOption {
+	return func(c *Config) {
+		c.FingerprintLimit = n
+		c.FingerprintLimitWindow = window
+	}
+}
+
+// WithAsyncWorkers completes captures on a pool of n background goroutines
+// so that enriching them, deduplication, the before-send hook, payload
+// fitting and sending do not add to the caller's latency. The caller still
+// captures the stack and variables and encodes the context values, which
+// hooks then see as json.RawMessage, before returning, so they are read as
+// they are at the call and not while the caller goes on to change them.
+// Fatal captures, CaptureErrorWithResult and CaptureErrorWithOptions are
+// made synchronously. When the queue is full, captures are dropped and
+// counted in Stats. Flush waits for queued captures. Zero, the default,
+// captures synchronously.
+func WithAsyncWorkers(n int) ConfigOption {
+	return func(c *Config) {
+		c.AsyncWorkers = n
+	}
+}
+
+// WithSampler sets a sampler that decides whether each finished capture is
+// sent. It runs after level sampling, once the fingerprint is known.
+func WithSampler(s Sampler) ConfigOption {
+	return func(c *Config) {
+		c.Sampler = s
+	}
+}
+
+// WithCaptureAllGoroutines attaches the stacks of all goroutines, up to
+// 200, to each capture, which helps to debug deadlocks and goroutine
+// leaks. Dumping all stacks briefly stops the world, so leave this off in
+// services that capture frequently.
+func WithCaptureAllGoroutines(enable bool) ConfigOption {
+	return func(c *Config) {
+		c.CaptureAllGoroutines = enable
+	}
+}
+
+// WithFingerprintMode selects how captures are grouped, e.g.
+// FingerprintTypeAndMessage for a network layer whose errors come from
+// many call sites. The default is FingerprintStackOnly.
+func WithFingerprintMode(mode FingerprintMode) ConfigOption {
+	return func(c *Config) {
+		c.FingerprintMode = mode
+	}
+}
+
+// WithStableFingerprint leaves line numbers out of FingerprintStackOnly
+// fingerprints, which then consist of the error type and the
+// package-qualified names of the top five functions. Grouping survives
+// edits that move code, at the cost of merging errors of the same type
+// raised at different lines of the same function. Closures are still told
+// apart by their generated names, such as handler.func1, which change
+// when closures are added or removed before them.
+func WithStableFingerprint(stable bool) ConfigOption {
+	return func(c *Config) {
+		c.StableFingerprint = stable
+	}
+}
+
+// WithCaptureUnexported captures unexported struct fields, including those
+// of custom errors, marking them IsUnexported. It reads them bypassing
+// Go's visibility rules, which may observe fields in the middle of a
+// concurrent write, so it is off by default. Function and unsafe pointer
+// fields are never read.
+func WithCaptureUnexported(capture bool) ConfigOption {
+	return func(c *Config) {
+		c.CaptureUnexported = capture
+	}
+}
+
+// WithMaxStackFrames caps the number of frames in a captured stack trace,
+// not counting runtime frames kept by WithIncludeRuntimeFrames. Defaults
+// to 50.
+func WithMaxStackFrames(n int) ConfigOption {
+	return func(c *Config) {
+		c.MaxStackFrames = n
+	}
+}
+
+// WithIncludeRuntimeFrames keeps the frames of the Go runtime in stack
+// traces, marked IsNative, e.g. to see that a panic was raised in
+// runtime.mapassign. They are dropped by default and never affect the
+// fingerprint.
+func WithIncludeRuntimeFrames(include bool) ConfigOption {
+	return func(c *Config) {
+		c.IncludeRuntimeFrames = include
+	}
+}
+
+// WithMaxPayloadBytes caps the JSON size of each capture at n bytes, so
+// large object graphs do not produce captures the backend rejects.
+// Variables are pruned to fit, keeping shallow values over deeply nested
+// ones and marking pruned values truncated; if the capture is still too
+// large, other goroutines' stacks, source snippets, recent logs,
+// breadcrumbs, variables, frames beyond the top ten and the context are
+// dropped in turn. Captures that cannot be made to fit are not sent.
+func WithMaxPayloadBytes(n int) ConfigOption {
+	return func(c *Config) {
+		c.MaxPayloadBytes = n
+	}
+}
+
+// WithTrimPathPrefix strips prefix from the file paths of stack frames, so
+// they are reported relative to it and build machine directories are not
+// sent to the backend. By default the directory the main module was built
+// from is detected from the stack when the agent starts; binaries built
+// with -trimpath need neither.
+func WithTrimPathPrefix(prefix string) ConfigOption {
+	return func(c *Config) {
+		c.TrimPathPrefix = prefix
+	}
+}
+
+// WithFingerprinter groups captures by the fingerprint fn returns and
+// selects FingerprintCustom mode.
+func WithFingerprinter(fn capture.Fingerprinter) ConfigOption {
+	return func(c *Config) {
+		c.FingerprintMode = FingerprintCustom
+		c.Fingerprinter = fn
+	}
+}
+
+// WithPrioritySend makes the first capture after startup, and every fatal
+// capture such as a panic, jump the send queue. If the agent is not
+// connected yet, a connection attempt is made immediately and the capturing
+// goroutine waits up to timeout for the capture to be written, so a startup
+// crash is not lost while the connection is still being set up. If the
+// timeout passes, the capture stays queued and the caller continues.
+func WithPrioritySend(timeout time.Duration) ConfigOption {
+	return func(c *Config) {
+		c.PrioritySendTimeout = timeout
+	}
+}
+
+// WithPanicSendTimeout sets how long a panic that is re-raised, by
+// CapturePanic, InstallPanicHandler, InstallGlobalHandler, Wrap or Go with
+// WithGoRepanic, waits for its capture to be delivered before the panic
+// continues and likely terminates the process. The default is 2s.
+func WithPanicSendTimeout(timeout time.Duration) ConfigOption {
+	return func(c *Config) {
+		c.PanicSendTimeout = timeout
+	}
+}
+
+// WithGoRepanic makes goroutines started with Go re-panic after a panic
+// has been captured and flushed, crashing the process as an unrecovered
+// panic would. By default the panic is swallowed and the goroutine ends.
+func WithGoRepanic(repanic bool) ConfigOption {
+	return func(c *Config) {
+		c.GoRepanic = repanic
+	}
+}
+
+// WithManageSignals makes the agent handle SIGINT and SIGTERM: it flushes
+// queued captures and stops. It does not terminate the process; once the
+// signals are handled, Go no longer exits on them, so the application must
+// receive them too, e.g. with its own signal.Notify, and exit. On Windows
+// it handles the console control events instead: CTRL+C and CTRL+BREAK,
+// and the close, logoff and shutdown events. It is off by default.
+//
+// Applications that handle signals themselves can leave it off and call
+// Flush and Shutdown in their own handler. Windows services must do so
+// when their svc.Handler receives svc.Stop or svc.Shutdown, as the service
+// control manager sends no console event.
+func WithManageSignals(manage bool) ConfigOption {
+	return func(c *Config) {
+		c.ManageSignals = manage
+	}
+}
+
+// WithLogger sends the agent's log lines to l instead of the standard
+// logger, including those of its connection and breakpoints. Use
+// LoggerFunc to log through a function, or NewStdLogger to write to a
+// *log.Logger. Repeated debug lines are collapsed either way: a line logged
+// with the same format within 30s of the last is suppressed, and the next
+// one logged reports how many were.
+func WithLogger(l Logger) ConfigOption {
+	return func(c *Config) {
+		c.Logger = l
+	}
+}
+
+// WithExplodeJoinedErrors captures each error joined with errors.Join (or
+// any error with an Unwrap() []error method) as a separate capture, so
+// alerting treats the failures independently. The captures share
+// breadcrumbs, context and tags, and record their position in the
+// joined_error_index context key. At most 10 errors are captured.
+func WithExplodeJoinedErrors(enable bool) ConfigOption {
+	return func(c *Config) {
+		c.ExplodeJoinedErrors = enable
+	}
+}
+
+// WithTags sets the tags attached to every capture, such as
+// service=checkout or region=eu-west. See Agent.SetTags for the rules on
+// keys and values.
+func WithTags(tags map[string]string) ConfigOption {
+	return func(c *Config) {
+		c.Tags = tags
+	}
+}
+
+// WithKnownErrors names sentinel errors such as sql.ErrNoRows or
+// context.DeadlineExceeded. A captured error that matches one or more of
+// them with errors.Is lists their names, sorted, in MatchedSentinels and,
+// separated by commas, in the "sentinel" tag, so captures can be grouped
+// by failure mode rather than by stack:
+//
+//	agent.WithKnownErrors(map[string]error{
+//		"no_rows":  sql.ErrNoRows,
+//		"deadline": context.DeadlineExceeded,
+//	})
+func WithKnownErrors(errs map[string]error) ConfigOption {
+	return func(c *Config) {
+		c.KnownErrors = errs
+	}
+}
+
+// WithTransport sends captures through t instead of the WebSocket
+// connection to the AIVory backend, e.g. an in-memory transport in tests
+// or a custom sink. No API key is needed and breakpoints are unavailable.
+func WithTransport(t Transport) ConfigOption {
+	return func(c *Config) {
+		c.Transport = t
+	}
+}
+
+// WithSentryCompatMode sends captures as Sentry event envelopes to the
+// Sentry-compatible endpoint of dsn instead of the AIVory backend. No
+// AIVory API key is needed in this mode and breakpoints are unavailable.
+func WithSentryCompatMode(dsn string) ConfigOption {
+	return func(c *Config) {
+		c.SentryDSN = dsn
+	}
+}
+
+// WithBeforeSend sets a hook that is called with each fully populated
+// capture after sampling, right before it is sent. The hook may modify the
+// capture, e.g. to scrub personal data from the message, return a
+// replacement, or return nil to drop it, e.g. for context.Canceled.
+func WithBeforeSend(fn func(*capture.ExceptionCapture) *capture.ExceptionCapture) ConfigOption {
+	return func(c *Config) {
+		c.BeforeSend = fn
+	}
+}
+
 // WithDebug enables debug logging.
 func WithDebug(debug bool) ConfigOption {
 	return func(c *Config) {
@@ -96,6 +995,31 @@ func WithDebug(debug bool) ConfigOption {
 	}
 }
 
+// WithEnabled enables or disables the agent. A disabled agent never
+// connects or sends anything, and needs no API key, but CapturePanic and
+// HTTPMiddleware still recover and re-panic as usual, so call sites need
+// not change, e.g. in local development builds. Enabled by default, except
+// in CI and the environments of WithDisabledEnvironments; WithEnabled(true)
+// enables the agent there too.
+func WithEnabled(enabled bool) ConfigOption {
+	return func(c *Config) {
+		c.Enabled = enabled
+		c.enabledSet = true
+	}
+}
+
+// WithDisabledEnvironments disables the agent when its environment is one
+// of envs, compared case-insensitively, e.g. to keep test runs from
+// reporting. The agent is likewise disabled when it detects a CI system
+// from environment variables such as CI=true or GITHUB_ACTIONS. Either is
+// overridden by WithEnabled(true) or AIVORY_ENABLED. The default list is
+// read from AIVORY_DISABLED_ENVIRONMENTS, separated by commas.
+func WithDisabledEnvironments(envs []string) ConfigOption {
+	return func(c *Config) {
+		c.DisabledEnvironments = envs
+	}
+}
+
 // WithEnableBreakpoints enables or disables breakpoint support.
 func WithEnableBreakpoints(enable bool) ConfigOption {
 	return func(c *Config) {
@@ -103,20 +1027,48 @@ func WithEnableBreakpoints(enable bool) ConfigOption {
 	}
 }
 
+// WithSamplingByLevel sets per-level sampling rates that override the flat
+// sampling rate, e.g. keep all fatal and error captures but only 10% of
+// warnings. Levels not in the map use the flat rate.
+func WithSamplingByLevel(rates map[Level]float64) ConfigOption {
+	return func(c *Config) {
+		c.SamplingByLevel = rates
+	}
+}
+
 // ShouldSample returns true if the current event should be sampled.
 func (c *Config) ShouldSample() bool {
-	if c.SamplingRate >= 1.0 {
+	return shouldSampleRate(c.SamplingRate)
+}
+
+// ShouldSampleLevel returns true if an event of the given level should be
+// sampled, honoring per-level sampling rates.
+func (c *Config) ShouldSampleLevel(level Level) bool {
+	if rate, ok := c.SamplingByLevel[level]; ok {
+		return shouldSampleRate(rate)
+	}
+	return c.ShouldSample()
+}
+
+func shouldSampleRate(rate float64) bool {
+	if rate >= 1.0 {
 		return true
 	}
-	if c.SamplingRate <= 0.0 {
+	if rate <= 0.0 {
 		return false
 	}
 
 	// Simple random sampling
+	return randomFloat() < rate
+}
+
+// randomFloat returns a uniformly distributed random number in [0, 1),
+// built from the 53 high bits of a random uint64 so every float64 step in
+// the range is reachable.
+func randomFloat() float64 {
 	var b [8]byte
 	rand.Read(b[:])
-	r := float64(b[0]) / 256.0
-	return r < c.SamplingRate
+	return float64(binary.BigEndian.Uint64(b[:])>>11) / (1 << 53)
 }
 
 // RuntimeInfo contains Go runtime information.
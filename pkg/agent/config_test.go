@@ -0,0 +1,117 @@
+package agent_test
+
+import (
+	"errors"
+	"math"
+	"strings"
+	"testing"
+
+	"github.com/aivorynet/agent-go/pkg/agent"
+)
+
+func TestSamplingByLevel(t *testing.T) {
+	a, tr := newTestAgent(t, agent.WithSamplingByLevel(map[agent.Level]float64{
+		agent.LevelFatal:   1,
+		agent.LevelWarning: 0.1,
+		agent.LevelInfo:    0,
+	}))
+
+	const n = 1000
+	counts := make(map[agent.Level]int)
+	for _, level := range []agent.Level{agent.LevelFatal, agent.LevelError, agent.LevelWarning, agent.LevelInfo} {
+		for i := 0; i < n; i++ {
+			a.CaptureErrorWithLevel(errors.New("sampled"), level)
+		}
+	}
+	for _, c := range tr.Captures() {
+		counts[c.Level]++
+	}
+
+	if counts[agent.LevelFatal] != n {
+		t.Errorf("captured %d of %d fatal errors, want all", counts[agent.LevelFatal], n)
+	}
+	if counts[agent.LevelError] != n {
+		t.Errorf("captured %d of %d errors at the flat rate, want all", counts[agent.LevelError], n)
+	}
+	// 10% of 1000 has a standard deviation of about 9.5.
+	if got := counts[agent.LevelWarning]; got < 40 || got > 160 {
+		t.Errorf("captured %d of %d warnings, want about 100", got, n)
+	}
+	if counts[agent.LevelInfo] != 0 {
+		t.Errorf("captured %d info events, want none", counts[agent.LevelInfo])
+	}
+}
+
+func TestShouldSampleRate(t *testing.T) {
+	const n = 200000
+	for _, rate := range []float64{0.001, 0.01, 0.5, 0.999} {
+		config := agent.NewConfig(agent.WithSamplingRate(rate))
+
+		sampled := 0
+		for i := 0; i < n; i++ {
+			if config.ShouldSample() {
+				sampled++
+			}
+		}
+
+		// Allow five standard deviations of the binomial distribution.
+		want := rate * n
+		tolerance := 5 * math.Sqrt(n*rate*(1-rate))
+		if got := float64(sampled); math.Abs(got-want) > tolerance {
+			t.Errorf("rate %v: sampled %d of %d, want %.0f ± %.0f", rate, sampled, n, want, tolerance)
+		}
+	}
+}
+
+func TestBackendURLSchemes(t *testing.T) {
+	for _, tt := range []struct {
+		url, want string
+		valid     bool
+	}{
+		{"wss://api.aivory.net/monitor/agent", "wss://api.aivory.net/monitor/agent", true},
+		{"ws://localhost:19999/ws", "ws://localhost:19999/ws", true},
+		{"https://api.aivory.net/monitor/agent", "wss://api.aivory.net/monitor/agent", true},
+		{"http://localhost:19999/ws", "ws://localhost:19999/ws", true},
+		{"HTTPS://api.aivory.net/monitor/agent", "wss://api.aivory.net/monitor/agent", true},
+		{"ftp://api.aivory.net/monitor/agent", "", false},
+		{"api.aivory.net/monitor/agent", "", false},
+		{"wss://", "", false},
+		{"wss://api.aivory.net/%zz", "", false},
+	} {
+		config := agent.NewConfig(agent.WithAPIKey("key"), agent.WithBackendURL(tt.url))
+		err := config.Validate()
+		if (err == nil) != tt.valid {
+			t.Errorf("%q: Validate() = %v, want valid = %v", tt.url, err, tt.valid)
+		}
+		if tt.valid && config.BackendURL != tt.want {
+			t.Errorf("%q: BackendURL = %q, want %q", tt.url, config.BackendURL, tt.want)
+		}
+	}
+}
+
+func TestBackendURLFromEnvironment(t *testing.T) {
+	t.Setenv("AIVORY_BACKEND_URL", "https://api.aivory.net/monitor/agent")
+
+	if got := agent.NewConfig().BackendURL; got != "wss://api.aivory.net/monitor/agent" {
+		t.Errorf("BackendURL = %q, want the https URL upgraded to wss", got)
+	}
+}
+
+func TestNewRejectsMalformedBackendURL(t *testing.T) {
+	var logged []string
+	a := agent.New(
+		agent.WithEnabled(true),
+		agent.WithAPIKey("key"),
+		agent.WithBackendURL("api.aivory.net:443"),
+		agent.WithLogger(agent.LoggerFunc(func(level, msg string) {
+			logged = append(logged, level+": "+msg)
+		})),
+	)
+	if a != nil {
+		a.Stop()
+		t.Fatal("New started an agent with a malformed backend URL")
+	}
+	if len(logged) != 1 || !strings.HasPrefix(logged[0], "error: ") || !strings.Contains(logged[0], "wss://") {
+		t.Errorf("logged %q, want one error explaining the expected scheme", logged)
+	}
+}
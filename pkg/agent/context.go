@@ -0,0 +1,88 @@
+package agent
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+)
+
+// contextKey is the type of the keys the agent stores in a context.Context.
+type contextKey int
+
+const (
+	breadcrumbsKey contextKey = iota
+	traceKey
+	featureFlagsKey
+)
+
+// CaptureErrorCtx captures an error with context-scoped data taken from
+// ctx. Breadcrumbs recorded with AddBreadcrumbCtx on ctx are attached
+// instead of the global trail, and the trace_id and span_id set with
+// WithTraceparent are added to the capture context. Feature flags set with
+// ContextWithFeatureFlags take precedence over the global ones.
+//
+// The values of the keys registered with WithContextKeys are added to the
+// capture context, as are the deadline of ctx and, once ctx is done, its
+// error and cancellation cause, to show whether the failing operation had
+// already run out of time.
+func (a *Agent) CaptureErrorCtx(ctx context.Context, err error, extra ...map[string]interface{}) {
+	ev := &event{err: err, level: LevelError}
+	if len(extra) > 0 {
+		ev.context = extra[0]
+	}
+	a.captureEvent(a.withContext(ctx, ev))
+}
+
+// withContext adds the context-scoped data of ctx to ev and returns it.
+func (a *Agent) withContext(ctx context.Context, ev *event) *event {
+	if breadcrumbs, ok := contextBreadcrumbs(ctx); ok {
+		ev.breadcrumbs = breadcrumbs
+	} else {
+		ev.breadcrumbs = a.globalBreadcrumbs()
+	}
+	if flags, ok := contextFeatureFlags(ctx); ok {
+		ev.featureFlags = flags
+	}
+
+	scope := a.contextValues(ctx)
+	if tp, ok := contextTrace(ctx); ok {
+		scope["trace_id"] = tp.traceID
+		scope["span_id"] = tp.spanID
+	}
+	ev.scope = scope
+
+	return ev
+}
+
+// contextValues returns the registered context key values and the
+// deadline state of ctx.
+func (a *Agent) contextValues(ctx context.Context) map[string]interface{} {
+	values := make(map[string]interface{})
+
+	for _, key := range a.config.ContextKeys {
+		if v := ctx.Value(key); v != nil {
+			values[fmt.Sprintf("%v", key)] = v
+		}
+	}
+
+	if deadline, ok := ctx.Deadline(); ok {
+		values["context_deadline"] = deadline.UTC().Format(time.RFC3339Nano)
+	}
+	if err := ctx.Err(); err != nil {
+		values["context_error"] = err.Error()
+		if cause := context.Cause(ctx); cause != nil && !errors.Is(err, cause) {
+			values["context_cause"] = cause.Error()
+		}
+	}
+
+	return values
+}
+
+// CaptureErrorCtx captures an error with context-scoped data using the
+// global agent.
+func CaptureErrorCtx(ctx context.Context, err error, extra ...map[string]interface{}) {
+	if globalAgent != nil {
+		globalAgent.CaptureErrorCtx(ctx, err, extra...)
+	}
+}
@@ -0,0 +1,84 @@
+package agent
+
+import (
+	"sync"
+	"time"
+)
+
+// maxDedupEntries bounds the number of fingerprints tracked for
+// deduplication.
+const maxDedupEntries = 1000
+
+// dedupCache collapses captures with the same fingerprint that repeat
+// within a time window.
+type dedupCache struct {
+	mu      sync.Mutex
+	window  time.Duration
+	entries map[string]*dedupEntry
+}
+
+type dedupEntry struct {
+	lastSent   time.Time
+	suppressed int
+}
+
+func newDedupCache(window time.Duration) *dedupCache {
+	return &dedupCache{
+		window:  window,
+		entries: make(map[string]*dedupEntry),
+	}
+}
+
+// allow reports whether a capture with the given fingerprint should be
+// sent. If it should, it also returns the number of repeats suppressed
+// since the fingerprint was last sent.
+func (d *dedupCache) allow(fingerprint string) (bool, int) {
+	if d == nil || d.window <= 0 || fingerprint == "" {
+		return true, 0
+	}
+
+	now := time.Now()
+
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	entry, exists := d.entries[fingerprint]
+	if exists && now.Sub(entry.lastSent) < d.window {
+		entry.suppressed++
+		return false, 0
+	}
+
+	suppressed := 0
+	if exists {
+		suppressed = entry.suppressed
+	} else {
+		if len(d.entries) >= maxDedupEntries {
+			d.evict(now)
+		}
+		entry = &dedupEntry{}
+		d.entries[fingerprint] = entry
+	}
+	entry.lastSent = now
+	entry.suppressed = 0
+
+	return true, suppressed
+}
+
+// evict drops fingerprints whose window has passed, or the least recently
+// sent one if none has. Suppressed counts of evicted entries are lost.
+func (d *dedupCache) evict(now time.Time) {
+	var oldestKey string
+	var oldest time.Time
+	for key, entry := range d.entries {
+		if now.Sub(entry.lastSent) >= d.window && entry.suppressed == 0 {
+			delete(d.entries, key)
+			continue
+		}
+		if oldestKey == "" || entry.lastSent.Before(oldest) {
+			oldestKey, oldest = key, entry.lastSent
+		}
+	}
+	if len(d.entries) >= maxDedupEntries {
+		delete(d.entries, oldestKey)
+	}
+}
@@ -0,0 +1,69 @@
+package agent
+
+import (
+	"fmt"
+	"os"
+	"strings"
+)
+
+// ciEnvVars are environment variables set by common CI systems. CI and
+// CONTINUOUS_INTEGRATION must be "true" or "1"; the others only need to be
+// set.
+var ciEnvVars = []string{
+	"CI",
+	"CONTINUOUS_INTEGRATION",
+	"GITHUB_ACTIONS",
+	"GITLAB_CI",
+	"CIRCLECI",
+	"TRAVIS",
+	"JENKINS_URL",
+	"BUILDKITE",
+	"TEAMCITY_VERSION",
+	"TF_BUILD",
+	"BITBUCKET_BUILD_NUMBER",
+	"DRONE",
+}
+
+// detectCI returns the name of the environment variable that shows the
+// process runs in CI, or "" if none does.
+func detectCI() string {
+	for _, name := range ciEnvVars {
+		value, ok := os.LookupEnv(name)
+		if !ok || value == "" {
+			continue
+		}
+		if name == "CI" || name == "CONTINUOUS_INTEGRATION" {
+			if v := strings.ToLower(value); v != "true" && v != "1" {
+				continue
+			}
+		}
+		return name
+	}
+	return ""
+}
+
+// autoDisableReason returns why the agent should be disabled although it
+// was not disabled explicitly: its environment is one of
+// DisabledEnvironments, or it runs in CI. It returns "" if it should not.
+func (c *Config) autoDisableReason() string {
+	for _, env := range c.DisabledEnvironments {
+		if strings.EqualFold(strings.TrimSpace(env), c.Environment) {
+			return fmt.Sprintf("environment %q is in the disabled environments", c.Environment)
+		}
+	}
+	if name := detectCI(); name != "" {
+		return fmt.Sprintf("running in CI (%s is set)", name)
+	}
+	return ""
+}
+
+// splitList splits a comma-separated list, dropping empty items.
+func splitList(s string) []string {
+	var items []string
+	for _, item := range strings.Split(s, ",") {
+		if item = strings.TrimSpace(item); item != "" {
+			items = append(items, item)
+		}
+	}
+	return items
+}
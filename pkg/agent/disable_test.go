@@ -0,0 +1,174 @@
+package agent_test
+
+import (
+	"errors"
+	"strings"
+	"testing"
+
+	"github.com/aivorynet/agent-go/pkg/agent"
+	"github.com/aivorynet/agent-go/pkg/agent/agenttest"
+)
+
+// clearCI unsets the environment variables that enable, disable or show
+// CI for the duration of the test, so it behaves the same in CI.
+func clearCI(t *testing.T) {
+	t.Helper()
+
+	for _, name := range []string{
+		"AIVORY_ENABLED",
+		"AIVORY_DISABLED_ENVIRONMENTS",
+		"CI",
+		"CONTINUOUS_INTEGRATION",
+		"GITHUB_ACTIONS",
+		"GITLAB_CI",
+		"CIRCLECI",
+		"TRAVIS",
+		"JENKINS_URL",
+		"BUILDKITE",
+		"TEAMCITY_VERSION",
+		"TF_BUILD",
+		"BITBUCKET_BUILD_NUMBER",
+		"DRONE",
+	} {
+		t.Setenv(name, "")
+	}
+}
+
+// newDisablableAgent starts an agent like newTestAgent but without
+// enabling it explicitly, and returns what it logged.
+func newDisablableAgent(t *testing.T, options ...agent.ConfigOption) (*agent.Agent, *agenttest.Transport, *[]string) {
+	t.Helper()
+
+	tr := agenttest.NewTransport()
+	logged := new([]string)
+	options = append([]agent.ConfigOption{
+		agent.WithTransport(tr),
+		agent.WithDedupWindow(0),
+		agent.WithLogger(agent.LoggerFunc(func(level, msg string) {
+			*logged = append(*logged, msg)
+		})),
+	}, options...)
+
+	a := agent.New(options...)
+	if a == nil {
+		t.Fatal("New returned nil")
+	}
+	t.Cleanup(a.Stop)
+	return a, tr, logged
+}
+
+func TestCIDisablesAgent(t *testing.T) {
+	tests := []struct {
+		name, value string
+		disabled    bool
+	}{
+		{"CI", "true", true},
+		{"CI", "1", true},
+		{"CI", "false", false},
+		{"CONTINUOUS_INTEGRATION", "TRUE", true},
+		{"GITHUB_ACTIONS", "true", true},
+		{"JENKINS_URL", "https://jenkins.example.com/", true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name+"="+tt.value, func(t *testing.T) {
+			clearCI(t)
+			t.Setenv(tt.name, tt.value)
+
+			a, tr, logged := newDisablableAgent(t)
+			a.CaptureError(errors.New("boom"))
+
+			if got := len(tr.Captures()) == 0; got != tt.disabled {
+				t.Fatalf("disabled = %v, want %v", got, tt.disabled)
+			}
+			if !tt.disabled {
+				return
+			}
+			var reasons []string
+			for _, msg := range *logged {
+				if strings.Contains(msg, "Agent disabled") {
+					reasons = append(reasons, msg)
+				}
+			}
+			if len(reasons) != 1 || !strings.Contains(reasons[0], tt.name) {
+				t.Errorf("logged %q, want one message naming %s", reasons, tt.name)
+			}
+		})
+	}
+}
+
+func TestEnabledOverridesCI(t *testing.T) {
+	clearCI(t)
+	t.Setenv("CI", "true")
+
+	a, tr, _ := newDisablableAgent(t, agent.WithEnabled(true))
+	a.CaptureError(errors.New("boom"))
+	if got := len(tr.Captures()); got != 1 {
+		t.Errorf("captured %d errors with WithEnabled(true) in CI, want 1", got)
+	}
+
+	t.Setenv("AIVORY_ENABLED", "true")
+	a, tr, _ = newDisablableAgent(t)
+	a.CaptureError(errors.New("boom"))
+	if got := len(tr.Captures()); got != 1 {
+		t.Errorf("captured %d errors with AIVORY_ENABLED in CI, want 1", got)
+	}
+}
+
+func TestDisabledEnvironments(t *testing.T) {
+	clearCI(t)
+
+	a, tr, logged := newDisablableAgent(t,
+		agent.WithEnvironment("test"),
+		agent.WithDisabledEnvironments([]string{"Test", "staging"}),
+	)
+	a.CaptureError(errors.New("boom"))
+	if got := len(tr.Captures()); got != 0 {
+		t.Errorf("captured %d errors in a disabled environment, want 0", got)
+	}
+	if len(*logged) == 0 || !strings.Contains((*logged)[0], `"test"`) {
+		t.Errorf("logged %q, want the disabled environment named", *logged)
+	}
+
+	a, tr, _ = newDisablableAgent(t,
+		agent.WithEnvironment("production"),
+		agent.WithDisabledEnvironments([]string{"test"}),
+	)
+	a.CaptureError(errors.New("boom"))
+	if got := len(tr.Captures()); got != 1 {
+		t.Errorf("captured %d errors in production, want 1", got)
+	}
+
+	t.Setenv("AIVORY_DISABLED_ENVIRONMENTS", "dev, test")
+	a, tr, _ = newDisablableAgent(t, agent.WithEnvironment("test"))
+	a.CaptureError(errors.New("boom"))
+	if got := len(tr.Captures()); got != 0 {
+		t.Errorf("captured %d errors in an environment of AIVORY_DISABLED_ENVIRONMENTS, want 0", got)
+	}
+
+	a, tr, _ = newDisablableAgent(t, agent.WithEnvironment("test"), agent.WithEnabled(true))
+	a.CaptureError(errors.New("boom"))
+	if got := len(tr.Captures()); got != 1 {
+		t.Errorf("captured %d errors with WithEnabled(true), want 1", got)
+	}
+}
+
+func TestDisabledAgentStillRepanics(t *testing.T) {
+	clearCI(t)
+	t.Setenv("CI", "true")
+
+	tr := agenttest.NewTransport()
+	agent.Init(
+		agent.WithTransport(tr),
+		agent.WithLogger(agent.LoggerFunc(func(level, msg string) {})),
+	)
+	t.Cleanup(agenttest.Reset)
+
+	agent.CaptureError(errors.New("boom"))
+	if r := panicWith(agent.CapturePanic, func() { panic("boom") }); r != "boom" {
+		t.Errorf("recovered %v, want the panic re-panicked", r)
+	}
+	if got := len(tr.Captures()); got != 0 {
+		t.Errorf("captured %d events while disabled, want 0", got)
+	}
+	agent.Shutdown()
+}
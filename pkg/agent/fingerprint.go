@@ -0,0 +1,36 @@
+package agent
+
+import (
+	"strings"
+
+	"github.com/aivorynet/agent-go/pkg/capture"
+)
+
+// FingerprintMode selects how captures are grouped.
+type FingerprintMode = capture.FingerprintMode
+
+// Fingerprint modes.
+const (
+	FingerprintStackOnly      = capture.FingerprintStackOnly
+	FingerprintMessageOnly    = capture.FingerprintMessageOnly
+	FingerprintTypeAndMessage = capture.FingerprintTypeAndMessage
+	FingerprintCustom         = capture.FingerprintCustom
+)
+
+// DefaultFingerprint stands for the computed fingerprint in
+// CaptureOptions.Fingerprint.
+const DefaultFingerprint = "{{ default }}"
+
+// overrideFingerprint replaces the fingerprint of a capture with the hash
+// of parts, in which DefaultFingerprint expands to the computed one.
+func overrideFingerprint(c *capture.ExceptionCapture, parts []string) {
+	expanded := make([]string, len(parts))
+	for i, part := range parts {
+		if part == DefaultFingerprint {
+			part = c.Fingerprint
+		}
+		expanded[i] = part
+	}
+	c.Fingerprint = capture.HashFingerprint(strings.Join(expanded, ":"))
+	c.FingerprintMode = FingerprintCustom
+}
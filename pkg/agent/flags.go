@@ -0,0 +1,79 @@
+package agent
+
+import (
+	"context"
+	"sort"
+)
+
+// maxFeatureFlags bounds the number of feature flags attached to a capture.
+const maxFeatureFlags = 100
+
+// SetFeatureFlags sets the feature flag state attached to every capture,
+// replacing any flags set before. At most 100 flags are kept, chosen in
+// name order.
+func (a *Agent) SetFeatureFlags(flags map[string]bool) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	a.featureFlags = mergeFeatureFlags(flags, nil)
+}
+
+// globalFeatureFlags returns a copy of the flags set with SetFeatureFlags.
+func (a *Agent) globalFeatureFlags() map[string]bool {
+	a.mu.RLock()
+	defer a.mu.RUnlock()
+
+	return mergeFeatureFlags(a.featureFlags, nil)
+}
+
+// ContextWithFeatureFlags returns a context carrying feature flag state for
+// captures made with CaptureErrorCtx. Context flags take precedence over
+// those set with SetFeatureFlags; nested calls merge, with the innermost
+// value of a flag winning.
+func ContextWithFeatureFlags(ctx context.Context, flags map[string]bool) context.Context {
+	outer, _ := contextFeatureFlags(ctx)
+	return context.WithValue(ctx, featureFlagsKey, mergeFeatureFlags(flags, outer))
+}
+
+// contextFeatureFlags returns the feature flags carried by ctx.
+func contextFeatureFlags(ctx context.Context) (map[string]bool, bool) {
+	flags, ok := ctx.Value(featureFlagsKey).(map[string]bool)
+	return flags, ok
+}
+
+// mergeFeatureFlags returns a new map holding the flags of primary and,
+// for names not in primary, those of fallback, capped at maxFeatureFlags.
+// Flags are taken in name order so the same ones are kept every time. It
+// returns nil if both are empty.
+func mergeFeatureFlags(primary, fallback map[string]bool) map[string]bool {
+	if len(primary) == 0 && len(fallback) == 0 {
+		return nil
+	}
+
+	merged := make(map[string]bool, len(primary)+len(fallback))
+	for _, flags := range []map[string]bool{primary, fallback} {
+		names := make([]string, 0, len(flags))
+		for name := range flags {
+			names = append(names, name)
+		}
+		sort.Strings(names)
+
+		for _, name := range names {
+			if len(merged) >= maxFeatureFlags {
+				return merged
+			}
+			if _, exists := merged[name]; !exists {
+				merged[name] = flags[name]
+			}
+		}
+	}
+	return merged
+}
+
+// SetFeatureFlags sets the feature flag state attached to every capture
+// using the global agent.
+func SetFeatureFlags(flags map[string]bool) {
+	if globalAgent != nil {
+		globalAgent.SetFeatureFlags(flags)
+	}
+}
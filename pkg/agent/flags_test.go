@@ -0,0 +1,50 @@
+package agent_test
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"reflect"
+	"testing"
+
+	"github.com/aivorynet/agent-go/pkg/agent"
+)
+
+func TestFeatureFlagsPrecedence(t *testing.T) {
+	a, tr := newTestAgent(t)
+	a.SetFeatureFlags(map[string]bool{"new-checkout": false, "dark-mode": true})
+
+	a.CaptureError(errors.New("global"))
+
+	ctx := agent.ContextWithFeatureFlags(context.Background(), map[string]bool{"new-checkout": true})
+	ctx = agent.ContextWithFeatureFlags(ctx, map[string]bool{"beta-search": true})
+	a.CaptureErrorCtx(ctx, errors.New("scoped"))
+
+	captures := tr.Captures()
+	if want := map[string]bool{"new-checkout": false, "dark-mode": true}; !reflect.DeepEqual(captures[0].FeatureFlags, want) {
+		t.Errorf("global flags = %v, want %v", captures[0].FeatureFlags, want)
+	}
+	want := map[string]bool{"new-checkout": true, "dark-mode": true, "beta-search": true}
+	if !reflect.DeepEqual(captures[1].FeatureFlags, want) {
+		t.Errorf("context flags = %v, want %v", captures[1].FeatureFlags, want)
+	}
+}
+
+func TestFeatureFlagsCap(t *testing.T) {
+	a, tr := newTestAgent(t)
+
+	flags := make(map[string]bool)
+	for i := 0; i < 150; i++ {
+		flags[fmt.Sprintf("flag-%03d", i)] = true
+	}
+	a.SetFeatureFlags(flags)
+	a.CaptureError(errors.New("boom"))
+
+	got := tr.Captures()[0].FeatureFlags
+	if len(got) != 100 {
+		t.Fatalf("attached %d flags, want 100", len(got))
+	}
+	if !got["flag-000"] || got["flag-100"] {
+		t.Error("the flags kept are not the first in name order")
+	}
+}
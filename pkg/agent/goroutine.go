@@ -0,0 +1,62 @@
+package agent
+
+import (
+	"fmt"
+
+	"github.com/aivorynet/agent-go/pkg/capture"
+)
+
+// Go runs fn in a new goroutine that captures a panic in fn before it can
+// crash the process. The capture has the "goroutine" context flag and the
+// stack of the call to Go under goroutine_spawn_stack, to show where the
+// goroutine was launched. The panic is then swallowed, or, if WithGoRepanic
+// is set, re-panicked after the capture is sent synchronously as by
+// CapturePanic.
+func (a *Agent) Go(fn func()) {
+	spawn := spawnStack(capture.CaptureStackTrace())
+
+	go func() {
+		defer func() {
+			r := recover()
+			if r == nil {
+				return
+			}
+			ctx := map[string]interface{}{
+				"panic":     true,
+				"goroutine": true,
+			}
+			scope := map[string]interface{}{
+				"goroutine_spawn_stack": spawn,
+			}
+			if !a.config.GoRepanic {
+				a.capturePanic(r, ctx, scope)
+				return
+			}
+			if !a.suppressIfPaused() {
+				a.sendFatal(a.panicEvent(r, ctx, scope))
+			}
+			panic(r)
+		}()
+
+		fn()
+	}()
+}
+
+// spawnStack formats a stack trace as "function (file:line)" lines.
+func spawnStack(frames []capture.StackFrame) []string {
+	lines := make([]string, 0, len(frames))
+	for _, f := range frames {
+		lines = append(lines, fmt.Sprintf("%s.%s (%s:%d)", f.PackageName, f.MethodName, f.FilePath, f.LineNumber))
+	}
+	return lines
+}
+
+// Go runs fn in a new goroutine that captures panics using the global
+// agent. Without a global agent, fn runs in a plain goroutine.
+func Go(fn func()) {
+	if globalAgent != nil {
+		globalAgent.Go(fn)
+		return
+	}
+	go fn()
+}
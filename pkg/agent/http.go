@@ -0,0 +1,109 @@
+package agent
+
+import (
+	"bufio"
+	"net"
+	"net/http"
+
+	"github.com/aivorynet/agent-go/pkg/capture"
+)
+
+// HTTPMiddleware wraps next so that panics in it are captured with details
+// of the request under the "http_request" context key and answered with
+// 500 Internal Server Error. The panic is not re-raised, so the server
+// keeps serving. If the handler had already started the response, it
+// cannot be replaced, so it is aborted with http.ErrAbortHandler instead.
+// Panics with http.ErrAbortHandler, which abort a response on purpose, are
+// passed through uncaptured.
+//
+// The request is described by capture.FromHTTPRequest. Headers are
+// forwarded as configured by WithHTTPHeaders; cookies, credentials and
+// header and query parameter values whose names match the redact keys are
+// redacted.
+func (a *Agent) HTTPMiddleware(next http.Handler) http.Handler {
+	return httpMiddleware(func() *Agent { return a }, next)
+}
+
+// HTTPMiddleware wraps next so that panics in it are captured using the
+// global agent. The agent may be initialized after the handler is wrapped.
+func HTTPMiddleware(next http.Handler) http.Handler {
+	return httpMiddleware(GetAgent, next)
+}
+
+func httpMiddleware(agent func() *Agent, next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		tw := &trackingWriter{ResponseWriter: w}
+		defer func() {
+			rec := recover()
+			if rec == nil {
+				return
+			}
+			if rec == http.ErrAbortHandler {
+				panic(rec)
+			}
+
+			if a := agent(); a != nil {
+				a.capturePanic(rec, map[string]interface{}{
+					"panic":        true,
+					"http_request": a.httpRequestContext(r),
+				}, nil)
+			}
+			if tw.started {
+				panic(http.ErrAbortHandler)
+			}
+			http.Error(w, http.StatusText(http.StatusInternalServerError), http.StatusInternalServerError)
+		}()
+
+		next.ServeHTTP(tw, r)
+	})
+}
+
+// trackingWriter records whether a handler started its response. It
+// passes Flush and Hijack on, and Unwrap gives http.ResponseController
+// access to the other optional interfaces of the wrapped writer.
+type trackingWriter struct {
+	http.ResponseWriter
+	started bool
+}
+
+func (w *trackingWriter) WriteHeader(code int) {
+	// Informational 1xx responses may precede the final one.
+	if code >= 200 {
+		w.started = true
+	}
+	w.ResponseWriter.WriteHeader(code)
+}
+
+func (w *trackingWriter) Write(b []byte) (int, error) {
+	w.started = true
+	return w.ResponseWriter.Write(b)
+}
+
+func (w *trackingWriter) Flush() {
+	if f, ok := w.ResponseWriter.(http.Flusher); ok {
+		w.started = true
+		f.Flush()
+	}
+}
+
+func (w *trackingWriter) Hijack() (net.Conn, *bufio.ReadWriter, error) {
+	h, ok := w.ResponseWriter.(http.Hijacker)
+	if !ok {
+		return nil, nil, http.ErrNotSupported
+	}
+	w.started = true
+	return h.Hijack()
+}
+
+func (w *trackingWriter) Unwrap() http.ResponseWriter {
+	return w.ResponseWriter
+}
+
+// httpRequestContext describes a request for the capture context.
+func (a *Agent) httpRequestContext(r *http.Request) map[string]interface{} {
+	return capture.FromHTTPRequestWithOptions(r, capture.HTTPRequestOptions{
+		Headers:        a.config.HTTPHeaders,
+		RedactKeys:     a.config.RedactKeys,
+		MaxValueLength: a.config.MaxStringLength,
+	})
+}
@@ -0,0 +1,51 @@
+package agent
+
+import (
+	"strconv"
+
+	"github.com/aivorynet/agent-go/pkg/capture"
+)
+
+// maxJoinedErrors bounds the captures made for one joined error.
+const maxJoinedErrors = 10
+
+// joinedErrors returns the errors joined in err, or nil if err is not a
+// multi-error.
+func joinedErrors(err error) []error {
+	if joined, ok := err.(interface{ Unwrap() []error }); ok {
+		return joined.Unwrap()
+	}
+	return nil
+}
+
+// captureJoined captures each joined error as its own event, sharing the
+// rest of ev, and returns the first capture that was sent.
+func (a *Agent) captureJoined(ev *event, errs []error) *capture.ExceptionCapture {
+	var first *capture.ExceptionCapture
+	for i, err := range errs {
+		if i >= maxJoinedErrors {
+			break
+		}
+		if err == nil {
+			continue
+		}
+
+		child := *ev
+		child.err = err
+		child.joinIndex = i + 1
+		if captured := a.captureEvent(&child); first == nil {
+			first = captured
+		}
+	}
+	return first
+}
+
+// markJoined records the position of a capture in its joined error. Stack
+// fingerprints are salted with the position, as the joined errors share a
+// stack and would otherwise be grouped, and deduplicated, as one.
+func markJoined(c *capture.ExceptionCapture, index int) {
+	c.Context["joined_error_index"] = index
+	if c.FingerprintMode == capture.FingerprintStackOnly {
+		c.Fingerprint = capture.HashFingerprint(c.Fingerprint + ":" + strconv.Itoa(index))
+	}
+}
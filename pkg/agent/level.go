@@ -0,0 +1,30 @@
+package agent
+
+import (
+	"github.com/aivorynet/agent-go/pkg/capture"
+)
+
+// Level is the severity of a capture.
+type Level = capture.Level
+
+// Severity levels, from most to least severe.
+const (
+	LevelFatal   = capture.LevelFatal
+	LevelError   = capture.LevelError
+	LevelWarning = capture.LevelWarning
+	LevelInfo    = capture.LevelInfo
+	LevelDebug   = capture.LevelDebug
+)
+
+// normalizeLevel returns level, LevelError if it is unset, or LevelError
+// with a warning if it is not one of the defined levels.
+func (a *Agent) normalizeLevel(level Level) Level {
+	if level == "" {
+		return LevelError
+	}
+	if !level.Valid() {
+		a.log.Warnf("Unknown level %q, using %q", level, LevelError)
+		return LevelError
+	}
+	return level
+}
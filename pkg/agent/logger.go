@@ -0,0 +1,46 @@
+package agent
+
+import (
+	"log"
+
+	"github.com/aivorynet/agent-go/pkg/internal/logging"
+	"github.com/aivorynet/agent-go/pkg/transport"
+)
+
+// Logger receives the agent's log lines: those of the agent itself, of its
+// connection to the backend and of breakpoints. Lines are passed without
+// the "[AIVory Monitor]" prefix the default logger adds, so they can be
+// written as structured records. Debug lines repeated within 30s are
+// suppressed before they reach it.
+type Logger = transport.Logger
+
+// NewStdLogger returns a Logger printing lines with the "[AIVory Monitor]"
+// prefix to l, or to the standard logger if l is nil. It is the default.
+func NewStdLogger(l *log.Logger) Logger {
+	return logging.Std(l)
+}
+
+// LoggerFunc adapts a function to a Logger. It is called with the level
+// ("debug", "info", "warn" or "error") and the formatted line, including
+// the "[AIVory Monitor]" prefix.
+type LoggerFunc func(level, msg string)
+
+// Debugf implements Logger.
+func (f LoggerFunc) Debugf(format string, args ...interface{}) {
+	logging.Func(f).Debugf(format, args...)
+}
+
+// Infof implements Logger.
+func (f LoggerFunc) Infof(format string, args ...interface{}) {
+	logging.Func(f).Infof(format, args...)
+}
+
+// Warnf implements Logger.
+func (f LoggerFunc) Warnf(format string, args ...interface{}) {
+	logging.Func(f).Warnf(format, args...)
+}
+
+// Errorf implements Logger.
+func (f LoggerFunc) Errorf(format string, args ...interface{}) {
+	logging.Func(f).Errorf(format, args...)
+}
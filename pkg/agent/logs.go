@@ -0,0 +1,90 @@
+package agent
+
+import (
+	"io"
+	"strings"
+	"sync"
+
+	"github.com/aivorynet/agent-go/pkg/capture"
+)
+
+const agentLogPrefix = "[AIVory Monitor]"
+
+// logBuffer keeps the most recent log lines, bounded by line count and
+// total bytes, and implements io.Writer so it can back the log package.
+type logBuffer struct {
+	mu       sync.Mutex
+	maxLines int
+	maxBytes int
+	lines    []string
+	size     int
+
+	redactKeys []string
+	redact     capture.RedactFunc
+}
+
+func newLogBuffer(maxLines, maxBytes int, redactKeys []string, redact capture.RedactFunc) *logBuffer {
+	return &logBuffer{
+		maxLines:   maxLines,
+		maxBytes:   maxBytes,
+		redactKeys: redactKeys,
+		redact:     redact,
+	}
+}
+
+// Write records each line of p, redacting the values of sensitive
+// key=value pairs. The agent's own log lines are skipped.
+func (b *logBuffer) Write(p []byte) (int, error) {
+	if b.maxLines <= 0 || b.maxBytes <= 0 {
+		return len(p), nil
+	}
+
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	for _, line := range strings.Split(strings.TrimRight(string(p), "\n"), "\n") {
+		if line == "" || strings.Contains(line, agentLogPrefix) {
+			continue
+		}
+		line = capture.TruncateString(capture.RedactLogLine(line, b.redactKeys, b.redact), b.maxBytes)
+
+		b.lines = append(b.lines, line)
+		b.size += len(line)
+		for len(b.lines) > b.maxLines || b.size > b.maxBytes {
+			b.size -= len(b.lines[0])
+			b.lines = b.lines[1:]
+		}
+	}
+
+	return len(p), nil
+}
+
+func (b *logBuffer) snapshot() []string {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	if len(b.lines) == 0 {
+		return nil
+	}
+	lines := make([]string, len(b.lines))
+	copy(lines, b.lines)
+	return lines
+}
+
+// LogWriter returns a writer that keeps recent log lines and attaches them
+// to captures as RecentLogs. Use it as (part of) the output of the
+// standard log package, keeping the normal output as well:
+//
+//	log.SetOutput(io.MultiWriter(os.Stderr, a.LogWriter()))
+func (a *Agent) LogWriter() io.Writer {
+	return a.recentLogs
+}
+
+// LogWriter returns the global agent's log writer, or io.Discard if the
+// agent has not been initialized.
+func LogWriter() io.Writer {
+	if globalAgent != nil {
+		return globalAgent.LogWriter()
+	}
+	return io.Discard
+}
@@ -0,0 +1,54 @@
+package agent_test
+
+import (
+	"errors"
+	"fmt"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/aivorynet/agent-go/pkg/agent"
+)
+
+func TestLogWriterEvictsOldestLines(t *testing.T) {
+	a, tr := newTestAgent(t, agent.WithRecentLogLimits(3, 1024))
+
+	for i := 1; i <= 5; i++ {
+		fmt.Fprintf(a.LogWriter(), "line %d\n", i)
+	}
+	fmt.Fprintln(a.LogWriter(), "[AIVory Monitor] agent log line")
+	a.CaptureError(errors.New("boom"))
+
+	want := []string{"line 3", "line 4", "line 5"}
+	if got := tr.Captures()[0].RecentLogs; !reflect.DeepEqual(got, want) {
+		t.Errorf("RecentLogs = %q, want %q", got, want)
+	}
+}
+
+func TestLogWriterByteLimit(t *testing.T) {
+	a, tr := newTestAgent(t, agent.WithRecentLogLimits(10, 25))
+
+	fmt.Fprint(a.LogWriter(), "aaaaaaaaaa\nbbbbbbbbbb\ncccccccccc\n")
+	fmt.Fprintln(a.LogWriter(), strings.Repeat("é", 20))
+	a.CaptureError(errors.New("boom"))
+
+	logs := tr.Captures()[0].RecentLogs
+	if len(logs) != 1 {
+		t.Fatalf("RecentLogs = %q, want only the last line", logs)
+	}
+	if line := logs[0]; len(line) > 25 || !strings.HasPrefix(strings.Repeat("é", 20), line) {
+		t.Errorf("last line = %q, want it cut at a rune boundary within 25 bytes", line)
+	}
+}
+
+func TestLogWriterRedactsSensitivePairs(t *testing.T) {
+	a, tr := newTestAgent(t, agent.WithRedactKeys([]string{"token"}))
+
+	fmt.Fprintln(a.LogWriter(), `login user=bob token=abc123 {"api_token": "xyz"}`)
+	a.CaptureError(errors.New("boom"))
+
+	want := `login user=bob token=[REDACTED] {"api_token": "[REDACTED]"}`
+	if got := tr.Captures()[0].RecentLogs; len(got) != 1 || got[0] != want {
+		t.Errorf("RecentLogs = %q, want %q", got, want)
+	}
+}
@@ -0,0 +1,140 @@
+package agent_test
+
+import (
+	"runtime/debug"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/aivorynet/agent-go/pkg/agent"
+)
+
+// panicWith runs fn with a deferred handler and returns the value it
+// re-panicked with.
+func panicWith(handler func(), fn func()) (r interface{}) {
+	defer func() {
+		r = recover()
+	}()
+	defer handler()
+	fn()
+	return nil
+}
+
+func TestInstallGlobalHandlerCapturesPanic(t *testing.T) {
+	tr := initTestAgent(t)
+
+	r := panicWith(agent.InstallGlobalHandler(), func() {
+		panic("main crashed")
+	})
+	if r != "main crashed" {
+		t.Errorf("re-panicked with %v, want the original value", r)
+	}
+
+	captures := tr.Captures()
+	if len(captures) != 1 {
+		t.Fatalf("got %d captures, want 1", len(captures))
+	}
+	if c := captures[0]; c.Level != agent.LevelFatal || c.Message != "main crashed" {
+		t.Errorf("capture = %s %q, want a fatal capture of the panic", c.Level, c.Message)
+	}
+}
+
+func TestInstallPanicHandlerCapturesRuntimeError(t *testing.T) {
+	a, tr := newTestAgent(t)
+
+	r := panicWith(a.InstallPanicHandler(), func() {
+		var m map[string]int
+		m["key"] = 1
+	})
+	if err, ok := r.(error); !ok || !strings.Contains(err.Error(), "nil map") {
+		t.Errorf("re-panicked with %v, want the runtime error", r)
+	}
+	if captures := tr.Captures(); len(captures) != 1 || !strings.Contains(captures[0].Message, "nil map") {
+		t.Errorf("captures = %v, want the runtime error", captures)
+	}
+}
+
+func TestInstallPanicHandlerRestoresPanicOnFault(t *testing.T) {
+	a, _ := newTestAgent(t)
+
+	panicWith(a.InstallPanicHandler(), func() {
+		if !debug.SetPanicOnFault(true) {
+			t.Error("SetPanicOnFault not enabled by the handler")
+		}
+	})
+	if debug.SetPanicOnFault(false) {
+		t.Error("SetPanicOnFault not restored after the handler returned")
+	}
+}
+
+func TestInstallPanicHandlerWithoutPanic(t *testing.T) {
+	a, tr := newTestAgent(t)
+
+	if r := panicWith(a.InstallPanicHandler(), func() {}); r != nil {
+		t.Errorf("handler panicked with %v without a panic", r)
+	}
+	if got := len(tr.Captures()); got != 0 {
+		t.Errorf("got %d captures without a panic", got)
+	}
+}
+
+func TestGoCapturesPanic(t *testing.T) {
+	tr := initTestAgent(t)
+
+	agent.Go(func() {
+		panic("worker crashed")
+	})
+
+	deadline := time.Now().Add(5 * time.Second)
+	for len(tr.Captures()) == 0 {
+		if time.Now().After(deadline) {
+			t.Fatal("panic in Go not captured")
+		}
+		time.Sleep(time.Millisecond)
+	}
+	if c := tr.Captures()[0]; c.Message != "worker crashed" || c.Context["goroutine"] != true {
+		t.Errorf("capture = %q %v, want the goroutine panic", c.Message, c.Context)
+	}
+}
+
+func TestPanicExceptionTypes(t *testing.T) {
+	a, tr := newTestAgent(t)
+
+	for _, tt := range []struct {
+		name, exceptionType, kind, message string
+		fn                                 func()
+	}{
+		{"nil deref", "runtime.errorString", "runtime_error", "invalid memory address or nil pointer dereference", func() {
+			var p *struct{ n int }
+			_ = p.n
+		}},
+		{"nil map assign", "runtime.plainError", "runtime_error", "assignment to entry in nil map", func() {
+			var m map[string]int
+			m["key"] = 1
+		}},
+		{"index out of range", "runtime.boundsError", "runtime_error", "index out of range", func() {
+			var s []int
+			i := 0
+			_ = s[i]
+		}},
+		{"string", "panic", "string", "Test panic error", func() {
+			panic("Test panic error")
+		}},
+	} {
+		tr.Clear()
+		panicWith(a.CapturePanic, tt.fn)
+
+		captures := tr.Captures()
+		if len(captures) != 1 {
+			t.Errorf("%s: got %d captures, want 1", tt.name, len(captures))
+			continue
+		}
+		c := captures[0]
+		if c.ExceptionType != tt.exceptionType || !strings.Contains(c.Message, tt.message) {
+			t.Errorf("%s: captured %s %q, want %s %q", tt.name, c.ExceptionType, c.Message, tt.exceptionType, tt.message)
+		}
+		if c.Context["panic_kind"] != tt.kind || c.Context["panic"] != true {
+			t.Errorf("%s: panic_kind = %v, panic = %v; want %s", tt.name, c.Context["panic_kind"], c.Context["panic"], tt.kind)
+		}
+	}
+}
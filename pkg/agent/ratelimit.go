@@ -0,0 +1,98 @@
+package agent
+
+import (
+	"sync"
+	"time"
+)
+
+// maxLimiterBuckets bounds the number of fingerprints tracked for
+// per-fingerprint rate limiting.
+const maxLimiterBuckets = 1000
+
+// fingerprintLimiter rate limits captures per fingerprint with a token
+// bucket, so a high-volume error cannot crowd out rare ones.
+type fingerprintLimiter struct {
+	mu      sync.Mutex
+	limit   int
+	window  time.Duration
+	buckets map[string]*tokenBucket
+}
+
+// tokenBucket holds up to limit tokens and refills limit tokens per window.
+type tokenBucket struct {
+	tokens  float64
+	updated time.Time
+	dropped int
+}
+
+func newFingerprintLimiter(limit int, window time.Duration) *fingerprintLimiter {
+	return &fingerprintLimiter{
+		limit:   limit,
+		window:  window,
+		buckets: make(map[string]*tokenBucket),
+	}
+}
+
+// allow reports whether a capture with the given fingerprint may be sent.
+// If it may, it also returns the number of captures dropped since the
+// fingerprint was last allowed.
+func (l *fingerprintLimiter) allow(fingerprint string) (bool, int) {
+	if l == nil || l.limit <= 0 || l.window <= 0 || fingerprint == "" {
+		return true, 0
+	}
+
+	now := time.Now()
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	bucket, exists := l.buckets[fingerprint]
+	if !exists {
+		if len(l.buckets) >= maxLimiterBuckets {
+			l.evict(now)
+		}
+		bucket = &tokenBucket{tokens: float64(l.limit), updated: now}
+		l.buckets[fingerprint] = bucket
+	}
+	l.refill(bucket, now)
+
+	if bucket.tokens < 1 {
+		bucket.dropped++
+		return false, 0
+	}
+	bucket.tokens--
+
+	dropped := bucket.dropped
+	bucket.dropped = 0
+	return true, dropped
+}
+
+// refill adds the tokens accrued since the bucket was last updated.
+func (l *fingerprintLimiter) refill(bucket *tokenBucket, now time.Time) {
+	elapsed := now.Sub(bucket.updated)
+	bucket.updated = now
+	bucket.tokens += float64(l.limit) * float64(elapsed) / float64(l.window)
+	if bucket.tokens > float64(l.limit) {
+		bucket.tokens = float64(l.limit)
+	}
+}
+
+// evict drops full buckets, which behave like new ones, or the least
+// recently updated one if none is full. Dropped counts of evicted buckets
+// are lost.
+func (l *fingerprintLimiter) evict(now time.Time) {
+	var oldestKey string
+	var oldest time.Time
+	for key, bucket := range l.buckets {
+		if bucket.dropped == 0 && now.Sub(bucket.updated) >= l.window {
+			delete(l.buckets, key)
+			continue
+		}
+		if oldestKey == "" || bucket.updated.Before(oldest) {
+			oldestKey, oldest = key, bucket.updated
+		}
+	}
+	if len(l.buckets) >= maxLimiterBuckets {
+		delete(l.buckets, oldestKey)
+	}
+}
@@ -0,0 +1,22 @@
+package agent
+
+import (
+	"sync"
+
+	"github.com/aivorynet/agent-go/pkg/internal/hooks"
+)
+
+func init() {
+	hooks.ResetGlobal = resetGlobal
+}
+
+// resetGlobal stops the global agent and resets it so that Init can
+// initialize a new one. It is exposed to tests by the agenttest package.
+func resetGlobal() {
+	if globalAgent != nil {
+		globalAgent.Stop()
+	}
+	globalAgent = nil
+	globalInitErr = nil
+	globalOnce = sync.Once{}
+}
@@ -0,0 +1,87 @@
+package agent
+
+import (
+	"sync"
+	"time"
+
+	"github.com/aivorynet/agent-go/pkg/capture"
+)
+
+// Sampler decides whether a finished capture is sent to the backend.
+// Implementations must be safe for concurrent use.
+type Sampler interface {
+	Sample(c *capture.ExceptionCapture) bool
+}
+
+// maxReservoirGroups bounds the number of fingerprints a ReservoirSampler
+// tracks at once.
+const maxReservoirGroups = 1000
+
+// ReservoirSampler keeps a representative sample of each error group by
+// applying reservoir sampling per fingerprint within a time window. The
+// first size captures of a fingerprint in each window are always sent;
+// the n-th after that is sent with probability size/n, so high-volume
+// groups still send occasionally while rare groups are always covered.
+type ReservoirSampler struct {
+	size   int
+	window time.Duration
+
+	mu     sync.Mutex
+	groups map[string]*reservoir
+}
+
+type reservoir struct {
+	start time.Time
+	seen  int
+}
+
+// NewReservoirSampler creates a sampler that keeps about size captures per
+// fingerprint per window.
+func NewReservoirSampler(size int, window time.Duration) *ReservoirSampler {
+	return &ReservoirSampler{
+		size:   size,
+		window: window,
+		groups: make(map[string]*reservoir),
+	}
+}
+
+// Sample implements Sampler.
+func (s *ReservoirSampler) Sample(c *capture.ExceptionCapture) bool {
+	now := time.Now()
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	group, exists := s.groups[c.Fingerprint]
+	if !exists || now.Sub(group.start) >= s.window {
+		if !exists && len(s.groups) >= maxReservoirGroups {
+			s.evict(now)
+		}
+		group = &reservoir{start: now}
+		s.groups[c.Fingerprint] = group
+	}
+
+	group.seen++
+	if group.seen <= s.size {
+		return true
+	}
+	return randomFloat() < float64(s.size)/float64(group.seen)
+}
+
+// evict drops expired groups, or the oldest group if none has expired.
+func (s *ReservoirSampler) evict(now time.Time) {
+	var oldestKey string
+	var oldest time.Time
+	for key, group := range s.groups {
+		if now.Sub(group.start) >= s.window {
+			delete(s.groups, key)
+			continue
+		}
+		if oldestKey == "" || group.start.Before(oldest) {
+			oldestKey, oldest = key, group.start
+		}
+	}
+	if len(s.groups) >= maxReservoirGroups {
+		delete(s.groups, oldestKey)
+	}
+}
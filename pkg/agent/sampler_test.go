@@ -0,0 +1,47 @@
+package agent_test
+
+import (
+	"testing"
+	"time"
+
+	"github.com/aivorynet/agent-go/pkg/agent"
+	"github.com/aivorynet/agent-go/pkg/capture"
+)
+
+func sampleN(s *agent.ReservoirSampler, fingerprint string, n int) int {
+	sent := 0
+	for i := 0; i < n; i++ {
+		if s.Sample(&capture.ExceptionCapture{Fingerprint: fingerprint}) {
+			sent++
+		}
+	}
+	return sent
+}
+
+func TestReservoirSamplerSkewedVolumes(t *testing.T) {
+	s := agent.NewReservoirSampler(10, time.Hour)
+
+	hot := sampleN(s, "hot", 10000)
+	rare := sampleN(s, "rare", 5)
+
+	if rare != 5 {
+		t.Errorf("sent %d of 5 rare captures, want all", rare)
+	}
+	// The first 10 are sent, then the n-th with probability 10/n: about
+	// 10 + 10*ln(1000) = 79 in total.
+	if hot < 40 || hot > 140 {
+		t.Errorf("sent %d of 10000 hot captures, want about 79", hot)
+	}
+}
+
+func TestReservoirSamplerWindow(t *testing.T) {
+	s := agent.NewReservoirSampler(2, 20*time.Millisecond)
+
+	if sent := sampleN(s, "fp", 2); sent != 2 {
+		t.Fatalf("sent %d of the first 2 captures, want both", sent)
+	}
+	time.Sleep(30 * time.Millisecond)
+	if sent := sampleN(s, "fp", 2); sent != 2 {
+		t.Errorf("sent %d of the first 2 captures of a new window, want both", sent)
+	}
+}
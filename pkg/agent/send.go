@@ -0,0 +1,245 @@
+package agent
+
+import (
+	"time"
+
+	"github.com/aivorynet/agent-go/pkg/capture"
+)
+
+// Send transmits a pre-built capture, e.g. one translated from another
+// monitoring system or replayed from storage. It returns true if the
+// capture was handed to the transport.
+//
+// The agent fills these fields only when they are unset: ID, Level
+// (defaults to error, as do unknown levels), CapturedAt, AgentID,
+// Environment, Release, Runtime, RuntimeInfo, Build, Process, Host,
+// Memory (with WithCaptureMemStats), Context, FeatureFlags and Tags. All
+// other fields, including Fingerprint, StackTrace and LocalVariables, are
+// sent as provided.
+// Pause and level sampling apply as for any other capture.
+func (a *Agent) Send(c *capture.ExceptionCapture) bool {
+	if c == nil || !a.started || a.suppressIfPaused() {
+		return false
+	}
+
+	a.stamp(c)
+
+	if !a.config.ShouldSampleLevel(c.Level) {
+		return false
+	}
+
+	return a.dispatch(c) != nil
+}
+
+// stamp fills the agent-level fields of a capture that are not yet set.
+func (a *Agent) stamp(c *capture.ExceptionCapture) {
+	if c.ID == "" {
+		c.ID = a.captureOptions().NewID()
+	}
+	c.Level = a.normalizeLevel(c.Level)
+	if c.CapturedAt == "" {
+		c.CapturedAt = time.Now().UTC().Format(time.RFC3339)
+	}
+	if c.AgentID == "" {
+		c.AgentID = a.config.AgentID
+	}
+	if c.Environment == "" {
+		c.Environment = a.config.Environment
+	}
+	if c.Release == "" {
+		c.Release = a.release
+	}
+	if c.Runtime == "" {
+		c.Runtime = "go"
+	}
+	if c.RuntimeInfo == (capture.RuntimeInfo{}) {
+		ri := a.config.GetRuntimeInfo()
+		c.RuntimeInfo = capture.RuntimeInfo{
+			Runtime:        ri.Runtime,
+			RuntimeVersion: ri.RuntimeVersion,
+			Platform:       ri.Platform,
+			Arch:           ri.Arch,
+			NumCPU:         ri.NumCPU,
+			NumGoroutine:   ri.NumGoroutine,
+		}
+	}
+	if c.Build == nil {
+		c.Build = a.build
+	}
+	if c.Process == nil {
+		c.Process = a.process
+	}
+	if c.Host == nil {
+		c.Host = a.host.Uptime()
+	}
+	if c.Memory == nil && a.config.CaptureMemStats {
+		c.Memory = capture.ReadMemStats()
+	}
+	if c.Context == nil {
+		c.Context = make(map[string]interface{})
+	}
+	if c.FeatureFlags == nil {
+		c.FeatureFlags = a.globalFeatureFlags()
+	}
+	if c.Tags == nil {
+		c.Tags = a.globalTags()
+	}
+}
+
+// dispatch applies deduplication, the configured sampler and the
+// before-send hook and hands a finished capture to the transport. It
+// returns the capture that was sent, or nil if it was dropped.
+func (a *Agent) dispatch(c *capture.ExceptionCapture) *capture.ExceptionCapture {
+	if c = a.prepare(c, false); c == nil {
+		return nil
+	}
+
+	switch {
+	case a.transport == nil:
+	case a.connection != nil && a.isPriority(c):
+		a.connection.SendPriority(c, a.config.PrioritySendTimeout)
+	default:
+		a.transport.SendException(c)
+	}
+	a.writeFileSink(c)
+	return c
+}
+
+// writeFileSink writes a capture sent to the transport to the file sink as
+// well, if there is one.
+func (a *Agent) writeFileSink(c *capture.ExceptionCapture) {
+	a.mu.RLock()
+	sink := a.fileSink
+	a.mu.RUnlock()
+
+	if sink != nil {
+		sink.SendException(c)
+	}
+}
+
+// prepare applies everything dispatch does before handing a capture to
+// the transport. It returns the capture to send, or nil if it was dropped.
+// Fatal captures and those sent synchronously, typically right before the
+// process exits, are never deduplicated or limited.
+func (a *Agent) prepare(c *capture.ExceptionCapture, sync bool) *capture.ExceptionCapture {
+	if !sync && c.Level != LevelFatal {
+		allowed, suppressed := a.dedup.allow(c.Fingerprint)
+		if !allowed {
+			return nil
+		}
+		c.OccurrenceCount += suppressed
+
+		allowed, dropped := a.limiter.allow(c.Fingerprint)
+		if !allowed {
+			if a.config.Debug {
+				a.log.Debugf("Capture dropped by per-fingerprint limit")
+			}
+			return nil
+		}
+		c.OccurrenceCount += dropped
+	}
+
+	if a.config.Sampler != nil && !a.config.Sampler.Sample(c) {
+		return nil
+	}
+
+	if a.config.BeforeSend != nil {
+		if c = a.config.BeforeSend(c); c == nil {
+			if a.config.Debug {
+				a.log.Debugf("Capture dropped by before-send hook")
+			}
+			return nil
+		}
+	}
+
+	if n := a.config.MaxPayloadBytes; n > 0 && !capture.FitPayload(c, n) {
+		if a.config.Debug {
+			a.log.Debugf("Capture dropped, larger than %d bytes after truncation", n)
+		}
+		return nil
+	}
+	return c
+}
+
+// CaptureErrorSync captures an error and sends it on the calling
+// goroutine, bypassing the async capture workers and the send queue, and
+// returns true if it was delivered within timeout. With reliable delivery
+// enabled, delivered means acknowledged by the backend; otherwise it means
+// written to the connection. It is meant for last-gasp reporting, e.g. in
+// a deferred function or signal handler right before os.Exit.
+//
+// Transports other than the WebSocket connection and the Sentry transport
+// are sent the capture and, if they queue captures, flushed.
+func (a *Agent) CaptureErrorSync(err error, timeout time.Duration, ctx ...map[string]interface{}) bool {
+	ev := &event{
+		err:         err,
+		level:       LevelError,
+		breadcrumbs: a.globalBreadcrumbs(),
+		sync:        true,
+	}
+	if len(ctx) > 0 {
+		ev.context = ctx[0]
+	}
+
+	c := a.captureEvent(ev)
+	if c == nil {
+		return false
+	}
+	return a.sendSync(c, timeout)
+}
+
+// sendSync hands a prepared capture to the transport and waits up to
+// timeout for it to be delivered.
+func (a *Agent) sendSync(c *capture.ExceptionCapture, timeout time.Duration) bool {
+	a.mu.RLock()
+	t := a.transport
+	a.mu.RUnlock()
+
+	if t == nil {
+		return false
+	}
+	a.writeFileSink(c)
+	if s, ok := t.(syncSender); ok {
+		return s.SendSync(c, timeout)
+	}
+
+	t.SendException(c)
+	if f, ok := t.(flusher); ok {
+		return f.Flush(timeout)
+	}
+	return true
+}
+
+// syncSender is implemented by transports that can deliver a capture on
+// the calling goroutine.
+type syncSender interface {
+	SendSync(exc *capture.ExceptionCapture, timeout time.Duration) bool
+}
+
+// isPriority returns true if a capture should jump the send queue: the
+// first capture after startup and fatal captures, when priority send is
+// enabled.
+func (a *Agent) isPriority(c *capture.ExceptionCapture) bool {
+	if a.config.PrioritySendTimeout <= 0 {
+		return false
+	}
+	first := a.firstSent.CompareAndSwap(false, true)
+	return first || c.Level == LevelFatal
+}
+
+// CaptureErrorSync captures an error using the global agent and waits up
+// to timeout for it to be delivered.
+func CaptureErrorSync(err error, timeout time.Duration, ctx ...map[string]interface{}) bool {
+	if globalAgent != nil {
+		return globalAgent.CaptureErrorSync(err, timeout, ctx...)
+	}
+	return false
+}
+
+// Send transmits a pre-built capture using the global agent.
+func Send(c *capture.ExceptionCapture) bool {
+	if globalAgent != nil {
+		return globalAgent.Send(c)
+	}
+	return false
+}
@@ -0,0 +1,107 @@
+package agent_test
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"testing"
+
+	"github.com/aivorynet/agent-go/pkg/agent"
+	"github.com/aivorynet/agent-go/pkg/capture"
+)
+
+func TestSendFillsAgentFields(t *testing.T) {
+	a, tr := newTestAgent(t,
+		agent.WithEnvironment("staging"),
+		agent.WithRelease("2.1.0"),
+		agent.WithTags(map[string]string{"team": "core"}),
+		agent.WithCaptureMemStats(true),
+	)
+
+	if !a.Send(&capture.ExceptionCapture{
+		ExceptionType: "legacy.Error",
+		Message:       "translated",
+		Fingerprint:   "imported-fingerprint",
+	}) {
+		t.Fatal("Send returned false")
+	}
+
+	c := tr.Captures()[0]
+	if c.ID == "" || c.CapturedAt == "" || c.AgentID == "" {
+		t.Errorf("ID = %q, CapturedAt = %q, AgentID = %q; want them filled", c.ID, c.CapturedAt, c.AgentID)
+	}
+	if c.Environment != "staging" || c.Release != "2.1.0" || c.Runtime != "go" {
+		t.Errorf("Environment = %q, Release = %q, Runtime = %q", c.Environment, c.Release, c.Runtime)
+	}
+	if c.Level != agent.LevelError {
+		t.Errorf("Level = %q, want error", c.Level)
+	}
+	if c.Tags["team"] != "core" {
+		t.Errorf("Tags = %v, want the global tags", c.Tags)
+	}
+	if c.Host == nil || c.Memory == nil {
+		t.Errorf("Host = %v, Memory = %v; want them filled", c.Host, c.Memory)
+	}
+	if c.Fingerprint != "imported-fingerprint" || c.Message != "translated" {
+		t.Errorf("Fingerprint = %q, Message = %q; want them sent as provided", c.Fingerprint, c.Message)
+	}
+}
+
+func TestSendKeepsSetFields(t *testing.T) {
+	a, tr := newTestAgent(t, agent.WithEnvironment("staging"))
+
+	a.Send(&capture.ExceptionCapture{
+		ID:          "replayed-1",
+		Environment: "production",
+		Level:       agent.LevelWarning,
+		Tags:        map[string]string{"source": "replay"},
+	})
+
+	c := tr.Captures()[0]
+	if c.ID != "replayed-1" || c.Environment != "production" || c.Level != agent.LevelWarning {
+		t.Errorf("ID = %q, Environment = %q, Level = %q; want the provided values", c.ID, c.Environment, c.Level)
+	}
+	if len(c.Tags) != 1 || c.Tags["source"] != "replay" {
+		t.Errorf("Tags = %v, want the provided tags", c.Tags)
+	}
+	if a.Send(nil) {
+		t.Error("Send(nil) returned true")
+	}
+}
+
+func TestBeforeSend(t *testing.T) {
+	var seen []*capture.ExceptionCapture
+	a, tr := newTestAgent(t, agent.WithBeforeSend(func(c *capture.ExceptionCapture) *capture.ExceptionCapture {
+		seen = append(seen, c)
+		if c.Message == context.Canceled.Error() {
+			return nil
+		}
+		c.Message = strings.ReplaceAll(c.Message, "alice@example.com", "[email]")
+		return c
+	}))
+
+	a.CaptureError(context.Canceled)
+	a.CaptureError(errors.New("no account for alice@example.com"))
+
+	if len(seen) != 2 || seen[0].AgentID == "" || seen[0].StackTrace == nil {
+		t.Fatalf("hook saw %d captures, want 2 fully populated ones", len(seen))
+	}
+	captures := tr.Captures()
+	if len(captures) != 1 {
+		t.Fatalf("sent %d captures, want the one the hook kept", len(captures))
+	}
+	if captures[0].Message != "no account for [email]" {
+		t.Errorf("Message = %q, want it scrubbed by the hook", captures[0].Message)
+	}
+}
+
+func TestTagValueTruncatedOnRuneBoundary(t *testing.T) {
+	a, tr := newTestAgent(t, agent.WithTags(map[string]string{
+		"region": strings.Repeat("a", 199) + "é",
+	}))
+	a.CaptureError(errors.New("boom"))
+
+	if got := tr.Captures()[0].Tags["region"]; got != strings.Repeat("a", 199) {
+		t.Errorf("region tag is %d bytes ending %q, want the 199 bytes before the split rune", len(got), got[len(got)-2:])
+	}
+}
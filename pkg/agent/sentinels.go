@@ -0,0 +1,39 @@
+package agent
+
+import (
+	"errors"
+	"sort"
+	"strings"
+
+	"github.com/aivorynet/agent-go/pkg/capture"
+)
+
+// sentinelTag is the tag listing the known errors a captured error matches.
+const sentinelTag = "sentinel"
+
+// matchSentinels returns the names of the known errors that err matches
+// with errors.Is, sorted.
+func (a *Agent) matchSentinels(err error) []string {
+	if err == nil {
+		return nil
+	}
+	var names []string
+	for name, sentinel := range a.config.KnownErrors {
+		if sentinel != nil && errors.Is(err, sentinel) {
+			names = append(names, name)
+		}
+	}
+	sort.Strings(names)
+	return names
+}
+
+// markSentinels records the known errors err matches on a capture, in
+// MatchedSentinels and in the "sentinel" tag unless that tag is set.
+func (a *Agent) markSentinels(c *capture.ExceptionCapture, err error) {
+	names := a.matchSentinels(err)
+	if len(names) == 0 {
+		return
+	}
+	c.MatchedSentinels = names
+	c.Tags = a.mergeTags(c.Tags, map[string]string{sentinelTag: strings.Join(names, ",")})
+}
@@ -0,0 +1,11 @@
+//go:build !windows
+
+package agent
+
+import (
+	"os"
+	"syscall"
+)
+
+// shutdownSignals are the signals WithManageSignals handles.
+var shutdownSignals = []os.Signal{syscall.SIGINT, syscall.SIGTERM}
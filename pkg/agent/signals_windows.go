@@ -0,0 +1,17 @@
+//go:build windows
+
+package agent
+
+import (
+	"os"
+	"syscall"
+)
+
+// shutdownSignals are the signals WithManageSignals handles. The Go
+// runtime delivers CTRL_C_EVENT and CTRL_BREAK_EVENT as os.Interrupt, and
+// CTRL_CLOSE_EVENT, CTRL_LOGOFF_EVENT and CTRL_SHUTDOWN_EVENT as
+// syscall.SIGTERM.
+//
+// A service is stopped through the service control manager, which sends no
+// console event, so its handler must flush and stop the agent itself.
+var shutdownSignals = []os.Signal{os.Interrupt, syscall.SIGTERM}
@@ -0,0 +1,181 @@
+package agent
+
+import (
+	"context"
+	"errors"
+	"log/slog"
+	"strings"
+)
+
+// slogHandler forwards records to another handler and captures those at
+// or above a minimum level.
+type slogHandler struct {
+	next     slog.Handler
+	minLevel slog.Level
+	agent    func() *Agent
+
+	// attrs holds the attributes added with WithAttrs, flattened under
+	// the group prefix that was open when they were added, and attrKeys
+	// their keys in the order they were added.
+	attrs    map[string]interface{}
+	attrKeys []string
+	prefix   string
+}
+
+// NewSlogHandler returns a slog.Handler that passes every record to next and
+// captures records at or above minLevel. The record's attributes, including
+// those added with WithAttrs and WithGroup, are flattened into the capture
+// context with dot-separated group names. An error-valued attribute is
+// captured as the error, so it sets the exception type and fingerprint, and
+// the log message is added as log_message; otherwise the message is
+// captured as the error. Of several error-valued attributes, one named
+// "err" or "error" is preferred, then the first in attribute order.
+// Context-scoped data of the context passed to the logger is attached as
+// by CaptureErrorCtx.
+func (a *Agent) NewSlogHandler(next slog.Handler, minLevel slog.Level) slog.Handler {
+	return &slogHandler{next: next, minLevel: minLevel, agent: func() *Agent { return a }}
+}
+
+// NewSlogHandler returns a slog.Handler like Agent.NewSlogHandler that
+// captures with the global agent:
+//
+//	logger := slog.New(agent.NewSlogHandler(slog.NewJSONHandler(os.Stderr, nil), slog.LevelError))
+func NewSlogHandler(next slog.Handler, minLevel slog.Level) slog.Handler {
+	return &slogHandler{next: next, minLevel: minLevel, agent: GetAgent}
+}
+
+// Enabled reports whether the wrapped handler handles the level or the
+// level is captured.
+func (h *slogHandler) Enabled(ctx context.Context, level slog.Level) bool {
+	return level >= h.minLevel || h.next.Enabled(ctx, level)
+}
+
+// Handle captures the record if its level is at least the minimum level
+// and passes it on to the wrapped handler.
+func (h *slogHandler) Handle(ctx context.Context, r slog.Record) error {
+	if r.Level >= h.minLevel && !strings.Contains(r.Message, agentLogPrefix) {
+		if a := h.agent(); a != nil {
+			h.capture(ctx, a, r)
+		}
+	}
+
+	if !h.next.Enabled(ctx, r.Level) {
+		return nil
+	}
+	return h.next.Handle(ctx, r)
+}
+
+// capture captures a record.
+func (h *slogHandler) capture(ctx context.Context, a *Agent, r slog.Record) {
+	fields := make(map[string]interface{}, len(h.attrs)+r.NumAttrs())
+	for k, v := range h.attrs {
+		fields[k] = v
+	}
+	keys := append([]string(nil), h.attrKeys...)
+	r.Attrs(func(attr slog.Attr) bool {
+		keys = flattenAttr(fields, keys, h.prefix, attr)
+		return true
+	})
+
+	err := recordError(fields, keys)
+	if err == nil {
+		err = errors.New(r.Message)
+	} else {
+		fields["log_message"] = r.Message
+	}
+
+	if ctx == nil {
+		ctx = context.Background()
+	}
+	a.captureEvent(a.withContext(ctx, &event{
+		err:     err,
+		level:   slogLevel(r.Level),
+		context: fields,
+	}))
+}
+
+// WithAttrs returns a handler that adds attrs to every record.
+func (h *slogHandler) WithAttrs(attrs []slog.Attr) slog.Handler {
+	clone := *h
+	clone.next = h.next.WithAttrs(attrs)
+	clone.attrs = make(map[string]interface{}, len(h.attrs)+len(attrs))
+	for k, v := range h.attrs {
+		clone.attrs[k] = v
+	}
+	clone.attrKeys = append([]string(nil), h.attrKeys...)
+	for _, attr := range attrs {
+		clone.attrKeys = flattenAttr(clone.attrs, clone.attrKeys, h.prefix, attr)
+	}
+	return &clone
+}
+
+// WithGroup returns a handler that nests later attributes under name.
+func (h *slogHandler) WithGroup(name string) slog.Handler {
+	if name == "" {
+		return h
+	}
+	clone := *h
+	clone.next = h.next.WithGroup(name)
+	clone.prefix = h.prefix + name + "."
+	return &clone
+}
+
+// flattenAttr adds an attribute to fields under prefix, flattening groups
+// into dot-separated keys, and returns keys with the keys it added
+// appended.
+func flattenAttr(fields map[string]interface{}, keys []string, prefix string, attr slog.Attr) []string {
+	value := attr.Value.Resolve()
+	if value.Kind() == slog.KindGroup {
+		groupPrefix := prefix
+		if attr.Key != "" {
+			groupPrefix += attr.Key + "."
+		}
+		for _, a := range value.Group() {
+			keys = flattenAttr(fields, keys, groupPrefix, a)
+		}
+		return keys
+	}
+	if attr.Key == "" {
+		return keys
+	}
+	key := prefix + attr.Key
+	if _, exists := fields[key]; !exists {
+		keys = append(keys, key)
+	}
+	fields[key] = value.Any()
+	return keys
+}
+
+// recordError returns the error a record is captured as: the value of an
+// attribute named "err" or "error", in any group, or else the first
+// error-valued attribute in keys. It returns nil if there is none.
+func recordError(fields map[string]interface{}, keys []string) error {
+	var first error
+	for _, key := range keys {
+		err, ok := fields[key].(error)
+		if !ok {
+			continue
+		}
+		if name := key[strings.LastIndex(key, ".")+1:]; name == "err" || name == "error" {
+			return err
+		}
+		if first == nil {
+			first = err
+		}
+	}
+	return first
+}
+
+// slogLevel maps a slog level onto a capture level.
+func slogLevel(level slog.Level) Level {
+	switch {
+	case level >= slog.LevelError:
+		return LevelError
+	case level >= slog.LevelWarn:
+		return LevelWarning
+	case level >= slog.LevelInfo:
+		return LevelInfo
+	default:
+		return LevelDebug
+	}
+}
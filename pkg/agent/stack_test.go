@@ -0,0 +1,63 @@
+package agent_test
+
+import (
+	"errors"
+	"strings"
+	"testing"
+
+	"github.com/aivorynet/agent-go/pkg/agent"
+	"github.com/aivorynet/agent-go/pkg/capture"
+)
+
+// checkTopFrame fails unless the stack trace starts in function.
+func checkTopFrame(t *testing.T, c *capture.ExceptionCapture, function string) {
+	t.Helper()
+
+	if len(c.StackTrace) == 0 {
+		t.Fatal("empty stack trace")
+	}
+	top := c.StackTrace[0]
+	if !strings.HasPrefix(top.Function, "github.com/aivorynet/agent-go/pkg/agent_test."+function) {
+		t.Errorf("top frame = %s (%s:%d), want %s", top.Function, top.FileName, top.LineNumber, function)
+	}
+	if !top.InApp {
+		t.Error("top frame not in app")
+	}
+}
+
+func TestStackStartsInCallerPackageLevel(t *testing.T) {
+	tr := initTestAgent(t)
+
+	agent.CaptureError(errors.New("boom"))
+	checkTopFrame(t, tr.Captures()[0], "TestStackStartsInCallerPackageLevel")
+}
+
+func TestStackStartsInCallerMethod(t *testing.T) {
+	a, tr := newTestAgent(t)
+
+	a.CaptureError(errors.New("boom"))
+	a.CaptureErrorWithLevel(errors.New("boom"), agent.LevelWarning)
+	for _, c := range tr.Captures() {
+		checkTopFrame(t, c, "TestStackStartsInCallerMethod")
+	}
+}
+
+func TestStackStartsInCallerPanic(t *testing.T) {
+	a, tr := newTestAgent(t)
+
+	panicWith(a.CapturePanic, func() {
+		panic("boom")
+	})
+	panicWith(a.InstallPanicHandler(), func() {
+		var p *struct{ n int }
+		_ = p.n
+	})
+
+	captures := tr.Captures()
+	if len(captures) != 2 {
+		t.Fatalf("got %d captures, want 2", len(captures))
+	}
+	for _, c := range captures {
+		checkTopFrame(t, c, "TestStackStartsInCallerPanic.func")
+	}
+}
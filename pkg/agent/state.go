@@ -0,0 +1,18 @@
+package agent
+
+import "github.com/aivorynet/agent-go/pkg/transport"
+
+// ConnectionState is the state of the agent's connection to the backend.
+type ConnectionState = transport.ConnectionState
+
+// ConnectionInfo describes the agent's connection to the backend.
+type ConnectionInfo = transport.ConnectionInfo
+
+// Connection states.
+const (
+	StateDisconnected  = transport.StateDisconnected
+	StateConnecting    = transport.StateConnecting
+	StateConnected     = transport.StateConnected
+	StateAuthenticated = transport.StateAuthenticated
+	StateFailed        = transport.StateFailed
+)
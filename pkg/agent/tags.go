@@ -0,0 +1,96 @@
+package agent
+
+import (
+	"sort"
+
+	"github.com/aivorynet/agent-go/pkg/capture"
+)
+
+// Limits on the tags attached to a capture.
+const (
+	maxTags           = 50
+	maxTagKeyLength   = 32
+	maxTagValueLength = 200
+)
+
+// SetTags sets the tags attached to every capture, replacing any tags set
+// before. Tags are short key/value pairs for searching and grouping in the
+// dashboard, such as service=checkout. Keys may hold only letters, digits
+// and underscores and at most 32 characters; other keys are dropped.
+// Values are truncated to 200 characters, and at most 50 tags are kept,
+// chosen in key order.
+func (a *Agent) SetTags(tags map[string]string) {
+	tags = a.mergeTags(tags, nil)
+
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	a.tags = tags
+}
+
+// globalTags returns a copy of the tags set with SetTags.
+func (a *Agent) globalTags() map[string]string {
+	a.mu.RLock()
+	defer a.mu.RUnlock()
+
+	return a.mergeTags(a.tags, nil)
+}
+
+// mergeTags returns a new map holding the valid tags of primary and, for
+// keys not in primary, those of fallback, capped at maxTags. Tags are
+// taken in key order so the same ones are kept every time. It returns nil
+// if there are no valid tags.
+func (a *Agent) mergeTags(primary, fallback map[string]string) map[string]string {
+	merged := make(map[string]string, len(primary)+len(fallback))
+	for _, tags := range []map[string]string{primary, fallback} {
+		keys := make([]string, 0, len(tags))
+		for key := range tags {
+			keys = append(keys, key)
+		}
+		sort.Strings(keys)
+
+		for _, key := range keys {
+			if len(merged) >= maxTags {
+				break
+			}
+			if !validTagKey(key) {
+				if a.config.Debug {
+					a.log.Debugf("Dropping tag with invalid key %q", key)
+				}
+				continue
+			}
+			if _, exists := merged[key]; exists {
+				continue
+			}
+			merged[key] = capture.TruncateString(tags[key], maxTagValueLength)
+		}
+	}
+
+	if len(merged) == 0 {
+		return nil
+	}
+	return merged
+}
+
+// validTagKey returns true if key is a non-empty run of at most
+// maxTagKeyLength letters, digits and underscores.
+func validTagKey(key string) bool {
+	if key == "" || len(key) > maxTagKeyLength {
+		return false
+	}
+	for _, r := range key {
+		switch {
+		case r >= 'a' && r <= 'z', r >= 'A' && r <= 'Z', r >= '0' && r <= '9', r == '_':
+		default:
+			return false
+		}
+	}
+	return true
+}
+
+// SetTags sets the tags attached to every capture using the global agent.
+func SetTags(tags map[string]string) {
+	if globalAgent != nil {
+		globalAgent.SetTags(tags)
+	}
+}
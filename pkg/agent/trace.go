@@ -0,0 +1,76 @@
+package agent
+
+import (
+	"context"
+	"strings"
+)
+
+// traceParent holds the IDs parsed from a W3C traceparent header.
+type traceParent struct {
+	traceID string
+	spanID  string
+}
+
+// WithTraceparent parses a W3C traceparent header
+// ("00-<trace-id>-<parent-id>-<flags>") and returns a context whose
+// captures made with CaptureErrorCtx carry its trace_id and span_id. A
+// malformed header is ignored and ctx is returned unchanged.
+func WithTraceparent(ctx context.Context, header string) context.Context {
+	tp, ok := parseTraceparent(header)
+	if !ok {
+		if globalAgent != nil && globalAgent.config.Debug {
+			globalAgent.log.Debugf("Ignoring malformed traceparent: %q", header)
+		}
+		return ctx
+	}
+	return context.WithValue(ctx, traceKey, tp)
+}
+
+// parseTraceparent validates and parses a traceparent header as specified
+// by the W3C Trace Context recommendation.
+func parseTraceparent(header string) (traceParent, bool) {
+	parts := strings.Split(strings.TrimSpace(header), "-")
+	if len(parts) < 4 {
+		return traceParent{}, false
+	}
+
+	version, traceID, spanID, flags := parts[0], parts[1], parts[2], parts[3]
+	if !isLowerHex(version, 2) || version == "ff" {
+		return traceParent{}, false
+	}
+	// Version 00 has exactly four fields; later versions may append more.
+	if version == "00" && len(parts) != 4 {
+		return traceParent{}, false
+	}
+	if !isLowerHex(traceID, 32) || traceID == strings.Repeat("0", 32) {
+		return traceParent{}, false
+	}
+	if !isLowerHex(spanID, 16) || spanID == strings.Repeat("0", 16) {
+		return traceParent{}, false
+	}
+	if !isLowerHex(flags, 2) {
+		return traceParent{}, false
+	}
+
+	return traceParent{traceID: traceID, spanID: spanID}, true
+}
+
+// isLowerHex returns true if s is n lowercase hex digits.
+func isLowerHex(s string, n int) bool {
+	if len(s) != n {
+		return false
+	}
+	for i := 0; i < len(s); i++ {
+		c := s[i]
+		if (c < '0' || c > '9') && (c < 'a' || c > 'f') {
+			return false
+		}
+	}
+	return true
+}
+
+// contextTrace returns the trace context stored in ctx, if any.
+func contextTrace(ctx context.Context) (traceParent, bool) {
+	tp, ok := ctx.Value(traceKey).(traceParent)
+	return tp, ok
+}
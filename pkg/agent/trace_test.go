@@ -0,0 +1,45 @@
+package agent_test
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	"github.com/aivorynet/agent-go/pkg/agent"
+)
+
+func TestWithTraceparent(t *testing.T) {
+	a, tr := newTestAgent(t)
+
+	ctx := agent.WithTraceparent(context.Background(), "00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01")
+	a.CaptureErrorCtx(ctx, errors.New("boom"))
+
+	c := tr.Captures()[0]
+	if c.Context["trace_id"] != "4bf92f3577b34da6a3ce929d0e0e4736" || c.Context["span_id"] != "00f067aa0ba902b7" {
+		t.Errorf("trace_id = %v, span_id = %v", c.Context["trace_id"], c.Context["span_id"])
+	}
+}
+
+func TestWithTraceparentMalformed(t *testing.T) {
+	a, tr := newTestAgent(t)
+
+	for _, header := range []string{
+		"",
+		"not-a-traceparent",
+		"00-4BF92F3577B34DA6A3CE929D0E0E4736-00f067aa0ba902b7-01",
+		"00-00000000000000000000000000000000-00f067aa0ba902b7-01",
+		"00-4bf92f3577b34da6a3ce929d0e0e4736-0000000000000000-01",
+		"00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01-extra",
+		"ff-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01",
+		"00-4bf92f3577b34da6a3ce929d0e0e473-00f067aa0ba902b7-01",
+	} {
+		ctx := agent.WithTraceparent(context.Background(), header)
+		a.CaptureErrorCtx(ctx, errors.New("boom"))
+
+		captures := tr.Captures()
+		c := captures[len(captures)-1]
+		if _, ok := c.Context["trace_id"]; ok {
+			t.Errorf("traceparent %q accepted", header)
+		}
+	}
+}
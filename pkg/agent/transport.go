@@ -0,0 +1,6 @@
+package agent
+
+import "github.com/aivorynet/agent-go/pkg/transport"
+
+// Transport delivers captures to a backend; see WithTransport.
+type Transport = transport.Transport
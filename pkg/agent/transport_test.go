@@ -0,0 +1,83 @@
+package agent_test
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/aivorynet/agent-go/pkg/agent"
+	"github.com/aivorynet/agent-go/pkg/capture"
+)
+
+// ndjsonTransport writes captures as NDJSON, flushing them on request.
+type ndjsonTransport struct {
+	mu           sync.Mutex
+	out          bytes.Buffer
+	queued       []*capture.ExceptionCapture
+	flushed      bool
+	disconnected bool
+}
+
+func (t *ndjsonTransport) SendException(exc *capture.ExceptionCapture) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.queued = append(t.queued, exc)
+}
+
+func (t *ndjsonTransport) IsConnected() bool {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	return !t.disconnected
+}
+
+func (t *ndjsonTransport) Disconnect() {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.disconnected = true
+}
+
+func (t *ndjsonTransport) Flush(timeout time.Duration) bool {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	enc := json.NewEncoder(&t.out)
+	for _, exc := range t.queued {
+		enc.Encode(exc)
+	}
+	t.queued = nil
+	t.flushed = true
+	return true
+}
+
+func TestCustomTransport(t *testing.T) {
+	tr := &ndjsonTransport{}
+	a := agent.New(
+		agent.WithTransport(tr),
+		agent.WithEnabled(true),
+		agent.WithLogger(agent.LoggerFunc(func(level, msg string) {})),
+	)
+	if !a.IsConnected() {
+		t.Error("IsConnected = false with a connected custom transport")
+	}
+	select {
+	case <-a.Ready():
+	default:
+		t.Error("Ready not closed with a custom transport")
+	}
+
+	a.CaptureError(errors.New("to stdout"))
+	a.Stop()
+
+	if !tr.flushed || !tr.disconnected {
+		t.Errorf("flushed = %v, disconnected = %v after Stop, want both", tr.flushed, tr.disconnected)
+	}
+	var c capture.ExceptionCapture
+	if err := json.Unmarshal(tr.out.Bytes(), &c); err != nil || c.Message != "to stdout" {
+		t.Errorf("wrote %q, want the capture as JSON (%v)", tr.out.String(), err)
+	}
+	if a.IsConnected() {
+		t.Error("IsConnected = true after Stop")
+	}
+}
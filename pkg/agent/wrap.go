@@ -0,0 +1,60 @@
+package agent
+
+// Wrap runs fn and captures the error it returns, or the panic it raises,
+// with args captured as local variables and name as the "operation" tag.
+// Go cannot read the arguments of an arbitrary failing function, so
+// wrapping critical functions records their arguments at failure instead:
+//
+//	err := agent.Wrap("charge", func() error {
+//		return charge(order, amount)
+//	}, map[string]interface{}{"order": order, "amount": amount})
+//
+// The error is returned unchanged; a panic is captured as fatal, sent
+// synchronously as by CapturePanic, and then re-panicked.
+func (a *Agent) Wrap(name string, fn func() error, args map[string]interface{}) error {
+	tags := map[string]string{"operation": name}
+
+	defer func() {
+		r := recover()
+		if r == nil {
+			return
+		}
+		if !a.suppressIfPaused() {
+			ev := a.panicEvent(r, withPanicFlag(args), nil)
+			ev.tags = tags
+			a.sendFatal(ev)
+		}
+		panic(r)
+	}()
+
+	err := fn()
+	if err != nil {
+		a.captureEvent(&event{
+			err:         err,
+			level:       LevelError,
+			context:     args,
+			breadcrumbs: a.globalBreadcrumbs(),
+			tags:        tags,
+		})
+	}
+	return err
+}
+
+// withPanicFlag returns a copy of ctx with the "panic" flag set.
+func withPanicFlag(ctx map[string]interface{}) map[string]interface{} {
+	flagged := make(map[string]interface{}, len(ctx)+1)
+	for k, v := range ctx {
+		flagged[k] = v
+	}
+	flagged["panic"] = true
+	return flagged
+}
+
+// Wrap runs fn and captures its error or panic with args using the global
+// agent. Without a global agent, it just runs fn.
+func Wrap(name string, fn func() error, args map[string]interface{}) error {
+	if globalAgent != nil {
+		return globalAgent.Wrap(name, fn, args)
+	}
+	return fn()
+}
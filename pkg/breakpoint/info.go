@@ -12,4 +12,16 @@ type BreakpointInfo struct {
 	MaxHits    int
 	HitCount   int
 	CreatedAt  time.Time
+
+	// ExpiresAt, if set, is when the breakpoint is removed automatically.
+	ExpiresAt time.Time
+
+	// LogMessage, if set, is rendered on every hit with {name}
+	// placeholders replaced by the local variables passed to Hit.
+	LogMessage string
+}
+
+// expired returns true if the breakpoint has an expiry time that passed.
+func (bp *BreakpointInfo) expired(now time.Time) bool {
+	return !bp.ExpiresAt.IsZero() && now.After(bp.ExpiresAt)
 }
@@ -2,15 +2,21 @@ package breakpoint
 
 import (
 	"encoding/json"
-	"log"
 	"path/filepath"
+	"regexp"
 	"runtime"
 	"sync"
 	"time"
+
+	"github.com/aivorynet/agent-go/pkg/capture"
+	"github.com/aivorynet/agent-go/pkg/internal/logging"
 )
 
 const maxCapturesPerSecond = 50
 
+// sweepInterval is how often expired breakpoints are purged.
+const sweepInterval = time.Minute
+
 // Sender is the interface for sending breakpoint hits to the backend.
 type Sender interface {
 	SendBreakpointHit(breakpointID string, payload map[string]interface{})
@@ -27,40 +33,130 @@ type Manager struct {
 
 	captureCount       int
 	captureWindowStart time.Time
+
+	// captureOptions controls how local variables passed to Hit are
+	// captured.
+	captureOptions capture.Options
+
+	log *logging.Logger
+
+	done      chan struct{}
+	closeOnce sync.Once
+}
+
+// Option configures a Manager.
+type Option func(*Manager)
+
+// WithCaptureOptions sets how local variables passed to Hit are captured,
+// e.g. their depth and redaction rules.
+func WithCaptureOptions(opts capture.Options) Option {
+	return func(m *Manager) {
+		m.captureOptions = opts
+	}
+}
+
+// Logger receives the log lines of a Manager. It has the same methods as
+// transport.Logger.
+type Logger interface {
+	Debugf(format string, args ...interface{})
+	Infof(format string, args ...interface{})
+	Warnf(format string, args ...interface{})
+	Errorf(format string, args ...interface{})
+}
+
+// WithLogger sends the manager's log lines to l instead of the standard
+// logger.
+func WithLogger(l Logger) Option {
+	return func(m *Manager) {
+		m.log = logging.New(l)
+	}
 }
 
 // NewManager creates a new breakpoint manager.
-func NewManager(debug bool, sender Sender) *Manager {
-	return &Manager{
+func NewManager(debug bool, sender Sender, opts ...Option) *Manager {
+	m := &Manager{
 		debug:              debug,
 		sender:             sender,
 		breakpoints:        make(map[string]*BreakpointInfo),
 		captureWindowStart: time.Now(),
+		captureOptions:     capture.Options{MaxDepth: 10},
+		log:                logging.New(nil),
+		done:               make(chan struct{}),
 	}
-}
 
-// SetBreakpoint registers a breakpoint.
-func (m *Manager) SetBreakpoint(id, filePath string, lineNumber int, condition string, maxHits int) {
-	if maxHits < 1 {
-		maxHits = 1
+	for _, opt := range opts {
+		opt(m)
 	}
-	if maxHits > 50 {
-		maxHits = 50
+
+	go m.sweep()
+
+	return m
+}
+
+// Close stops the background purging of expired breakpoints.
+func (m *Manager) Close() {
+	m.closeOnce.Do(func() {
+		close(m.done)
+	})
+}
+
+// sweep periodically removes expired breakpoints until Close is called.
+func (m *Manager) sweep() {
+	ticker := time.NewTicker(sweepInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-m.done:
+			return
+		case now := <-ticker.C:
+			m.removeExpired(now)
+		}
 	}
+}
 
+// removeExpired removes the breakpoints that expired before now.
+func (m *Manager) removeExpired(now time.Time) {
 	m.mu.Lock()
-	m.breakpoints[id] = &BreakpointInfo{
+	defer m.mu.Unlock()
+
+	for id, bp := range m.breakpoints {
+		if bp.expired(now) {
+			delete(m.breakpoints, id)
+			if m.debug {
+				m.log.Debugf("Breakpoint expired: %s", id)
+			}
+		}
+	}
+}
+
+// SetBreakpoint registers a breakpoint.
+func (m *Manager) SetBreakpoint(id, filePath string, lineNumber int, condition string, maxHits int) {
+	m.setBreakpoint(&BreakpointInfo{
 		ID:         id,
 		FilePath:   filePath,
 		LineNumber: lineNumber,
 		Condition:  condition,
 		MaxHits:    maxHits,
-		CreatedAt:  time.Now(),
+	})
+}
+
+// setBreakpoint registers a breakpoint, clamping MaxHits to 1..50.
+func (m *Manager) setBreakpoint(bp *BreakpointInfo) {
+	if bp.MaxHits < 1 {
+		bp.MaxHits = 1
+	}
+	if bp.MaxHits > 50 {
+		bp.MaxHits = 50
 	}
+	bp.CreatedAt = time.Now()
+
+	m.mu.Lock()
+	m.breakpoints[bp.ID] = bp
 	m.mu.Unlock()
 
 	if m.debug {
-		log.Printf("[AIVory Monitor] Breakpoint set: %s at %s:%d", id, filePath, lineNumber)
+		m.log.Debugf("Breakpoint set: %s at %s:%d", bp.ID, bp.FilePath, bp.LineNumber)
 	}
 }
 
@@ -71,13 +167,16 @@ func (m *Manager) RemoveBreakpoint(id string) {
 	m.mu.Unlock()
 
 	if m.debug {
-		log.Printf("[AIVory Monitor] Breakpoint removed: %s", id)
+		m.log.Debugf("Breakpoint removed: %s", id)
 	}
 }
 
 // Hit triggers a breakpoint capture.
 // Only captures if the breakpoint ID is registered and active.
-func (m *Manager) Hit(id string) {
+// The variables in locals, if given, are captured like the local variables
+// of an exception and fill the placeholders of the breakpoint's log
+// message.
+func (m *Manager) Hit(id string, locals ...map[string]interface{}) {
 	m.mu.RLock()
 	bp, exists := m.breakpoints[id]
 	m.mu.RUnlock()
@@ -86,6 +185,11 @@ func (m *Manager) Hit(id string) {
 		return
 	}
 
+	if bp.expired(time.Now()) {
+		m.RemoveBreakpoint(id)
+		return
+	}
+
 	if bp.HitCount >= bp.MaxHits {
 		return
 	}
@@ -100,7 +204,7 @@ func (m *Manager) Hit(id string) {
 	m.mu.Unlock()
 
 	if m.debug {
-		log.Printf("[AIVory Monitor] Breakpoint hit: %s", id)
+		m.log.Debugf("Breakpoint hit: %s", id)
 	}
 
 	stackTrace := m.buildStackTrace()
@@ -112,6 +216,14 @@ func (m *Manager) Hit(id string) {
 		"stack_trace": stackTrace,
 		"hit_count":   hitCount,
 	}
+	var variables map[string]capture.Variable
+	if len(locals) > 0 && len(locals[0]) > 0 {
+		variables = m.captureLocals(locals[0])
+		payload["local_variables"] = variables
+	}
+	if bp.LogMessage != "" {
+		payload["log_message"] = renderLogMessage(bp.LogMessage, variables)
+	}
 
 	m.sender.SendBreakpointHit(bp.ID, payload)
 }
@@ -150,8 +262,23 @@ func (m *Manager) HandleCommand(command string, payload interface{}) {
 		if mh, ok := payloadMap["max_hits"].(float64); ok {
 			maxHits = int(mh)
 		}
+		logMessage, _ := payloadMap["log_message"].(string)
+		var expiresAt time.Time
+		if ttl, ok := payloadMap["ttl"].(float64); ok && ttl > 0 {
+			expiresAt = time.Now().Add(time.Duration(ttl * float64(time.Second)))
+		} else if s, ok := payloadMap["expires_at"].(string); ok {
+			expiresAt, _ = time.Parse(time.RFC3339, s)
+		}
 
-		m.SetBreakpoint(id, filePath, lineNumber, condition, maxHits)
+		m.setBreakpoint(&BreakpointInfo{
+			ID:         id,
+			FilePath:   filePath,
+			LineNumber: lineNumber,
+			Condition:  condition,
+			MaxHits:    maxHits,
+			LogMessage: logMessage,
+			ExpiresAt:  expiresAt,
+		})
 
 	case "remove":
 		id, _ := payloadMap["id"].(string)
@@ -159,6 +286,32 @@ func (m *Manager) HandleCommand(command string, payload interface{}) {
 	}
 }
 
+// captureLocals captures the variables passed to Hit, in the shape of
+// ExceptionCapture.LocalVariables.
+func (m *Manager) captureLocals(locals map[string]interface{}) map[string]capture.Variable {
+	variables := make(map[string]capture.Variable, len(locals))
+	for name, value := range locals {
+		variables[name] = capture.CaptureValueWithOptions(name, value, m.captureOptions)
+	}
+	return variables
+}
+
+// logPlaceholder matches a {name} placeholder in a log message.
+var logPlaceholder = regexp.MustCompile(`\{([A-Za-z_][A-Za-z0-9_]*)\}`)
+
+// renderLogMessage replaces the {name} placeholders of a log message with
+// the captured values of the named variables. Placeholders of unknown
+// variables are kept as they are.
+func renderLogMessage(template string, variables map[string]capture.Variable) string {
+	return logPlaceholder.ReplaceAllStringFunc(template, func(placeholder string) string {
+		name := placeholder[1 : len(placeholder)-1]
+		if v, ok := variables[name]; ok {
+			return v.Value
+		}
+		return placeholder
+	})
+}
+
 func (m *Manager) rateLimitOk() bool {
 	now := time.Now()
 	if now.Sub(m.captureWindowStart) >= time.Second {
@@ -168,7 +321,7 @@ func (m *Manager) rateLimitOk() bool {
 
 	if m.captureCount >= maxCapturesPerSecond {
 		if m.debug {
-			log.Println("[AIVory Monitor] Rate limit reached, skipping capture")
+			m.log.Debugf("Rate limit reached, skipping capture")
 		}
 		return false
 	}
@@ -0,0 +1,226 @@
+package breakpoint
+
+import (
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/aivorynet/agent-go/pkg/capture"
+)
+
+// hit is a breakpoint hit recorded by recordingSender.
+type hit struct {
+	id      string
+	payload map[string]interface{}
+}
+
+// recordingSender records the breakpoint hits sent to it.
+type recordingSender struct {
+	mu   sync.Mutex
+	hits []hit
+}
+
+func (s *recordingSender) SendBreakpointHit(breakpointID string, payload map[string]interface{}) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.hits = append(s.hits, hit{breakpointID, payload})
+}
+
+func (s *recordingSender) sent() []hit {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return append([]hit(nil), s.hits...)
+}
+
+func newTestManager(t *testing.T) (*Manager, *recordingSender) {
+	t.Helper()
+
+	s := &recordingSender{}
+	m := NewManager(false, s)
+	t.Cleanup(m.Close)
+	return m, s
+}
+
+func TestHitCapturesLocals(t *testing.T) {
+	m, s := newTestManager(t)
+	m.HandleCommand("set", map[string]interface{}{
+		"id":          "bp-1",
+		"file_path":   "orders.go",
+		"line_number": float64(42),
+		"max_hits":    float64(2),
+	})
+
+	m.Hit("bp-1", map[string]interface{}{
+		"id":       17,
+		"customer": "acme",
+		"items":    []string{"apple", "pear"},
+	})
+
+	hits := s.sent()
+	if len(hits) != 1 || hits[0].id != "bp-1" {
+		t.Fatalf("sent %v, want one hit of bp-1", hits)
+	}
+	payload := hits[0].payload
+	if payload["file_path"] != "orders.go" || payload["line_number"] != 42 || payload["hit_count"] != 1 {
+		t.Errorf("payload = %v, want the breakpoint location and first hit", payload)
+	}
+
+	locals, ok := payload["local_variables"].(map[string]capture.Variable)
+	if !ok {
+		t.Fatalf("local_variables = %T, want map[string]capture.Variable", payload["local_variables"])
+	}
+	if locals["id"].Value != "17" || locals["customer"].Value != "acme" {
+		t.Errorf("local_variables = %v, want id and customer", locals)
+	}
+	if items := locals["items"]; len(items.ArrayElements) != 2 || items.ArrayElements[1].Value != "pear" {
+		t.Errorf("items = %+v, want both elements", items)
+	}
+}
+
+func TestHitWithoutLocals(t *testing.T) {
+	m, s := newTestManager(t)
+	m.SetBreakpoint("bp-1", "orders.go", 42, "", 1)
+
+	m.Hit("bp-1")
+	m.Hit("bp-1")
+	m.Hit("unknown")
+
+	hits := s.sent()
+	if len(hits) != 1 {
+		t.Fatalf("sent %d hits, want 1 within max hits", len(hits))
+	}
+	if _, ok := hits[0].payload["local_variables"]; ok {
+		t.Error("local_variables sent without locals")
+	}
+}
+
+func TestHandleCommandRemove(t *testing.T) {
+	m, s := newTestManager(t)
+	m.HandleCommand("set", map[string]interface{}{"id": "bp-1"})
+	m.HandleCommand("remove", map[string]interface{}{"id": "bp-1"})
+
+	m.Hit("bp-1")
+	if hits := s.sent(); len(hits) != 0 {
+		t.Errorf("sent %d hits of a removed breakpoint, want 0", len(hits))
+	}
+}
+
+func TestHitLogMessage(t *testing.T) {
+	m, s := newTestManager(t)
+	m.HandleCommand("set", map[string]interface{}{
+		"id":          "bp-1",
+		"max_hits":    float64(2),
+		"log_message": "order {id} for {customer} at {missing}",
+	})
+
+	m.Hit("bp-1", map[string]interface{}{"id": 17, "customer": "acme"})
+	m.Hit("bp-1")
+
+	hits := s.sent()
+	if len(hits) != 2 {
+		t.Fatalf("sent %d hits, want 2", len(hits))
+	}
+	if got := hits[0].payload["log_message"]; got != "order 17 for acme at {missing}" {
+		t.Errorf("log_message = %q, want placeholders filled and unknown ones kept", got)
+	}
+	if got := hits[1].payload["log_message"]; got != "order {id} for {customer} at {missing}" {
+		t.Errorf("log_message without locals = %q, want the template", got)
+	}
+}
+
+func TestRenderLogMessage(t *testing.T) {
+	variables := map[string]capture.Variable{
+		"user_id": {Value: "u-1"},
+		"count":   {Value: "3"},
+	}
+	tests := []struct {
+		template, want string
+	}{
+		{"no placeholders", "no placeholders"},
+		{"user {user_id} has {count} items", "user u-1 has 3 items"},
+		{"{user_id}{user_id}", "u-1u-1"},
+		{"unknown {nope} kept", "unknown {nope} kept"},
+		{"not a name {1x} or { count }", "not a name {1x} or { count }"},
+	}
+	for _, tt := range tests {
+		if got := renderLogMessage(tt.template, variables); got != tt.want {
+			t.Errorf("renderLogMessage(%q) = %q, want %q", tt.template, got, tt.want)
+		}
+	}
+}
+
+func TestHandleCommandExpiry(t *testing.T) {
+	m, _ := newTestManager(t)
+	expiresAt := time.Now().Add(time.Hour).UTC().Truncate(time.Second)
+
+	m.HandleCommand("set", map[string]interface{}{"id": "ttl", "ttl": float64(60)})
+	m.HandleCommand("set", map[string]interface{}{"id": "expires", "expires_at": expiresAt.Format(time.RFC3339)})
+	m.HandleCommand("set", map[string]interface{}{"id": "forever"})
+
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	if ttl := time.Until(m.breakpoints["ttl"].ExpiresAt); ttl < 59*time.Second || ttl > 60*time.Second {
+		t.Errorf("ttl breakpoint expires in %v, want 60s", ttl)
+	}
+	if got := m.breakpoints["expires"].ExpiresAt; !got.Equal(expiresAt) {
+		t.Errorf("expires_at breakpoint expires at %v, want %v", got, expiresAt)
+	}
+	if got := m.breakpoints["forever"].ExpiresAt; !got.IsZero() {
+		t.Errorf("breakpoint without expiry expires at %v", got)
+	}
+}
+
+func TestHitSkipsExpired(t *testing.T) {
+	m, s := newTestManager(t)
+	m.setBreakpoint(&BreakpointInfo{ID: "old", MaxHits: 1, ExpiresAt: time.Now().Add(-time.Second)})
+
+	m.Hit("old")
+
+	if hits := s.sent(); len(hits) != 0 {
+		t.Errorf("sent %d hits of an expired breakpoint, want 0", len(hits))
+	}
+	m.mu.RLock()
+	_, exists := m.breakpoints["old"]
+	m.mu.RUnlock()
+	if exists {
+		t.Error("expired breakpoint not deleted on Hit")
+	}
+}
+
+func TestRemoveExpired(t *testing.T) {
+	m, _ := newTestManager(t)
+	now := time.Now()
+	m.setBreakpoint(&BreakpointInfo{ID: "expired", ExpiresAt: now.Add(-time.Minute)})
+	m.setBreakpoint(&BreakpointInfo{ID: "live", ExpiresAt: now.Add(time.Minute)})
+	m.setBreakpoint(&BreakpointInfo{ID: "forever"})
+
+	m.removeExpired(now)
+
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	if _, ok := m.breakpoints["expired"]; ok {
+		t.Error("expired breakpoint kept")
+	}
+	for _, id := range []string{"live", "forever"} {
+		if _, ok := m.breakpoints[id]; !ok {
+			t.Errorf("%s breakpoint removed", id)
+		}
+	}
+}
+
+func TestCloseIdempotent(t *testing.T) {
+	m, _ := newTestManager(t)
+
+	m.Close()
+	m.Close()
+
+	var wg sync.WaitGroup
+	for i := 0; i < 4; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			m.Close()
+		}()
+	}
+	wg.Wait()
+}
@@ -0,0 +1,343 @@
+package capture
+
+import (
+	"encoding/json"
+	"math"
+	"sort"
+)
+
+// variableOverhead approximates the JSON size of the fixed fields of a
+// Variable.
+const variableOverhead = 80
+
+// buildBudgetFactor bounds the variables built at each depth and above for
+// a capture with a payload budget to this multiple of the budget, before
+// they are pruned.
+const buildBudgetFactor = 4
+
+// variableSize approximates the JSON size of a variable without its
+// children and elements. The name counts twice as it is also the key of
+// the map holding the variable.
+func variableSize(v Variable) int {
+	return variableOverhead + 2*len(v.Name) + len(v.Type) + len(v.Value)
+}
+
+// overBudget counts a variable being built at depth and returns true if
+// it is not built because the variables built at its depth and above
+// would exceed the build budget. As only shallower variables count
+// against it, deep values walked first cannot use up the budget of
+// shallow ones walked later.
+func (c *capturer) overBudget(name string, depth int) bool {
+	if c.opts.MaxPayloadBytes <= 0 {
+		return false
+	}
+	for len(c.built) <= depth {
+		c.built = append(c.built, 0)
+	}
+
+	size := variableOverhead + len(name)
+	total := size
+	for _, built := range c.built[:depth+1] {
+		total += built
+	}
+	if total > buildBudgetFactor*c.opts.MaxPayloadBytes {
+		return true
+	}
+	c.built[depth] += size
+	return false
+}
+
+// captureLocalsWithin captures local variables within the payload budget
+// in a single walk, bounded by the build budget, and prunes them breadth
+// first to fit.
+func captureLocalsWithin(err error, opts Options, ctx map[string]interface{}) *localVars {
+	locals := captureLocals(err, opts, ctx)
+	locals.omitted += fitVariables(locals.vars, opts.MaxPayloadBytes)
+	return locals
+}
+
+// budgetTruncated returns the placeholder of a variable dropped for the
+// payload budget.
+func budgetTruncated(name, typ string) Variable {
+	return Variable{
+		Name:             name,
+		Type:             typ,
+		IsTruncated:      true,
+		TruncationReason: TruncatedPayloadSize,
+	}
+}
+
+// fitVariables prunes variables breadth first until their approximate size
+// fits budget: every level of nesting is kept while it fits, then the
+// nodes of the first level that does not fit are kept in order while they
+// fit, and everything below is dropped. Variables that lose children are
+// marked truncated with TruncatedPayloadSize. It returns the number of top
+// level variables dropped.
+func fitVariables(vars map[string]Variable, budget int) int {
+	var levels []int
+	for _, name := range sortedNames(vars) {
+		levelSizes(vars[name], 0, &levels)
+	}
+
+	cutoff, remaining := len(levels), budget
+	for depth, size := range levels {
+		if size > remaining {
+			cutoff = depth
+			break
+		}
+		remaining -= size
+	}
+	if cutoff == len(levels) {
+		return 0
+	}
+
+	dropped := 0
+	for _, name := range sortedNames(vars) {
+		if v, keep := prune(vars[name], 0, cutoff, &remaining); keep {
+			vars[name] = v
+		} else {
+			delete(vars, name)
+			dropped++
+		}
+	}
+	return dropped
+}
+
+// variablesSize approximates the JSON size of variables and their
+// descendants.
+func variablesSize(vars map[string]Variable) int {
+	var levels []int
+	for _, v := range vars {
+		levelSizes(v, 0, &levels)
+	}
+	total := 0
+	for _, size := range levels {
+		total += size
+	}
+	return total
+}
+
+// levelSizes adds the size of v and its descendants to the sizes of their
+// levels.
+func levelSizes(v Variable, depth int, levels *[]int) {
+	if depth == len(*levels) {
+		*levels = append(*levels, 0)
+	}
+	(*levels)[depth] += variableSize(v)
+	for _, name := range sortedNames(v.Children) {
+		levelSizes(v.Children[name], depth+1, levels)
+	}
+	for _, element := range v.ArrayElements {
+		levelSizes(element, depth+1, levels)
+	}
+}
+
+// prune returns v with the levels below cutoff dropped, and whether v
+// itself is kept. Nodes at the cutoff level are kept while remaining
+// allows.
+func prune(v Variable, depth, cutoff int, remaining *int) (Variable, bool) {
+	if depth == cutoff {
+		size := variableSize(v)
+		if size > *remaining {
+			return v, false
+		}
+		*remaining -= size
+		if len(v.Children) > 0 || len(v.ArrayElements) > 0 {
+			v.Children, v.ArrayElements = nil, nil
+			markBudgetTruncated(&v)
+		}
+		return v, true
+	}
+
+	if len(v.Children) > 0 {
+		children := make(map[string]Variable, len(v.Children))
+		for _, name := range sortedNames(v.Children) {
+			if child, keep := prune(v.Children[name], depth+1, cutoff, remaining); keep {
+				children[name] = child
+			} else {
+				markBudgetTruncated(&v)
+			}
+		}
+		v.Children = children
+	}
+	if len(v.ArrayElements) > 0 {
+		elements := make([]Variable, 0, len(v.ArrayElements))
+		for _, element := range v.ArrayElements {
+			if element, keep := prune(element, depth+1, cutoff, remaining); keep {
+				elements = append(elements, element)
+			} else {
+				markBudgetTruncated(&v)
+			}
+		}
+		v.ArrayElements = elements
+	}
+	return v, true
+}
+
+func markBudgetTruncated(v *Variable) {
+	v.IsTruncated = true
+	v.TruncationReason = TruncatedPayloadSize
+}
+
+func sortedNames(vars map[string]Variable) []string {
+	names := make([]string, 0, len(vars))
+	for name := range vars {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// FitPayload shrinks a capture until its JSON encoding is at most maxBytes,
+// dropping in turn the stacks of other goroutines, source snippets, recent
+// logs, breadcrumbs, large context entries, local variables (pruned as by
+// the variable budget, then all of them), stack frames beyond the top ten
+// and finally the rest of the context. It returns false if the capture
+// still does not fit.
+//
+// The capture is encoded in full once, and again only to confirm it fits;
+// each step re-encodes just the part it changes to update the size.
+func FitPayload(c *ExceptionCapture, maxBytes int) bool {
+	size := payloadSize(c)
+
+	// resize applies drop to the part of the capture returned by part and
+	// updates size by the change in its encoding. An emptied field that is
+	// omitted from the encoding is counted as null, so size errs large.
+	resize := func(part func() interface{}, drop func()) {
+		before := encodedSize(part())
+		drop()
+		size += encodedSize(part()) - before
+	}
+	locals := func() interface{} {
+		return localsPart{c.LocalVariables, c.OmittedLocals}
+	}
+
+	steps := []func(){
+		func() {
+			resize(func() interface{} { return c.AllGoroutines }, func() {
+				c.AllGoroutines = nil
+			})
+		},
+		func() {
+			resize(func() interface{} { return c.StackTrace }, func() {
+				for i := range c.StackTrace {
+					c.StackTrace[i].SourceContext = nil
+					c.StackTrace[i].SourceLineIndex = 0
+				}
+			})
+		},
+		func() {
+			resize(func() interface{} { return c.RecentLogs }, func() {
+				c.RecentLogs = nil
+			})
+		},
+		func() {
+			resize(func() interface{} { return c.Breadcrumbs }, func() {
+				c.Breadcrumbs = nil
+			})
+		},
+		func() {
+			size -= dropLargeContext(c, size-maxBytes)
+		},
+		func() {
+			budget := variablesSize(c.LocalVariables) - (size - maxBytes)
+			for budget > 0 && len(c.LocalVariables) > 0 && size > maxBytes {
+				resize(locals, func() {
+					c.OmittedLocals += fitVariables(c.LocalVariables, budget)
+				})
+				budget /= 2
+			}
+		},
+		func() {
+			resize(locals, func() {
+				c.OmittedLocals += len(c.LocalVariables)
+				c.LocalVariables = map[string]Variable{}
+			})
+		},
+		func() {
+			resize(func() interface{} { return c.StackTrace }, func() {
+				if len(c.StackTrace) > 10 {
+					c.StackTrace = c.StackTrace[:10]
+				}
+			})
+		},
+		func() {
+			resize(func() interface{} { return c.Context }, func() {
+				c.Context = map[string]interface{}{"payload_truncated": true}
+			})
+		},
+	}
+
+	exact := true
+	for _, step := range steps {
+		if size <= maxBytes {
+			if exact {
+				return true
+			}
+			if size, exact = payloadSize(c), true; size <= maxBytes {
+				return true
+			}
+		}
+		step()
+		exact = false
+	}
+	return payloadSize(c) <= maxBytes
+}
+
+// localsPart encodes the local variables and the count of those omitted
+// as a capture does, so that a count becoming non-zero is sized with its
+// field name.
+type localsPart struct {
+	Vars    map[string]Variable `json:"local_variables"`
+	Omitted int                 `json:"omitted_local_variables,omitempty"`
+}
+
+// largeContextEntry is the encoded size from which context entries are
+// dropped before local variables.
+const largeContextEntry = 1024
+
+// truncatedMarker is the size of the context entry marking dropped entries.
+var truncatedMarker = len(`,"payload_truncated":true`)
+
+// dropLargeContext drops context entries of at least largeContextEntry
+// bytes, largest first, until their sizes add up to excess, and returns
+// the bytes saved, not counting their keys. Context values are kept as
+// passed in, so a large object passed as context is sent in full unless
+// dropped here.
+func dropLargeContext(c *ExceptionCapture, excess int) (saved int) {
+	sizes := make(map[string]int, len(c.Context))
+	keys := make([]string, 0, len(c.Context))
+	for key, value := range c.Context {
+		sizes[key] = encodedSize(value)
+		keys = append(keys, key)
+	}
+	sort.Slice(keys, func(i, j int) bool { return sizes[keys[i]] > sizes[keys[j]] })
+
+	for _, key := range keys {
+		if sizes[key] < largeContextEntry || saved >= excess {
+			break
+		}
+		delete(c.Context, key)
+		saved += sizes[key]
+	}
+	if saved > 0 {
+		c.Context["payload_truncated"] = true
+		saved -= truncatedMarker
+	}
+	return saved
+}
+
+// payloadSize returns the size of the JSON encoding of a capture.
+func payloadSize(c *ExceptionCapture) int {
+	return encodedSize(c)
+}
+
+// encodedSize returns the size of the JSON encoding of v, or the largest
+// int if it cannot be encoded.
+func encodedSize(v interface{}) int {
+	data, err := json.Marshal(v)
+	if err != nil {
+		return math.MaxInt
+	}
+	return len(data)
+}
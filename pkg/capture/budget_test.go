@@ -0,0 +1,100 @@
+package capture
+
+import (
+	"encoding/json"
+	"strings"
+	"testing"
+)
+
+// tree is a node of a value that is both wide and deep.
+type tree struct {
+	Label    string
+	Children []*tree
+}
+
+// wideDeepTree returns a tree depth levels deep in which every node has
+// width children. The children of a node share one subtree, so the value
+// is small in memory but has width^depth paths to walk.
+func wideDeepTree(width, depth int) *tree {
+	node := &tree{Label: strings.Repeat("x", 50)}
+	for i := 1; i < depth; i++ {
+		parent := &tree{Label: strings.Repeat("x", 50)}
+		for j := 0; j < width; j++ {
+			parent.Children = append(parent.Children, node)
+		}
+		node = parent
+	}
+	return node
+}
+
+// treeError carries a tree as a field, so it is captured as a local
+// variable but not kept as context.
+type treeError struct {
+	Tree *tree
+}
+
+func (e *treeError) Error() string { return "tree error" }
+
+func TestCaptureErrorPayloadBudgetWideDeep(t *testing.T) {
+	const budget = 32 * 1024
+	err := &treeError{Tree: wideDeepTree(100, 10)}
+	exc := CaptureErrorWithOptions(err, Options{MaxDepth: 10, MaxPayloadBytes: budget}, map[string]interface{}{
+		"request_id": "req-1",
+	})
+
+	// The variable budget is approximate; FitPayload enforces the limit.
+	data, jsonErr := json.Marshal(exc.LocalVariables)
+	if jsonErr != nil {
+		t.Fatal(jsonErr)
+	}
+	if limit := budget + budget/2; len(data) > limit {
+		t.Errorf("local variables encode to %d bytes, want about %d", len(data), budget)
+	}
+
+	if v := exc.LocalVariables["request_id"]; v.Value != "req-1" {
+		t.Errorf("request_id = %+v, want the shallow value kept", v)
+	}
+	root, ok := exc.LocalVariables["err.0.Tree"]
+	if !ok {
+		t.Fatal("err.0.Tree dropped; shallow variables should be kept")
+	}
+	if !hasTruncation(root, TruncatedPayloadSize) {
+		t.Error("no variable of tree marked TruncatedPayloadSize")
+	}
+}
+
+func TestFitPayloadWideDeep(t *testing.T) {
+	const maxBytes = 16 * 1024
+	err := &treeError{Tree: wideDeepTree(100, 10)}
+	exc := CaptureErrorWithOptions(err, Options{MaxDepth: 10, MaxPayloadBytes: 4 * maxBytes}, nil)
+
+	if !FitPayload(exc, maxBytes) {
+		t.Fatal("FitPayload = false")
+	}
+	data, jsonErr := json.Marshal(exc)
+	if jsonErr != nil {
+		t.Fatal(jsonErr)
+	}
+	if len(data) > maxBytes {
+		t.Errorf("capture encodes to %d bytes, want at most %d", len(data), maxBytes)
+	}
+}
+
+// hasTruncation reports whether v or one of its descendants is truncated
+// for reason.
+func hasTruncation(v Variable, reason TruncationReason) bool {
+	if v.TruncationReason == reason {
+		return true
+	}
+	for _, child := range v.Children {
+		if hasTruncation(child, reason) {
+			return true
+		}
+	}
+	for _, element := range v.ArrayElements {
+		if hasTruncation(element, reason) {
+			return true
+		}
+	}
+	return false
+}
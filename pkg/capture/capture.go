@@ -3,12 +3,17 @@ package capture
 
 import (
 	"crypto/sha256"
+	"encoding"
 	"encoding/hex"
+	"encoding/json"
 	"fmt"
 	"reflect"
 	"runtime"
+	"sort"
 	"strings"
+	"sync"
 	"time"
+	"unicode/utf8"
 
 	"github.com/google/uuid"
 )
@@ -23,85 +28,417 @@ type RuntimeInfo struct {
 	NumGoroutine   int    `json:"num_goroutine"`
 }
 
+// BuildInfo holds build metadata of the monitored application.
+type BuildInfo struct {
+	Version string `json:"version,omitempty"`
+	Commit  string `json:"commit,omitempty"`
+	Time    string `json:"time,omitempty"`
+
+	// ModulePath is the path of the main module and GoVersion the Go
+	// version the binary was built with.
+	ModulePath string `json:"module_path,omitempty"`
+	GoVersion  string `json:"go_version,omitempty"`
+
+	// VCS is the version control system the commit is from, e.g. "git",
+	// and Modified reports that the working tree had uncommitted changes.
+	VCS      string `json:"vcs,omitempty"`
+	Modified bool   `json:"vcs_modified,omitempty"`
+
+	// Dependencies are the modules linked into the binary, sorted by
+	// path and capped; OmittedDependencies counts those beyond the cap.
+	Dependencies        []ModuleInfo `json:"dependencies,omitempty"`
+	OmittedDependencies int          `json:"omitted_dependencies,omitempty"`
+}
+
+// ModuleInfo identifies a module dependency. Replace is the path of the
+// module replacing it, if any, and Version is then the replacement's.
+type ModuleInfo struct {
+	Path    string `json:"path"`
+	Version string `json:"version,omitempty"`
+	Replace string `json:"replace,omitempty"`
+}
+
 // ExceptionCapture holds captured exception data.
 type ExceptionCapture struct {
-	ID             string                 `json:"id"`
-	ExceptionType  string                 `json:"exception_type"`
-	Message        string                 `json:"message"`
-	Fingerprint    string                 `json:"fingerprint"`
-	StackTrace     []StackFrame           `json:"stack_trace"`
-	LocalVariables map[string]Variable    `json:"local_variables"`
-	Context        map[string]interface{} `json:"context"`
-	CapturedAt     string                 `json:"captured_at"`
-	AgentID        string                 `json:"agent_id"`
-	Environment    string                 `json:"environment"`
-	Runtime        string                 `json:"runtime"`
-	RuntimeInfo    RuntimeInfo            `json:"runtime_info"`
+	ID               string                 `json:"id"`
+	ExceptionType    string                 `json:"exception_type"`
+	Message          string                 `json:"message"`
+	Level            Level                  `json:"level"`
+	Fingerprint      string                 `json:"fingerprint"`
+	FingerprintMode  FingerprintMode        `json:"fingerprint_mode,omitempty"`
+	OccurrenceCount  int                    `json:"occurrence_count,omitempty"`
+	StackTrace       []StackFrame           `json:"stack_trace"`
+	LocalVariables   map[string]Variable    `json:"local_variables"`
+	OmittedLocals    int                    `json:"omitted_local_variables,omitempty"`
+	OmittedFields    int                    `json:"omitted_error_fields,omitempty"`
+	Context          map[string]interface{} `json:"context"`
+	CapturedAt       string                 `json:"captured_at"`
+	AgentID          string                 `json:"agent_id"`
+	Environment      string                 `json:"environment"`
+	Release          string                 `json:"release,omitempty"`
+	Runtime          string                 `json:"runtime"`
+	RuntimeInfo      RuntimeInfo            `json:"runtime_info"`
+	Build            *BuildInfo             `json:"build,omitempty"`
+	Breadcrumbs      []Breadcrumb           `json:"breadcrumbs,omitempty"`
+	RecentLogs       []string               `json:"recent_logs,omitempty"`
+	Process          *ProcessContext        `json:"process,omitempty"`
+	Host             *HostInfo              `json:"host,omitempty"`
+	Memory           *MemStats              `json:"memory,omitempty"`
+	FeatureFlags     map[string]bool        `json:"feature_flags,omitempty"`
+	Tags             map[string]string      `json:"tags,omitempty"`
+	MatchedSentinels []string               `json:"matched_sentinels,omitempty"`
+	AllGoroutines    []GoroutineInfo        `json:"all_goroutines,omitempty"`
+}
+
+// Breadcrumb records an event that happened before a capture.
+type Breadcrumb struct {
+	Timestamp string                 `json:"timestamp"`
+	Category  string                 `json:"category,omitempty"`
+	Message   string                 `json:"message"`
+	Data      map[string]interface{} `json:"data,omitempty"`
 }
 
 // StackFrame represents a single frame in the stack trace.
 type StackFrame struct {
-	MethodName      string `json:"method_name"`
-	FileName        string `json:"file_name,omitempty"`
-	FilePath        string `json:"file_path,omitempty"`
-	LineNumber      int    `json:"line_number,omitempty"`
-	PackageName     string `json:"package_name,omitempty"`
-	IsNative        bool   `json:"is_native"`
-	SourceAvailable bool   `json:"source_available"`
+	MethodName      string   `json:"method_name"`
+	Function        string   `json:"function,omitempty"`
+	FileName        string   `json:"file_name,omitempty"`
+	FilePath        string   `json:"file_path,omitempty"`
+	LineNumber      int      `json:"line_number,omitempty"`
+	PackageName     string   `json:"package_name,omitempty"`
+	IsNative        bool     `json:"is_native"`
+	SourceAvailable bool     `json:"source_available"`
+	InApp           bool     `json:"in_app"`
+	SourceContext   []string `json:"source_context,omitempty"`
+	SourceLineIndex int      `json:"source_line_index,omitempty"`
 }
 
 // Variable represents a captured variable.
 type Variable struct {
-	Name          string              `json:"name"`
-	Type          string              `json:"type"`
-	Value         string              `json:"value"`
-	IsNull        bool                `json:"is_null"`
-	IsTruncated   bool                `json:"is_truncated"`
-	Children      map[string]Variable `json:"children,omitempty"`
-	ArrayElements []Variable          `json:"array_elements,omitempty"`
-	ArrayLength   *int                `json:"array_length,omitempty"`
+	Name             string              `json:"name"`
+	Type             string              `json:"type"`
+	Value            string              `json:"value"`
+	IsNull           bool                `json:"is_null"`
+	IsTruncated      bool                `json:"is_truncated"`
+	TruncationReason TruncationReason    `json:"truncation_reason,omitempty"`
+	OriginalLength   int                 `json:"original_length,omitempty"`
+	IsRedacted       bool                `json:"is_redacted,omitempty"`
+	IsCycle          bool                `json:"is_cycle,omitempty"`
+	IsUnexported     bool                `json:"is_unexported,omitempty"`
+	OmittedFields    int                 `json:"omitted_fields,omitempty"`
+	Children         map[string]Variable `json:"children,omitempty"`
+	ArrayElements    []Variable          `json:"array_elements,omitempty"`
+	ArrayLength      *int                `json:"array_length,omitempty"`
+	TotalFields      *int                `json:"total_fields,omitempty"`
+	TotalEntries     *int                `json:"total_entries,omitempty"`
+}
+
+// Options controls how much data a capture collects.
+type Options struct {
+	// MaxDepth limits how deep nested values are walked.
+	MaxDepth int
+	// MaxLocalVariables caps the number of top-level local variables.
+	// Zero means no limit.
+	MaxLocalVariables int
+	// RedactKeys are matched case-insensitively against variable, map key
+	// and struct field names; values of matching names are replaced with
+	// "[REDACTED]" before any other processing.
+	RedactKeys []string
+	// RedactFunc, if set, is called for every captured scalar value after
+	// the built-in rules have been applied.
+	RedactFunc RedactFunc
+	// UseJSONMarshaler captures values (including errors) that implement
+	// json.Marshaler from their marshaled form instead of by reflection.
+	UseJSONMarshaler bool
+	// RespectJSONTags captures struct fields the way encoding/json would
+	// serialize them: fields tagged `json:"-"` are skipped, fields are
+	// named by their JSON name, and values implementing json.Marshaler are
+	// captured from their marshaled form as with UseJSONMarshaler.
+	RespectJSONTags bool
+	// TopFrameSource attaches source snippets to the top N in-app frames.
+	// Zero disables source capture.
+	TopFrameSource int
+	// CaptureSource attaches source snippets to every frame whose source
+	// is available, not only the top in-app ones. It overrides
+	// TopFrameSource.
+	CaptureSource bool
+	// IDGenerator returns the ID of a new capture. Defaults to a random
+	// UUID v4.
+	IDGenerator func() string
+	// MaxStructFields caps the exported fields captured per struct,
+	// including error structs. Defaults to 100.
+	MaxStructFields int
+	// MaxStringLength truncates captured strings. Defaults to 1000.
+	MaxStringLength int
+	// MaxCollectionSize caps the elements captured per slice, array or
+	// map. Defaults to 100.
+	MaxCollectionSize int
+	// MaxErrorChainDepth caps how many errors of the Unwrap chain have
+	// their fields extracted. Defaults to 10.
+	MaxErrorChainDepth int
+	// CaptureAllGoroutines attaches the stacks of all goroutines, up to
+	// MaxCapturedGoroutines of them.
+	CaptureAllGoroutines bool
+	// FingerprintMode selects how captures are grouped. Defaults to
+	// FingerprintStackOnly.
+	FingerprintMode FingerprintMode
+	// Fingerprinter computes the fingerprint in FingerprintCustom mode.
+	Fingerprinter Fingerprinter
+	// StableFingerprint leaves line numbers out of FingerprintStackOnly
+	// fingerprints so they survive code moving within a function.
+	StableFingerprint bool
+	// CaptureUnexported reads unexported struct fields, including those
+	// of errors, for display, marking them IsUnexported. It bypasses Go's
+	// visibility rules and may read fields that are being written
+	// concurrently.
+	CaptureUnexported bool
+	// MaxPayloadBytes, if positive, is the approximate budget for the
+	// JSON size of the captured variables. Once it is exhausted, nested
+	// values are dropped before shallow ones and their parents marked
+	// truncated with TruncatedPayloadSize; see also FitPayload.
+	MaxPayloadBytes int
+	// TrimPathPrefix is stripped from the file paths of stack frames, so
+	// they are reported relative to it. Source snippets are read before.
+	TrimPathPrefix string
+	// Callers, if set, are the program counters the stack trace is built
+	// from instead of the stack of the capturing goroutine; see Callers.
+	Callers []uintptr
+	// MaxStackFrames caps the frames of a stack trace, not counting
+	// runtime frames. Defaults to 50.
+	MaxStackFrames int
+	// IncludeRuntimeFrames keeps the frames of the Go runtime, such as
+	// runtime.mapassign under a nil map panic, marked IsNative. They are
+	// dropped by default and never part of the fingerprint.
+	IncludeRuntimeFrames bool
+}
+
+const (
+	defaultMaxStringLength    = 1000
+	defaultMaxCollectionSize  = 100
+	defaultMaxStructFields    = 100
+	defaultMaxErrorChainDepth = 10
+)
+
+// NewID returns a new capture ID from the configured generator, or a
+// random UUID v4 if none is set.
+func (o Options) NewID() string {
+	if o.IDGenerator != nil {
+		return o.IDGenerator()
+	}
+	return uuid.New().String()
 }
 
+// RedactFunc decides whether a captured scalar should be redacted. It
+// receives the variable name, type and rendered value, and returns the
+// replacement value and true to redact, or false to keep the value.
+type RedactFunc func(name, typ, value string) (string, bool)
+
+// Level is the severity of a capture.
+type Level string
+
+// Severity levels, from most to least severe.
+const (
+	LevelFatal   Level = "fatal"
+	LevelError   Level = "error"
+	LevelWarning Level = "warning"
+	LevelInfo    Level = "info"
+	LevelDebug   Level = "debug"
+)
+
+// Valid returns true if l is one of the defined levels.
+func (l Level) Valid() bool {
+	switch l {
+	case LevelFatal, LevelError, LevelWarning, LevelInfo, LevelDebug:
+		return true
+	}
+	return false
+}
+
+// FingerprintMode selects which parts of an error determine its
+// fingerprint, and so how captures are grouped.
+type FingerprintMode string
+
+// Fingerprint modes.
+const (
+	// FingerprintStackOnly groups by error type and the top stack frames.
+	FingerprintStackOnly FingerprintMode = "stack_only"
+	// FingerprintMessageOnly groups by error message.
+	FingerprintMessageOnly FingerprintMode = "message_only"
+	// FingerprintTypeAndMessage groups by error type and message.
+	FingerprintTypeAndMessage FingerprintMode = "type_and_message"
+	// FingerprintCustom groups by the result of Options.Fingerprinter.
+	FingerprintCustom FingerprintMode = "custom"
+)
+
+// Fingerprinter computes a custom fingerprint for an error and its stack.
+type Fingerprinter func(err error, stackTrace []StackFrame) string
+
+// TruncationReason explains why a captured value was truncated.
+type TruncationReason string
+
+// Truncation reasons.
+const (
+	// TruncatedDepth means the value was nested deeper than MaxDepth.
+	TruncatedDepth TruncationReason = "depth"
+	// TruncatedLength means a string was longer than the length limit.
+	TruncatedLength TruncationReason = "length"
+	// TruncatedCount means a collection had more elements than the limit.
+	TruncatedCount TruncationReason = "count"
+	// TruncatedPayloadSize means the overall capture size budget ran out.
+	TruncatedPayloadSize TruncationReason = "payload_size"
+)
+
 // CaptureError captures an error with stack trace and context.
 func CaptureError(err error, maxDepth int, ctx map[string]interface{}) *ExceptionCapture {
-	stackTrace := captureStackTrace(3) // Skip CaptureError, CaptureError, agent.CaptureError
-	fingerprint := calculateFingerprint(err, stackTrace)
+	return captureError(err, Options{MaxDepth: maxDepth}, ctx)
+}
+
+// CaptureErrorWithOptions captures an error with stack trace and context
+// using the given capture options.
+func CaptureErrorWithOptions(err error, opts Options, ctx map[string]interface{}) *ExceptionCapture {
+	return captureError(err, opts, ctx)
+}
+
+func captureError(err error, opts Options, ctx map[string]interface{}) *ExceptionCapture {
+	var stackTrace []StackFrame
+	if opts.Callers != nil {
+		stackTrace = stackFrames(opts.Callers, opts)
+	} else {
+		stackTrace = captureStackTrace(opts)
+	}
+	fingerprint, fingerprintMode := opts.fingerprint(err, stackTrace)
+
+	switch {
+	case opts.CaptureSource:
+		attachAllSource(stackTrace)
+	case opts.TopFrameSource > 0:
+		attachSource(stackTrace, opts.TopFrameSource)
+	}
 
 	context := make(map[string]interface{})
-	if ctx != nil {
-		for k, v := range ctx {
-			context[k] = v
+	for k, v := range ctx {
+		if IsSensitiveName(k, opts.RedactKeys) {
+			v = RedactedValue
 		}
+		context[k] = v
 	}
 
 	// Capture local variables from context and error
-	localVariables := make(map[string]Variable)
+	var locals *localVars
+	if opts.MaxPayloadBytes > 0 {
+		locals = captureLocalsWithin(err, opts, ctx)
+	} else {
+		locals = captureLocals(err, opts, ctx)
+	}
 
-	// Capture context values as local variables
-	for key, value := range ctx {
-		localVariables[key] = captureValue(key, value, 0, maxDepth)
+	var goroutines []GoroutineInfo
+	if opts.CaptureAllGoroutines {
+		goroutines = CaptureGoroutines(MaxCapturedGoroutines)
 	}
 
-	// Extract fields from the error if it's a struct
-	extractErrorFields(err, localVariables, maxDepth)
+	if opts.TrimPathPrefix != "" {
+		trimPaths(stackTrace, opts.TrimPathPrefix)
+		trimGoroutinePaths(goroutines, opts.TrimPathPrefix)
+	}
+
+	return &ExceptionCapture{
+		ID:              opts.NewID(),
+		ExceptionType:   getErrorType(err),
+		Message:         err.Error(),
+		Fingerprint:     fingerprint,
+		FingerprintMode: fingerprintMode,
+		StackTrace:      stackTrace,
+		LocalVariables:  locals.vars,
+		OmittedLocals:   locals.omitted,
+		OmittedFields:   locals.omittedErrorFields,
+		Context:         context,
+		CapturedAt:      time.Now().UTC().Format(time.RFC3339),
+		AllGoroutines:   goroutines,
+	}
+}
+
+// captureLocals captures the context values and the fields of the error
+// chain as local variables.
+func captureLocals(err error, opts Options, ctx map[string]interface{}) *localVars {
+	c := &capturer{opts: opts}
+	locals := newLocalVars(opts.MaxLocalVariables)
+
+	// Capture context values as local variables, in a stable order so
+	// the same variables are kept when the limit is reached
+	keys := make([]string, 0, len(ctx))
+	for key := range ctx {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	for _, key := range keys {
+		if locals.full() {
+			locals.omitted++
+			continue
+		}
+		locals.vars[key] = c.local(key, ctx[key])
+	}
+
+	// Extract fields from the error and each error it wraps
+	c.extractErrorChain(err, locals)
 
 	// Try to extract wrapped error chain
-	extractWrappedErrors(err, localVariables, maxDepth)
+	c.extractWrappedErrors(err, locals)
 
-	return &ExceptionCapture{
-		ID:             uuid.New().String(),
-		ExceptionType:  getErrorType(err),
-		Message:        err.Error(),
-		Fingerprint:    fingerprint,
-		StackTrace:     stackTrace,
-		LocalVariables: localVariables,
-		Context:        context,
-		CapturedAt:     time.Now().UTC().Format(time.RFC3339),
+	return locals
+}
+
+// localVars collects top-level variables up to a configured limit.
+type localVars struct {
+	vars    map[string]Variable
+	limit   int
+	omitted int
+
+	// omittedErrorFields counts error struct fields over MaxStructFields.
+	omittedErrorFields int
+}
+
+func newLocalVars(limit int) *localVars {
+	return &localVars{
+		vars:  make(map[string]Variable),
+		limit: limit,
+	}
+}
+
+// full returns true if no more variables may be added.
+func (l *localVars) full() bool {
+	return l.limit > 0 && len(l.vars) >= l.limit
+}
+
+// set stores a variable, counting it as omitted if the limit is reached.
+func (l *localVars) set(name string, v Variable) {
+	if _, exists := l.vars[name]; !exists && l.full() {
+		l.omitted++
+		return
+	}
+	l.vars[name] = v
+}
+
+// extractErrorChain extracts the fields of err and of the errors it wraps
+// via Unwrap() error, up to MaxErrorChainDepth errors. Fields are
+// namespaced by chain position, "err.0.Field" for err itself and
+// "err.1.Field" for the error it wraps, so they never collide.
+func (c *capturer) extractErrorChain(err error, vars *localVars) {
+	maxDepth := c.maxErrorChainDepth()
+	for depth := 0; err != nil && depth < maxDepth; depth++ {
+		c.extractErrorFields(errorValue(err), fmt.Sprintf("err.%d", depth), vars)
+
+		unwrapper, ok := err.(interface{ Unwrap() error })
+		if !ok {
+			break
+		}
+		err = unwrapper.Unwrap()
 	}
 }
 
-// extractErrorFields extracts public fields from a custom error type.
-func extractErrorFields(err error, vars map[string]Variable, maxDepth int) {
+// extractErrorFields extracts public fields, and unexported ones if
+// CaptureUnexported is set, from a custom error type or panic value,
+// naming them prefix.Field.
+func (c *capturer) extractErrorFields(err interface{}, prefix string, vars *localVars) {
 	v := reflect.ValueOf(err)
 	if v.Kind() == reflect.Ptr {
 		if v.IsNil() {
@@ -110,40 +447,130 @@ func extractErrorFields(err error, vars map[string]Variable, maxDepth int) {
 		v = v.Elem()
 	}
 
+	if c.useJSONMarshaler() {
+		if m, ok := err.(json.Marshaler); ok {
+			if decoded, ok := marshalJSON(m); ok {
+				c.extractMarshaledFields(decoded, prefix, vars)
+				return
+			}
+		}
+	}
+
 	if v.Kind() != reflect.Struct {
 		return
 	}
 
+	v = c.readableStruct(v)
 	t := v.Type()
-	for i := 0; i < t.NumField() && i < 50; i++ {
+	maxFields := c.maxStructFields()
+	captured := 0
+	for i := 0; i < t.NumField(); i++ {
 		field := t.Field(i)
-		if !field.IsExported() {
+		fieldValue, ok := c.fieldValue(v, i)
+		if !ok {
 			continue
 		}
 
-		fieldValue := v.Field(i)
-		if !fieldValue.CanInterface() {
+		name, skip := c.fieldName(field)
+		if skip {
 			continue
 		}
 
-		fieldName := "err." + field.Name
-		vars[fieldName] = captureValue(fieldName, fieldValue.Interface(), 0, maxDepth)
+		if captured >= maxFields {
+			vars.omittedErrorFields++
+			continue
+		}
+		captured++
+
+		fieldName := prefix + "." + name
+		if vars.full() {
+			vars.omitted++
+			continue
+		}
+		variable := c.local(fieldName, fieldValue)
+		variable.IsUnexported = !field.IsExported()
+		vars.set(fieldName, variable)
 	}
 }
 
+// extractMarshaledFields stores the decoded JSON form of an error as
+// top-level variables, one per object key.
+func (c *capturer) extractMarshaledFields(decoded interface{}, prefix string, vars *localVars) {
+	fields, ok := decoded.(map[string]interface{})
+	if !ok {
+		vars.set(prefix, c.local(prefix, decoded))
+		return
+	}
+
+	keys := make([]string, 0, len(fields))
+	for key := range fields {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+
+	for _, key := range keys {
+		fieldName := prefix + "." + key
+		if vars.full() {
+			vars.omitted++
+			continue
+		}
+		vars.set(fieldName, c.local(fieldName, fields[key]))
+	}
+}
+
+// useJSONMarshaler returns true if json.Marshaler values are captured from
+// their marshaled form.
+func (c *capturer) useJSONMarshaler() bool {
+	return c.opts.UseJSONMarshaler || c.opts.RespectJSONTags
+}
+
+// fieldName returns the name a struct field is captured under and whether
+// it is skipped. With RespectJSONTags it follows the field's json tag.
+func (c *capturer) fieldName(field reflect.StructField) (name string, skip bool) {
+	if !c.opts.RespectJSONTags {
+		return field.Name, false
+	}
+
+	tag := field.Tag.Get("json")
+	if tag == "-" {
+		return "", true
+	}
+	if name, _, _ = strings.Cut(tag, ","); name != "" {
+		return name, false
+	}
+	return field.Name, false
+}
+
+// marshalJSON calls MarshalJSON and decodes the result into generic Go
+// values. It returns false if the marshaler panics, fails or produces
+// invalid JSON.
+func marshalJSON(m json.Marshaler) (decoded interface{}, ok bool) {
+	defer func() {
+		if r := recover(); r != nil {
+			decoded, ok = nil, false
+		}
+	}()
+
+	data, err := m.MarshalJSON()
+	if err != nil || !json.Valid(data) {
+		return nil, false
+	}
+	if err := json.Unmarshal(data, &decoded); err != nil {
+		return nil, false
+	}
+	return decoded, true
+}
+
 // extractWrappedErrors extracts information from wrapped errors.
-func extractWrappedErrors(err error, vars map[string]Variable, maxDepth int) {
+func (c *capturer) extractWrappedErrors(err error, vars *localVars) {
 	// Check for Unwrap() error (Go 1.13+ wrapped errors)
 	if unwrapper, ok := err.(interface{ Unwrap() error }); ok {
 		if inner := unwrapper.Unwrap(); inner != nil {
-			vars["wrapped_error"] = Variable{
+			vars.set("wrapped_error", Variable{
 				Name:  "wrapped_error",
 				Type:  getErrorType(inner),
 				Value: inner.Error(),
-			}
-
-			// Recursively extract from wrapped error
-			extractErrorFields(inner, vars, maxDepth)
+			})
 		}
 	}
 
@@ -163,64 +590,204 @@ func extractWrappedErrors(err error, vars map[string]Variable, maxDepth int) {
 				})
 			}
 			length := len(errors)
-			vars["wrapped_errors"] = Variable{
+			vars.set("wrapped_errors", Variable{
 				Name:          "wrapped_errors",
 				Type:          "[]error",
 				Value:         fmt.Sprintf("[%d errors]", length),
 				ArrayElements: elements,
 				ArrayLength:   &length,
-			}
+			})
 		}
 	}
 
 	// Check for Cause() error (pkg/errors style)
 	if causer, ok := err.(interface{ Cause() error }); ok {
 		if cause := causer.Cause(); cause != nil {
-			vars["cause"] = Variable{
+			vars.set("cause", Variable{
 				Name:  "cause",
 				Type:  getErrorType(cause),
 				Value: cause.Error(),
-			}
+			})
 		}
 	}
 }
 
 // CaptureValue captures an arbitrary value.
 func CaptureValue(name string, value interface{}, maxDepth int) Variable {
-	return captureValue(name, value, 0, maxDepth)
+	return CaptureValueWithOptions(name, value, Options{MaxDepth: maxDepth})
 }
 
-func captureStackTrace(skip int) []StackFrame {
-	var frames []StackFrame
-	pcs := make([]uintptr, 50)
-	n := runtime.Callers(skip, pcs)
-	pcs = pcs[:n]
+// CaptureValueWithOptions captures an arbitrary value using the given
+// capture options.
+func CaptureValueWithOptions(name string, value interface{}, opts Options) Variable {
+	c := &capturer{opts: opts}
+	return c.local(name, value)
+}
+
+// capturer walks values according to a set of capture options.
+type capturer struct {
+	opts Options
+
+	// visiting holds the pointers, maps and slices on the current capture
+	// path, to detect values that refer back to an ancestor.
+	visiting map[visitKey]bool
+
+	// built approximates the size of the variables built so far at each
+	// depth.
+	built []int
+}
+
+// visitKey identifies a referenced value. The type is included because a
+// struct and its first field share an address.
+type visitKey struct {
+	ptr uintptr
+	typ reflect.Type
+}
+
+// enter marks a pointer, map or slice as being captured. It returns false
+// if the value is already on the capture path, i.e. it forms a cycle.
+func (c *capturer) enter(v reflect.Value) bool {
+	key := visitKey{ptr: v.Pointer(), typ: v.Type()}
+	if c.visiting[key] {
+		return false
+	}
+	if c.visiting == nil {
+		c.visiting = make(map[visitKey]bool)
+	}
+	c.visiting[key] = true
+	return true
+}
+
+// leave removes a value marked by enter from the capture path.
+func (c *capturer) leave(v reflect.Value) {
+	delete(c.visiting, visitKey{ptr: v.Pointer(), typ: v.Type()})
+}
+
+// cycle returns the variable emitted for a value that refers back to one
+// of its ancestors.
+func cycle(name string, t reflect.Type) Variable {
+	return Variable{
+		Name:    name,
+		Type:    t.String(),
+		Value:   "<cycle>",
+		IsCycle: true,
+	}
+}
+
+func (c *capturer) maxStringLength() int {
+	if c.opts.MaxStringLength > 0 {
+		return c.opts.MaxStringLength
+	}
+	return defaultMaxStringLength
+}
+
+func (c *capturer) maxCollectionSize() int {
+	if c.opts.MaxCollectionSize > 0 {
+		return c.opts.MaxCollectionSize
+	}
+	return defaultMaxCollectionSize
+}
+
+func (c *capturer) maxErrorChainDepth() int {
+	if c.opts.MaxErrorChainDepth > 0 {
+		return c.opts.MaxErrorChainDepth
+	}
+	return defaultMaxErrorChainDepth
+}
+
+func (c *capturer) maxStructFields() int {
+	if c.opts.MaxStructFields > 0 {
+		return c.opts.MaxStructFields
+	}
+	return defaultMaxStructFields
+}
 
+// redact applies the custom redaction function to a scalar variable.
+func (c *capturer) redact(v Variable) Variable {
+	if c.opts.RedactFunc == nil {
+		return v
+	}
+	if value, ok := c.opts.RedactFunc(v.Name, v.Type, v.Value); ok {
+		v.Value = value
+		v.IsRedacted = true
+	}
+	return v
+}
+
+const (
+	// defaultMaxStackFrames bounds the number of frames in a captured
+	// stack trace unless Options.MaxStackFrames is set.
+	defaultMaxStackFrames = 50
+
+	// maxCallers bounds the program counters recorded for a stack, and so
+	// the depth of any stack trace.
+	maxCallers = 256
+)
+
+// maxStackFrames returns the maximum number of frames of a stack trace.
+func (o Options) maxStackFrames() int {
+	if o.MaxStackFrames > 0 {
+		return o.MaxStackFrames
+	}
+	return defaultMaxStackFrames
+}
+
+// CaptureStackTrace returns the stack of the calling goroutine, starting at
+// the first frame outside the agent.
+func CaptureStackTrace() []StackFrame {
+	return captureStackTrace(Options{})
+}
+
+// captureStackTrace returns the stack of the calling goroutine, starting
+// at the first frame outside the agent. The frames of the agent's own
+// packages on top of the stack are dropped, however the capture was
+// entered (the package-level functions, Agent methods or a panic handler),
+// so the trace always starts in application code.
+func captureStackTrace(opts Options) []StackFrame {
+	pcs := make([]uintptr, maxCallers)
+	n := runtime.Callers(2, pcs) // Skip runtime.Callers and captureStackTrace
+	return stackFrames(pcs[:n], opts)
+}
+
+// Callers returns the program counters of the calling goroutine's stack.
+// Recording them is much cheaper than building a stack trace, which can
+// be done later, on another goroutine, through Options.Callers.
+func Callers() []uintptr {
+	pcs := make([]uintptr, maxCallers)
+	n := runtime.Callers(2, pcs) // Skip runtime.Callers and Callers
+	return pcs[:n]
+}
+
+// stackFrames resolves program counters into stack frames, dropping the
+// agent's frames on top of the stack and, unless opts includes them,
+// runtime frames.
+func stackFrames(pcs []uintptr, opts Options) []StackFrame {
+	var frames []StackFrame
+	maxFrames := opts.maxStackFrames()
+	counted := 0
 	frameIter := runtime.CallersFrames(pcs)
-	for {
-		frame, more := frameIter.Next()
-		if !more {
-			break
-		}
+	leading := true
+	for more := true; more; {
+		var frame runtime.Frame
+		frame, more = frameIter.Next()
 
 		// Skip runtime internals
-		if strings.HasPrefix(frame.Function, "runtime.") {
+		if isRuntimeFrame(frame) && !opts.IncludeRuntimeFrames {
 			continue
 		}
-
-		f := StackFrame{
-			MethodName:      extractFunctionName(frame.Function),
-			FilePath:        frame.File,
-			FileName:        extractFileName(frame.File),
-			LineNumber:      frame.Line,
-			PackageName:     extractPackageName(frame.Function),
-			IsNative:        strings.HasPrefix(frame.File, "runtime/"),
-			SourceAvailable: !strings.Contains(frame.File, "/pkg/mod/"),
+		if leading && isAgentFunction(frame.Function) {
+			continue
 		}
+		leading = false
 
-		frames = append(frames, f)
+		frames = append(frames, newStackFrame(frame))
 
-		if len(frames) >= 50 {
+		// Runtime frames do not count toward the limit, so including
+		// them leaves the other frames, and the fingerprint, unchanged.
+		if !isRuntimeFrame(frame) {
+			counted++
+		}
+		if counted >= maxFrames {
 			break
 		}
 	}
@@ -228,7 +795,60 @@ func captureStackTrace(skip int) []StackFrame {
 	return frames
 }
 
-func captureValue(name string, value interface{}, depth, maxDepth int) Variable {
+// isRuntimeFrame returns true for frames of the Go runtime.
+func isRuntimeFrame(frame runtime.Frame) bool {
+	return strings.HasPrefix(frame.Function, "runtime.")
+}
+
+// newStackFrame converts a runtime frame into a StackFrame.
+func newStackFrame(frame runtime.Frame) StackFrame {
+	f := StackFrame{
+		MethodName:      extractFunctionName(frame.Function),
+		Function:        frame.Function,
+		FilePath:        frame.File,
+		FileName:        extractFileName(frame.File),
+		LineNumber:      frame.Line,
+		PackageName:     extractPackageName(frame.Function),
+		IsNative:        isRuntimeFrame(frame) || strings.HasPrefix(frame.File, "runtime/"),
+		SourceAvailable: !strings.Contains(frame.File, "/pkg/mod/"),
+	}
+	f.InApp = isInApp(frame, f)
+	return f
+}
+
+// UnreadableValue replaces a variable whose capture panicked.
+const UnreadableValue = "<unreadable: capture panicked>"
+
+// local captures a top-level variable.
+func (c *capturer) local(name string, value interface{}) Variable {
+	return c.value(name, value, 0)
+}
+
+// value captures a variable. A panic while walking it, e.g. in a
+// RedactFunc or on a value torn by a concurrent write, replaces only that
+// variable with UnreadableValue, so its parent and siblings are still
+// captured.
+//
+// Recovering does not make reading shared values safe: a plain map written
+// by another goroutine while it is captured is a data race, which the
+// runtime may detect and abort the program for without any chance to
+// recover. Capture a copy of such values, or hold them in a sync.Map, which
+// is read with Range.
+func (c *capturer) value(name string, value interface{}, depth int) (captured Variable) {
+	defer func() {
+		if r := recover(); r != nil {
+			captured = Variable{
+				Name:  name,
+				Type:  fmt.Sprintf("%T", value),
+				Value: UnreadableValue,
+			}
+		}
+	}()
+	return c.walk(name, value, depth)
+}
+
+// walk captures a variable and, up to the maximum depth, its children.
+func (c *capturer) walk(name string, value interface{}, depth int) Variable {
 	if value == nil {
 		return Variable{
 			Name:   name,
@@ -238,18 +858,77 @@ func captureValue(name string, value interface{}, depth, maxDepth int) Variable
 		}
 	}
 
-	if depth > maxDepth {
+	if c.overBudget(name, depth) {
+		return budgetTruncated(name, reflect.TypeOf(value).String())
+	}
+
+	// Redact by name first so no part of a secret is ever captured, not
+	// even a truncated prefix.
+	if IsSensitiveName(name, c.opts.RedactKeys) {
+		return Variable{
+			Name:       name,
+			Type:       reflect.TypeOf(value).String(),
+			Value:      RedactedValue,
+			IsRedacted: true,
+		}
+	}
+
+	if depth > c.opts.MaxDepth {
 		return Variable{
-			Name:        name,
-			Type:        reflect.TypeOf(value).String(),
-			Value:       "<max depth exceeded>",
-			IsTruncated: true,
+			Name:             name,
+			Type:             reflect.TypeOf(value).String(),
+			Value:            "<max depth exceeded>",
+			IsTruncated:      true,
+			TruncationReason: TruncatedDepth,
 		}
 	}
 
 	v := reflect.ValueOf(value)
 	t := v.Type()
 
+	if c.useJSONMarshaler() && !isNilValue(v) {
+		if m, ok := value.(json.Marshaler); ok {
+			if decoded, ok := marshalJSON(m); ok {
+				captured := c.value(name, decoded, depth)
+				captured.Type = t.String()
+				return captured
+			}
+		}
+	}
+
+	// Times and durations have no useful exported fields; show them the
+	// way they are written.
+	switch x := value.(type) {
+	case time.Time:
+		return c.redact(Variable{
+			Name:  name,
+			Type:  "time.Time",
+			Value: x.Format(time.RFC3339),
+		})
+	case *time.Time:
+		if x != nil {
+			return c.redact(Variable{
+				Name:  name,
+				Type:  "*time.Time",
+				Value: x.Format(time.RFC3339),
+			})
+		}
+	case time.Duration:
+		return c.redact(Variable{
+			Name:  name,
+			Type:  "time.Duration",
+			Value: x.String(),
+		})
+	case *sync.Map:
+		if x != nil {
+			return c.syncMap(name, x, depth)
+		}
+	}
+
+	if text, ok := textValue(value, v); ok {
+		return c.textVariable(name, text, v, depth)
+	}
+
 	switch v.Kind() {
 	case reflect.Invalid:
 		return Variable{
@@ -262,24 +941,26 @@ func captureValue(name string, value interface{}, depth, maxDepth int) Variable
 	case reflect.Bool, reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64,
 		reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64,
 		reflect.Float32, reflect.Float64, reflect.Complex64, reflect.Complex128:
-		return Variable{
+		return c.redact(Variable{
 			Name:  name,
 			Type:  t.String(),
 			Value: fmt.Sprintf("%v", value),
-		}
+		})
 
 	case reflect.String:
 		s := v.String()
-		truncated := len(s) > 1000
-		if truncated {
-			s = s[:1000]
+		captured := Variable{
+			Name:  name,
+			Type:  "string",
+			Value: s,
 		}
-		return Variable{
-			Name:        name,
-			Type:        "string",
-			Value:       s,
-			IsTruncated: truncated,
+		if maxLen := c.maxStringLength(); len(s) > maxLen {
+			captured.Value = TruncateString(s, maxLen)
+			captured.IsTruncated = true
+			captured.TruncationReason = TruncatedLength
+			captured.OriginalLength = len(s)
 		}
+		return c.redact(captured)
 
 	case reflect.Ptr, reflect.Interface:
 		if v.IsNil() {
@@ -290,74 +971,123 @@ func captureValue(name string, value interface{}, depth, maxDepth int) Variable
 				IsNull: true,
 			}
 		}
-		return captureValue(name, v.Elem().Interface(), depth, maxDepth)
+		if v.Kind() == reflect.Ptr {
+			if !c.enter(v) {
+				return cycle(name, t)
+			}
+			defer c.leave(v)
+		}
+		return c.value(name, v.Elem().Interface(), depth)
 
 	case reflect.Slice, reflect.Array:
+		if t.Elem().Kind() == reflect.Uint8 {
+			return c.bytesVariable(name, v)
+		}
+		if v.Kind() == reflect.Slice && !v.IsNil() {
+			if !c.enter(v) {
+				return cycle(name, t)
+			}
+			defer c.leave(v)
+		}
+
 		length := v.Len()
 		lenPtr := &length
 		elements := []Variable{}
 
-		maxElements := 100
+		maxElements := c.maxCollectionSize()
+		truncated := length > maxElements
 		if length < maxElements {
 			maxElements = length
 		}
 
 		for i := 0; i < maxElements; i++ {
-			elem := captureValue(fmt.Sprintf("[%d]", i), v.Index(i).Interface(), depth+1, maxDepth)
+			elem := c.value(fmt.Sprintf("[%d]", i), v.Index(i).Interface(), depth+1)
 			elements = append(elements, elem)
 		}
 
-		return Variable{
+		captured := Variable{
 			Name:          name,
 			Type:          t.String(),
 			Value:         fmt.Sprintf("[%d items]", length),
 			ArrayElements: elements,
 			ArrayLength:   lenPtr,
-			IsTruncated:   length > 100,
 		}
+		if truncated {
+			captured.IsTruncated = true
+			captured.TruncationReason = TruncatedCount
+		}
+		return captured
 
 	case reflect.Map:
+		if !v.IsNil() {
+			if !c.enter(v) {
+				return cycle(name, t)
+			}
+			defer c.leave(v)
+		}
+
 		children := make(map[string]Variable)
-		keys := v.MapKeys()
+		keys := sortedMapKeys(v)
 
-		maxKeys := 100
+		maxKeys := c.maxCollectionSize()
+		truncated := len(keys) > maxKeys
 		if len(keys) < maxKeys {
 			maxKeys = len(keys)
 		}
 
 		for i := 0; i < maxKeys; i++ {
 			key := keys[i]
-			keyStr := fmt.Sprintf("%v", key.Interface())
-			val := v.MapIndex(key)
-			children[keyStr] = captureValue(keyStr, val.Interface(), depth+1, maxDepth)
+			val := v.MapIndex(key.value)
+			if !val.IsValid() {
+				// Deleted since the keys were read.
+				continue
+			}
+			children[key.name] = c.value(key.name, val.Interface(), depth+1)
 		}
 
-		return Variable{
-			Name:        name,
-			Type:        t.String(),
-			Value:       fmt.Sprintf("map[%d]", len(keys)),
-			Children:    children,
-			IsTruncated: len(keys) > 100,
+		total := len(keys)
+		captured := Variable{
+			Name:         name,
+			Type:         t.String(),
+			Value:        fmt.Sprintf("map[%d]", total),
+			Children:     children,
+			TotalEntries: &total,
 		}
+		if truncated {
+			captured.IsTruncated = true
+			captured.TruncationReason = TruncatedCount
+		}
+		return captured
 
 	case reflect.Struct:
-		children := make(map[string]Variable)
+		captured := Variable{
+			Name:  name,
+			Type:  t.String(),
+			Value: fmt.Sprintf("<%s>", t.Name()),
+		}
+		c.structFields(&captured, v, depth)
+		return captured
 
-		for i := 0; i < t.NumField() && i < 100; i++ {
-			field := t.Field(i)
-			if !field.IsExported() {
-				continue
+	case reflect.Chan, reflect.Func, reflect.UnsafePointer:
+		if v.IsNil() {
+			return Variable{
+				Name:   name,
+				Type:   t.String(),
+				Value:  "nil",
+				IsNull: true,
 			}
-
-			fieldValue := v.Field(i)
-			children[field.Name] = captureValue(field.Name, fieldValue.Interface(), depth+1, maxDepth)
+		}
+		return Variable{
+			Name:  name,
+			Type:  t.String(),
+			Value: describeReference(v),
 		}
 
+	case reflect.Uintptr:
 		return Variable{
-			Name:     name,
-			Type:     t.String(),
-			Value:    fmt.Sprintf("<%s>", t.Name()),
-			Children: children,
+			Name:  name,
+			Type:  t.String(),
+			Value: fmt.Sprintf("0x%x", v.Uint()),
 		}
 
 	default:
@@ -369,7 +1099,266 @@ func captureValue(name string, value interface{}, depth, maxDepth int) Variable
 	}
 }
 
-func calculateFingerprint(err error, stackTrace []StackFrame) string {
+// TruncateString returns s cut to at most n bytes, without splitting a
+// UTF-8 encoded rune.
+func TruncateString(s string, n int) string {
+	if len(s) <= n {
+		return s
+	}
+	for n > 0 && !utf8.RuneStart(s[n]) {
+		n--
+	}
+	return s[:n]
+}
+
+// validUTF8Prefix returns b if it is valid UTF-8. If b was cut from longer
+// data, a rune split at its end is dropped first.
+func validUTF8Prefix(b []byte, cut bool) ([]byte, bool) {
+	if utf8.Valid(b) {
+		return b, true
+	}
+	if !cut {
+		return nil, false
+	}
+	i := len(b) - 1
+	for i > 0 && i > len(b)-utf8.UTFMax && !utf8.RuneStart(b[i]) {
+		i--
+	}
+	if i < 0 || utf8.FullRune(b[i:]) || !utf8.Valid(b[:i]) {
+		return nil, false
+	}
+	return b[:i], true
+}
+
+// syncMap captures a sync.Map like a map, from a snapshot of its entries
+// taken with Range, which is safe while other goroutines write to it.
+func (c *capturer) syncMap(name string, m *sync.Map, depth int) Variable {
+	v := reflect.ValueOf(m)
+	if !c.enter(v) {
+		return cycle(name, v.Type())
+	}
+	defer c.leave(v)
+
+	entries := make(map[interface{}]interface{})
+	m.Range(func(key, value interface{}) bool {
+		entries[key] = value
+		return true
+	})
+	captured := c.value(name, entries, depth)
+	captured.Type = v.Type().String()
+	return captured
+}
+
+// describeReference describes a non-nil channel, function or unsafe
+// pointer: a channel by its type, which holds its direction and element
+// type, and its length and capacity; a function by its signature and, if
+// it can be resolved, its name; an unsafe pointer by its address.
+func describeReference(v reflect.Value) string {
+	switch v.Kind() {
+	case reflect.Chan:
+		return fmt.Sprintf("%s (len %d, cap %d)", v.Type(), v.Len(), v.Cap())
+	case reflect.Func:
+		if fn := runtime.FuncForPC(v.Pointer()); fn != nil {
+			return fmt.Sprintf("%s (%s)", fn.Name(), v.Type())
+		}
+		return v.Type().String()
+	default:
+		return fmt.Sprintf("0x%x", v.Pointer())
+	}
+}
+
+// mapKey is a map key and its string representation.
+type mapKey struct {
+	name  string
+	value reflect.Value
+}
+
+// sortedMapKeys returns the keys of the map v sorted by their string
+// representation, so that the same keys are kept when the map is
+// truncated and captures of the same map are identical.
+func sortedMapKeys(v reflect.Value) []mapKey {
+	keys := make([]mapKey, 0, v.Len())
+	for _, key := range v.MapKeys() {
+		keys = append(keys, mapKey{name: fmt.Sprintf("%v", key.Interface()), value: key})
+	}
+	sort.Slice(keys, func(i, j int) bool { return keys[i].name < keys[j].name })
+	return keys
+}
+
+// bytesVariable captures a byte slice or array as text if it is valid
+// UTF-8, or as hex otherwise, instead of one element per byte. The value
+// is truncated to the maximum string length; ArrayLength holds the byte
+// count. Only the bytes that fit are copied and checked, so a large slice
+// costs no more than a small one.
+func (c *capturer) bytesVariable(name string, v reflect.Value) Variable {
+	length := v.Len()
+	maxLen := c.maxStringLength()
+	b := make([]byte, min(length, maxLen))
+	if v.Type().Elem() == reflect.TypeOf(byte(0)) {
+		reflect.Copy(reflect.ValueOf(b), v)
+	} else {
+		// reflect.Copy needs identical element types, so named byte types
+		// are copied one by one.
+		for i := range b {
+			b[i] = byte(v.Index(i).Uint())
+		}
+	}
+
+	var text string
+	truncated := length > len(b)
+	if valid, ok := validUTF8Prefix(b, truncated); ok {
+		text = string(valid)
+	} else {
+		if len(b) > maxLen/2 {
+			b, truncated = b[:maxLen/2], true
+		}
+		text = hex.EncodeToString(b)
+	}
+
+	captured := Variable{
+		Name:        name,
+		Type:        v.Type().String(),
+		Value:       text,
+		ArrayLength: &length,
+	}
+	if truncated {
+		captured.IsTruncated = true
+		captured.TruncationReason = TruncatedLength
+		captured.OriginalLength = length
+	}
+	return c.redact(captured)
+}
+
+// structFields captures the exported fields of the struct v as children
+// of captured, up to the configured maximum. Fields are visited in
+// declaration order, so the fields kept when the maximum applies are the
+// first ones declared.
+// TotalFields counts the fields that would be captured without the maximum.
+func (c *capturer) structFields(captured *Variable, v reflect.Value, depth int) {
+	v = c.readableStruct(v)
+	t := v.Type()
+	children := make(map[string]Variable)
+	maxFields := c.maxStructFields()
+	omitted := 0
+
+	for i := 0; i < t.NumField(); i++ {
+		field := t.Field(i)
+		fieldValue, ok := c.fieldValue(v, i)
+		if !ok {
+			continue
+		}
+		name, skip := c.fieldName(field)
+		if skip {
+			continue
+		}
+		if len(children) >= maxFields {
+			omitted++
+			continue
+		}
+
+		child := c.value(name, fieldValue, depth+1)
+		child.IsUnexported = !field.IsExported()
+		children[name] = child
+	}
+
+	total := len(children) + omitted
+	captured.Children = children
+	captured.TotalFields = &total
+	if omitted > 0 {
+		captured.IsTruncated = true
+		captured.TruncationReason = TruncatedCount
+		captured.OmittedFields = omitted
+	}
+}
+
+// textVariable captures a value by its text form. Structs, and pointers
+// to structs, also keep their fields as children.
+func (c *capturer) textVariable(name, text string, v reflect.Value, depth int) Variable {
+	captured := Variable{
+		Name:  name,
+		Type:  v.Type().String(),
+		Value: text,
+	}
+	if maxLen := c.maxStringLength(); len(text) > maxLen {
+		captured.Value = TruncateString(text, maxLen)
+		captured.IsTruncated = true
+		captured.TruncationReason = TruncatedLength
+		captured.OriginalLength = len(text)
+	}
+
+	if s := reflect.Indirect(v); s.Kind() == reflect.Struct {
+		if v.Kind() == reflect.Ptr {
+			if !c.enter(v) {
+				return c.redact(captured)
+			}
+			defer c.leave(v)
+		}
+		c.structFields(&captured, s, depth)
+	}
+
+	return c.redact(captured)
+}
+
+// textValue returns the text form of a value implementing error,
+// fmt.Stringer or encoding.TextMarshaler, checked in that order, such as
+// "5s" for a time.Duration or "10.0.0.1" for a net.IP. Nil values and
+// methods that panic or fail yield no text.
+func textValue(value interface{}, v reflect.Value) (text string, ok bool) {
+	if isNilValue(v) {
+		return "", false
+	}
+	defer func() {
+		if recover() != nil {
+			text, ok = "", false
+		}
+	}()
+
+	switch x := value.(type) {
+	case error:
+		return x.Error(), true
+	case fmt.Stringer:
+		return x.String(), true
+	case encoding.TextMarshaler:
+		b, err := x.MarshalText()
+		if err != nil {
+			return "", false
+		}
+		return string(b), true
+	}
+	return "", false
+}
+
+// isNilValue returns true for nil pointers, interfaces, maps, slices,
+// channels and funcs.
+func isNilValue(v reflect.Value) bool {
+	switch v.Kind() {
+	case reflect.Ptr, reflect.Interface, reflect.Map, reflect.Slice, reflect.Chan, reflect.Func:
+		return v.IsNil()
+	}
+	return false
+}
+
+// fingerprint computes the fingerprint of an error in the configured mode
+// and returns it with the mode that was applied. Custom mode without a
+// Fingerprinter falls back to stack-only grouping.
+func (o Options) fingerprint(err error, stackTrace []StackFrame) (string, FingerprintMode) {
+	switch o.FingerprintMode {
+	case FingerprintMessageOnly:
+		return HashFingerprint(err.Error()), FingerprintMessageOnly
+	case FingerprintTypeAndMessage:
+		return HashFingerprint(getErrorType(err) + ":" + err.Error()), FingerprintTypeAndMessage
+	case FingerprintCustom:
+		if o.Fingerprinter != nil {
+			return o.Fingerprinter(err, stackTrace), FingerprintCustom
+		}
+	}
+	return calculateFingerprint(err, stackTrace, o.StableFingerprint), FingerprintStackOnly
+}
+
+// calculateFingerprint hashes the error type and the package-qualified
+// function names of the top five frames, with their line numbers unless
+// stable is set.
+func calculateFingerprint(err error, stackTrace []StackFrame, stable bool) string {
 	parts := []string{getErrorType(err)}
 
 	added := 0
@@ -380,23 +1369,33 @@ func calculateFingerprint(err error, stackTrace []StackFrame) string {
 		if frame.IsNative {
 			continue
 		}
-		parts = append(parts, fmt.Sprintf("%s:%d", frame.MethodName, frame.LineNumber))
+		name := frame.Function
+		if name == "" {
+			name = frame.MethodName
+		}
+		if stable {
+			parts = append(parts, name)
+		} else {
+			parts = append(parts, fmt.Sprintf("%s:%d", name, frame.LineNumber))
+		}
 		added++
 	}
 
-	hash := sha256.Sum256([]byte(strings.Join(parts, ":")))
+	return HashFingerprint(strings.Join(parts, ":"))
+}
+
+// HashFingerprint hashes s into a fingerprint in the format the agent
+// uses: 16 hex digits.
+func HashFingerprint(s string) string {
+	hash := sha256.Sum256([]byte(s))
 	return hex.EncodeToString(hash[:8])
 }
 
 func getErrorType(err error) string {
-	t := reflect.TypeOf(err)
-	if t == nil {
-		return "error"
-	}
-	if t.Kind() == reflect.Ptr {
-		return t.Elem().String()
+	if isStringPanic(err) {
+		return "panic"
 	}
-	return t.String()
+	return typeName(errorValue(err))
 }
 
 func extractFunctionName(fullName string) string {
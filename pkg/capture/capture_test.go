@@ -0,0 +1,463 @@
+package capture
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestCaptureValueSyncMapConcurrentWriter(t *testing.T) {
+	var m sync.Map
+	for i := 0; i < 50; i++ {
+		m.Store(fmt.Sprintf("key%02d", i), i)
+	}
+
+	stop := make(chan struct{})
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		for i := 0; ; i++ {
+			select {
+			case <-stop:
+				return
+			default:
+			}
+			key := fmt.Sprintf("key%02d", i%100)
+			if i%3 == 0 {
+				m.Delete(key)
+			} else {
+				m.Store(key, i)
+			}
+		}
+	}()
+
+	for i := 0; i < 200; i++ {
+		v := CaptureValue("m", &m, 3)
+		if v.Type != "*sync.Map" {
+			t.Fatalf("Type = %q, want *sync.Map", v.Type)
+		}
+		if v.TotalEntries == nil || *v.TotalEntries != len(v.Children) {
+			t.Fatalf("TotalEntries = %v, want %d", v.TotalEntries, len(v.Children))
+		}
+	}
+	close(stop)
+	<-done
+}
+
+func TestCaptureErrorUnreadableLocal(t *testing.T) {
+	opts := Options{
+		MaxDepth: 3,
+		RedactFunc: func(name, typ, value string) (string, bool) {
+			if name == "bad" {
+				panic("redact failed")
+			}
+			return "", false
+		},
+	}
+	exc := CaptureErrorWithOptions(errors.New("boom"), opts, map[string]interface{}{
+		"bad":  "x",
+		"good": "y",
+	})
+
+	bad := exc.LocalVariables["bad"]
+	if bad.Value != UnreadableValue || bad.Type != "string" {
+		t.Errorf("bad = %+v, want %q", bad, UnreadableValue)
+	}
+	if good := exc.LocalVariables["good"]; good.Value != "y" {
+		t.Errorf("good = %+v, want y", good)
+	}
+}
+
+func TestCaptureValueUnreadableField(t *testing.T) {
+	type account struct {
+		Owner string
+		Notes string
+		Tags  []string
+	}
+	opts := Options{
+		MaxDepth: 3,
+		RedactFunc: func(name, typ, value string) (string, bool) {
+			if name == "Notes" || name == "[1]" {
+				panic("redact failed")
+			}
+			return "", false
+		},
+	}
+	v := CaptureValueWithOptions("a", account{Owner: "ann", Notes: "x", Tags: []string{"a", "b", "c"}}, opts)
+
+	if got := v.Children["Notes"]; got.Value != UnreadableValue || got.Type != "string" {
+		t.Errorf("Notes = %+v, want %q", got, UnreadableValue)
+	}
+	if got := v.Children["Owner"].Value; got != "ann" {
+		t.Errorf("Owner = %q, want the sibling of the unreadable field kept", got)
+	}
+	tags := v.Children["Tags"].ArrayElements
+	if len(tags) != 3 || tags[0].Value != "a" || tags[1].Value != UnreadableValue || tags[2].Value != "c" {
+		t.Errorf("Tags = %+v, want only the second element unreadable", tags)
+	}
+}
+
+func TestCaptureErrorMaxLocalVariables(t *testing.T) {
+	ctx := make(map[string]interface{})
+	for i := 0; i < 20; i++ {
+		ctx[fmt.Sprintf("key%02d", i)] = i
+	}
+
+	exc := CaptureErrorWithOptions(errors.New("boom"), Options{MaxDepth: 3, MaxLocalVariables: 5}, ctx)
+	if got := len(exc.LocalVariables); got != 5 {
+		t.Fatalf("captured %d local variables, want 5", got)
+	}
+	if exc.OmittedLocals != 15 {
+		t.Errorf("OmittedLocals = %d, want 15", exc.OmittedLocals)
+	}
+	for i := 0; i < 5; i++ {
+		if _, ok := exc.LocalVariables[fmt.Sprintf("key%02d", i)]; !ok {
+			t.Errorf("key%02d missing; the first keys in sorted order should be kept", i)
+		}
+	}
+}
+
+func TestCaptureValueRedactFunc(t *testing.T) {
+	type ticket struct {
+		Title string
+		Notes string
+	}
+	opts := Options{
+		MaxDepth: 3,
+		RedactFunc: func(name, typ, value string) (string, bool) {
+			if name == "Notes" && strings.Contains(value, "@") {
+				return "[EMAIL]", true
+			}
+			return "", false
+		},
+	}
+
+	v := CaptureValueWithOptions("t", ticket{Title: "mail bob@example.com", Notes: "reach bob@example.com"}, opts)
+	if notes := v.Children["Notes"]; notes.Value != "[EMAIL]" || !notes.IsRedacted {
+		t.Errorf("Notes = %+v, want redacted as [EMAIL]", notes)
+	}
+	if title := v.Children["Title"]; title.Value != "mail bob@example.com" || title.IsRedacted {
+		t.Errorf("Title = %+v, want it kept: the predicate only matches Notes", title)
+	}
+
+	v = CaptureValueWithOptions("t", ticket{Notes: "call back tomorrow"}, opts)
+	for _, child := range v.Children {
+		if child.IsRedacted {
+			t.Errorf("%s redacted without an email in it", child.Name)
+		}
+	}
+}
+
+func TestCaptureValueRedactFuncAfterBuiltinRules(t *testing.T) {
+	called := false
+	opts := Options{
+		MaxDepth:   3,
+		RedactKeys: []string{"password"},
+		RedactFunc: func(name, typ, value string) (string, bool) {
+			called = true
+			return "", false
+		},
+	}
+
+	v := CaptureValueWithOptions("password", "hunter2", opts)
+	if v.Value != RedactedValue {
+		t.Errorf("Value = %q, want %q", v.Value, RedactedValue)
+	}
+	if called {
+		t.Error("RedactFunc saw a value the built-in rules already redacted")
+	}
+}
+
+type marshaledError struct {
+	Code    int
+	payload string
+	fail    bool
+}
+
+func (e *marshaledError) Error() string { return "marshaled error" }
+
+func (e *marshaledError) MarshalJSON() ([]byte, error) {
+	if e.fail {
+		panic("marshal failed")
+	}
+	return []byte(e.payload), nil
+}
+
+func TestCaptureErrorJSONMarshaler(t *testing.T) {
+	opts := Options{MaxDepth: 3, UseJSONMarshaler: true}
+	err := &marshaledError{Code: 7, payload: `{"status":"denied","detail":{"retry":true}}`}
+
+	exc := CaptureErrorWithOptions(err, opts, nil)
+	if status := exc.LocalVariables["err.0.status"]; status.Value != "denied" {
+		t.Errorf("err.0.status = %+v, want denied", status)
+	}
+	detail := exc.LocalVariables["err.0.detail"]
+	if retry, ok := detail.Children["retry"]; len(detail.Children) != 1 || !ok || retry.Value != "true" {
+		t.Errorf("err.0.detail = %+v, want a retry child", detail)
+	}
+	if _, ok := exc.LocalVariables["err.0.Code"]; ok {
+		t.Error("err.0.Code captured by reflection despite the marshaler")
+	}
+}
+
+func TestCaptureErrorJSONMarshalerFallback(t *testing.T) {
+	opts := Options{MaxDepth: 3, UseJSONMarshaler: true}
+	for name, err := range map[string]*marshaledError{
+		"panic":   {Code: 7, fail: true},
+		"invalid": {Code: 7, payload: `{"status":`},
+	} {
+		exc := CaptureErrorWithOptions(err, opts, nil)
+		if code := exc.LocalVariables["err.0.Code"]; code.Value != "7" {
+			t.Errorf("%s: err.0.Code = %+v, want the reflected field", name, code)
+		}
+	}
+}
+
+func TestCaptureValueTruncationKeepsOriginalLength(t *testing.T) {
+	opts := Options{MaxDepth: 3, MaxStringLength: 100, MaxCollectionSize: 10}
+
+	s := CaptureValueWithOptions("s", strings.Repeat("x", 5000), opts)
+	if len(s.Value) != 100 || !s.IsTruncated || s.TruncationReason != TruncatedLength {
+		t.Errorf("string: len(Value) = %d, IsTruncated = %v, reason = %q", len(s.Value), s.IsTruncated, s.TruncationReason)
+	}
+	if s.OriginalLength != 5000 {
+		t.Errorf("string OriginalLength = %d, want 5000", s.OriginalLength)
+	}
+
+	l := CaptureValueWithOptions("l", make([]int, 10000), opts)
+	if len(l.ArrayElements) != 10 || !l.IsTruncated || l.TruncationReason != TruncatedCount {
+		t.Errorf("slice: %d elements, IsTruncated = %v, reason = %q", len(l.ArrayElements), l.IsTruncated, l.TruncationReason)
+	}
+	if l.ArrayLength == nil || *l.ArrayLength != 10000 {
+		t.Errorf("slice ArrayLength = %v, want 10000", l.ArrayLength)
+	}
+
+	short := CaptureValueWithOptions("short", "abc", opts)
+	if short.IsTruncated || short.OriginalLength != 0 {
+		t.Errorf("untruncated string = %+v", short)
+	}
+}
+
+func TestCaptureValueDepthTruncation(t *testing.T) {
+	type node struct{ Next *node }
+	v := CaptureValueWithOptions("n", &node{Next: &node{Next: &node{}}}, Options{MaxDepth: 1})
+
+	deepest := v.Children["Next"].Children["Next"]
+	if !deepest.IsTruncated || deepest.TruncationReason != TruncatedDepth {
+		t.Errorf("n.Next.Next = %+v, want truncated by depth", deepest)
+	}
+}
+
+type opError struct {
+	Op  string
+	Err error
+}
+
+func (e *opError) Error() string { return e.Op + ": " + e.Err.Error() }
+func (e *opError) Unwrap() error { return e.Err }
+
+type codeError struct {
+	Code int
+}
+
+func (e *codeError) Error() string { return fmt.Sprintf("code %d", e.Code) }
+
+func TestCaptureErrorChainFields(t *testing.T) {
+	err := &opError{Op: "read", Err: &codeError{Code: 5}}
+
+	exc := CaptureErrorWithOptions(err, Options{MaxDepth: 3}, nil)
+	if op := exc.LocalVariables["err.0.Op"]; op.Value != "read" {
+		t.Errorf("err.0.Op = %+v, want read", op)
+	}
+	if inner := exc.LocalVariables["err.0.Err"]; inner.Value != "code 5" {
+		t.Errorf("err.0.Err = %+v, want the inner error", inner)
+	}
+	if code := exc.LocalVariables["err.1.Code"]; code.Value != "5" {
+		t.Errorf("err.1.Code = %+v, want 5", code)
+	}
+
+	exc = CaptureErrorWithOptions(err, Options{MaxDepth: 3, MaxErrorChainDepth: 1}, nil)
+	if _, ok := exc.LocalVariables["err.1.Code"]; ok {
+		t.Error("err.1.Code captured beyond MaxErrorChainDepth")
+	}
+}
+
+type loopError struct {
+	Next error
+}
+
+func (e *loopError) Error() string { return "loop" }
+func (e *loopError) Unwrap() error { return e.Next }
+
+func TestCaptureErrorChainCycle(t *testing.T) {
+	err := &loopError{}
+	err.Next = err
+
+	exc := CaptureErrorWithOptions(err, Options{MaxDepth: 3, MaxErrorChainDepth: 3}, nil)
+	for depth := 0; depth < 3; depth++ {
+		if _, ok := exc.LocalVariables[fmt.Sprintf("err.%d.Next", depth)]; !ok {
+			t.Errorf("err.%d.Next missing", depth)
+		}
+	}
+	if _, ok := exc.LocalVariables["err.3.Next"]; ok {
+		t.Error("a cyclic error chain was followed beyond MaxErrorChainDepth")
+	}
+}
+
+type otherError struct{ msg string }
+
+func (e otherError) Error() string { return e.msg }
+
+func TestFingerprintModes(t *testing.T) {
+	here := []StackFrame{{Function: "app.handle", LineNumber: 10}}
+	there := []StackFrame{{Function: "app.retry", LineNumber: 30}}
+	fp := func(mode FingerprintMode, err error, stack []StackFrame) string {
+		got, gotMode := Options{FingerprintMode: mode}.fingerprint(err, stack)
+		if gotMode != mode {
+			t.Errorf("mode %q reported as %q", mode, gotMode)
+		}
+		return got
+	}
+
+	if fp(FingerprintStackOnly, errors.New("a"), here) != fp(FingerprintStackOnly, errors.New("b"), here) {
+		t.Error("stack_only: different messages at the same call site grouped apart")
+	}
+	if fp(FingerprintStackOnly, errors.New("a"), here) == fp(FingerprintStackOnly, errors.New("a"), there) {
+		t.Error("stack_only: different call sites grouped together")
+	}
+
+	if fp(FingerprintMessageOnly, errors.New("a"), here) != fp(FingerprintMessageOnly, errors.New("a"), there) {
+		t.Error("message_only: the same message grouped apart")
+	}
+	if fp(FingerprintMessageOnly, errors.New("a"), here) == fp(FingerprintMessageOnly, errors.New("b"), here) {
+		t.Error("message_only: different messages grouped together")
+	}
+
+	if fp(FingerprintTypeAndMessage, errors.New("a"), here) != fp(FingerprintTypeAndMessage, errors.New("a"), there) {
+		t.Error("type_and_message: the same type and message grouped apart")
+	}
+	if fp(FingerprintTypeAndMessage, errors.New("a"), here) == fp(FingerprintTypeAndMessage, otherError{"a"}, here) {
+		t.Error("type_and_message: different types grouped together")
+	}
+}
+
+func TestFingerprintCustom(t *testing.T) {
+	opts := Options{
+		FingerprintMode: FingerprintCustom,
+		Fingerprinter: func(err error, stack []StackFrame) string {
+			return "tenant-" + err.Error()
+		},
+	}
+	if got, mode := opts.fingerprint(errors.New("a"), nil); got != "tenant-a" || mode != FingerprintCustom {
+		t.Errorf("custom fingerprint = %q, %q", got, mode)
+	}
+
+	opts.Fingerprinter = nil
+	if _, mode := opts.fingerprint(errors.New("a"), nil); mode != FingerprintStackOnly {
+		t.Errorf("custom mode without a Fingerprinter reported as %q, want stack_only", mode)
+	}
+}
+
+func TestCaptureValueTimes(t *testing.T) {
+	zone := time.FixedZone("UTC+5:30", 5*3600+1800)
+	local := time.Date(2026, 3, 4, 5, 6, 7, 890, zone)
+	opts := Options{MaxDepth: 3}
+
+	for _, tt := range []struct {
+		in        interface{}
+		typ, want string
+	}{
+		{time.Time{}, "time.Time", "0001-01-01T00:00:00Z"},
+		{local, "time.Time", "2026-03-04T05:06:07+05:30"},
+		{&local, "*time.Time", "2026-03-04T05:06:07+05:30"},
+		{90 * time.Second, "time.Duration", "1m30s"},
+	} {
+		v := CaptureValueWithOptions("t", tt.in, opts)
+		if v.Type != tt.typ || v.Value != tt.want {
+			t.Errorf("CaptureValue(%v) = %s %q, want %s %q", tt.in, v.Type, v.Value, tt.typ, tt.want)
+		}
+	}
+
+	type event struct {
+		At time.Time
+	}
+	v := CaptureValueWithOptions("e", event{At: local}, opts)
+	if at := v.Children["At"]; at.Value != "2026-03-04T05:06:07+05:30" || len(at.Children) != 0 {
+		t.Errorf("e.At = %+v, want the RFC3339 time", at)
+	}
+}
+
+func TestCaptureValueMapOrderDeterministic(t *testing.T) {
+	m := make(map[string]interface{})
+	for i := 0; i < 200; i++ {
+		m[fmt.Sprintf("key%03d", i)] = map[int]string{i: "a", i + 1: "b", i + 2: "c"}
+	}
+	opts := Options{MaxDepth: 3, MaxCollectionSize: 10}
+
+	var first []byte
+	for i := 0; i < 20; i++ {
+		data, err := json.Marshal(CaptureValueWithOptions("m", m, opts))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if first == nil {
+			first = data
+		} else if string(data) != string(first) {
+			t.Fatalf("capture %d differs from the first:\n%s\n%s", i, data, first)
+		}
+	}
+
+	v := CaptureValueWithOptions("m", m, opts)
+	for i := 0; i < 10; i++ {
+		if _, ok := v.Children[fmt.Sprintf("key%03d", i)]; !ok {
+			t.Errorf("key%03d missing; the first keys in sorted order should be kept", i)
+		}
+	}
+}
+
+type myByte byte
+
+func TestCaptureValueNamedBytes(t *testing.T) {
+	v := CaptureValue("b", []myByte("hello"), 3)
+	if v.Value != "hello" || v.Type != "[]capture.myByte" {
+		t.Errorf("[]myByte = %s %q, want hello", v.Type, v.Value)
+	}
+
+	type header struct {
+		Magic [4]myByte
+		Size  int
+	}
+	v = CaptureValue("h", header{Magic: [4]myByte{0xde, 0xad, 0xbe, 0xef}, Size: 4}, 3)
+	if got := v.Children["Magic"].Value; got != "deadbeef" {
+		t.Errorf("Magic = %q, want deadbeef", got)
+	}
+	if got := v.Children["Size"].Value; got != "4" {
+		t.Errorf("Size = %q, want 4", got)
+	}
+}
+
+func TestCaptureValueTruncatesMultiByteString(t *testing.T) {
+	v := CaptureValueWithOptions("s", "héllo", Options{MaxDepth: 3, MaxStringLength: 2})
+	if v.Value != "h" || !v.IsTruncated || v.OriginalLength != 6 {
+		t.Errorf("Value = %q truncated %v from %d, want \"h\" truncated from 6", v.Value, v.IsTruncated, v.OriginalLength)
+	}
+	v = CaptureValueWithOptions("s", "日本語", Options{MaxDepth: 3, MaxStringLength: 7})
+	if v.Value != "日本" {
+		t.Errorf("Value = %q, want 日本", v.Value)
+	}
+}
+
+type greeting struct{}
+
+func (greeting) String() string { return "héllo" }
+
+func TestCaptureValueTruncatesMultiByteText(t *testing.T) {
+	v := CaptureValueWithOptions("g", greeting{}, Options{MaxDepth: 3, MaxStringLength: 2})
+	if v.Value != "h" || !v.IsTruncated || v.OriginalLength != 6 {
+		t.Errorf("Value = %q truncated %v from %d, want \"h\" truncated from 6", v.Value, v.IsTruncated, v.OriginalLength)
+	}
+}
@@ -0,0 +1,164 @@
+package capture
+
+import (
+	"bufio"
+	"bytes"
+	"runtime"
+	"strconv"
+	"strings"
+)
+
+// GoroutineInfo describes one goroutine from a full stack dump.
+type GoroutineInfo struct {
+	ID             int          `json:"id"`
+	State          string       `json:"state"`
+	WaitMinutes    int          `json:"wait_minutes,omitempty"`
+	LockedToThread bool         `json:"locked_to_thread,omitempty"`
+	Frames         []StackFrame `json:"frames"`
+	CreatedBy      *StackFrame  `json:"created_by,omitempty"`
+}
+
+// MaxCapturedGoroutines bounds the goroutines attached to a capture when
+// Options.CaptureAllGoroutines is set, to keep payloads small.
+const MaxCapturedGoroutines = 200
+
+// maxGoroutineDumpBytes bounds the buffer used to read the stack dump.
+const maxGoroutineDumpBytes = 8 << 20
+
+// CaptureGoroutines dumps the stacks of all goroutines and parses at most
+// max of them. A max of zero or less means no limit.
+func CaptureGoroutines(max int) []GoroutineInfo {
+	buf := make([]byte, 64<<10)
+	for {
+		n := runtime.Stack(buf, true)
+		if n < len(buf) || len(buf) >= maxGoroutineDumpBytes {
+			buf = buf[:n]
+			break
+		}
+		buf = make([]byte, len(buf)*2)
+	}
+	return ParseGoroutines(buf, max)
+}
+
+// ParseGoroutines parses the output of runtime.Stack(buf, true) into
+// structured goroutines. At most max goroutines are returned; a max of
+// zero or less means no limit. Unrecognized lines are skipped, so a
+// truncated dump yields the goroutines that could be parsed.
+func ParseGoroutines(dump []byte, max int) []GoroutineInfo {
+	var goroutines []GoroutineInfo
+	var current *GoroutineInfo
+	var function string
+	createdBy := false
+
+	flush := func() {
+		if current != nil {
+			goroutines = append(goroutines, *current)
+			current = nil
+		}
+	}
+
+	scanner := bufio.NewScanner(bytes.NewReader(dump))
+	scanner.Buffer(make([]byte, 0, 64<<10), 1<<20)
+	for scanner.Scan() {
+		line := scanner.Text()
+
+		switch {
+		case strings.HasPrefix(line, "goroutine "):
+			flush()
+			if max > 0 && len(goroutines) >= max {
+				return goroutines
+			}
+			current = parseGoroutineHeader(line)
+			function = ""
+
+		case current == nil || line == "":
+			continue
+
+		case strings.HasPrefix(line, "\t"):
+			if function == "" {
+				continue
+			}
+			frame := parseDumpFrame(function, strings.TrimSpace(line))
+			if createdBy {
+				current.CreatedBy = &frame
+			} else {
+				current.Frames = append(current.Frames, frame)
+			}
+			function = ""
+
+		case strings.HasPrefix(line, "created by "):
+			function = strings.TrimPrefix(line, "created by ")
+			if i := strings.Index(function, " in goroutine "); i >= 0 {
+				function = function[:i]
+			}
+			createdBy = true
+
+		default:
+			// Function line, e.g. "main.(*T).Run(0xc000010000)". The
+			// argument list is dropped; "...additional frames elided..."
+			// lines are ignored.
+			if strings.HasPrefix(line, "...") {
+				continue
+			}
+			function = line
+			if strings.HasSuffix(function, ")") {
+				if i := strings.LastIndex(function, "("); i > 0 {
+					function = function[:i]
+				}
+			}
+			createdBy = false
+		}
+	}
+	flush()
+
+	return goroutines
+}
+
+// parseGoroutineHeader parses a line such as
+// "goroutine 18 [chan receive, 5 minutes, locked to thread]:".
+func parseGoroutineHeader(line string) *GoroutineInfo {
+	g := &GoroutineInfo{}
+
+	rest := strings.TrimPrefix(line, "goroutine ")
+	if i := strings.IndexByte(rest, ' '); i >= 0 {
+		g.ID, _ = strconv.Atoi(rest[:i])
+		rest = rest[i+1:]
+	}
+
+	start := strings.IndexByte(rest, '[')
+	end := strings.LastIndexByte(rest, ']')
+	if start < 0 || end < start {
+		return g
+	}
+
+	for i, part := range strings.Split(rest[start+1:end], ", ") {
+		switch {
+		case i == 0:
+			g.State = part
+		case strings.HasSuffix(part, " minutes"):
+			g.WaitMinutes, _ = strconv.Atoi(strings.TrimSuffix(part, " minutes"))
+		case part == "locked to thread":
+			g.LockedToThread = true
+		}
+	}
+
+	return g
+}
+
+// parseDumpFrame builds a StackFrame from a function name and a location
+// line such as "/src/main.go:42 +0x1d".
+func parseDumpFrame(function, location string) StackFrame {
+	if i := strings.LastIndex(location, " +0x"); i >= 0 {
+		location = location[:i]
+	}
+
+	file := location
+	line := 0
+	if i := strings.LastIndexByte(location, ':'); i >= 0 {
+		if n, err := strconv.Atoi(location[i+1:]); err == nil {
+			file, line = location[:i], n
+		}
+	}
+
+	return newStackFrame(runtime.Frame{Function: function, File: file, Line: line})
+}
@@ -0,0 +1,72 @@
+package capture
+
+import (
+	"runtime"
+	"testing"
+)
+
+const sampleDump = `goroutine 1 [running]:
+main.main()
+	/src/app/main.go:12 +0x1d
+
+goroutine 18 [chan receive, 5 minutes, locked to thread]:
+main.(*Worker).Run(0xc000010000, {0x1, 0x2})
+	/src/app/worker.go:42 +0x85
+created by main.start in goroutine 1
+	/src/app/main.go:30 +0x3f
+
+goroutine 19 [select]:
+...additional frames elided...
+net/http.(*Server).Serve(0xc0000a0000)
+	/usr/local/go/src/net/http/server.go:3056 +0x2d
+`
+
+func TestParseGoroutines(t *testing.T) {
+	goroutines := ParseGoroutines([]byte(sampleDump), 0)
+	if len(goroutines) != 3 {
+		t.Fatalf("parsed %d goroutines, want 3", len(goroutines))
+	}
+
+	g := goroutines[1]
+	if g.ID != 18 || g.State != "chan receive" || g.WaitMinutes != 5 || !g.LockedToThread {
+		t.Errorf("goroutine 18 header = %+v", g)
+	}
+	if len(g.Frames) != 1 {
+		t.Fatalf("goroutine 18 has %d frames, want 1", len(g.Frames))
+	}
+	if f := g.Frames[0]; f.Function != "main.(*Worker).Run" || f.FilePath != "/src/app/worker.go" || f.LineNumber != 42 {
+		t.Errorf("goroutine 18 frame = %+v", f)
+	}
+	if c := g.CreatedBy; c == nil || c.Function != "main.start" || c.LineNumber != 30 {
+		t.Errorf("goroutine 18 CreatedBy = %+v", c)
+	}
+
+	if g := goroutines[2]; g.State != "select" || len(g.Frames) != 1 || g.Frames[0].Function != "net/http.(*Server).Serve" {
+		t.Errorf("goroutine 19 = %+v", g)
+	}
+}
+
+func TestParseGoroutinesMax(t *testing.T) {
+	goroutines := ParseGoroutines([]byte(sampleDump), 2)
+	if len(goroutines) != 2 || goroutines[0].ID != 1 || goroutines[1].ID != 18 {
+		t.Errorf("parsed %+v, want the first 2 goroutines", goroutines)
+	}
+}
+
+func TestParseGoroutinesTruncated(t *testing.T) {
+	goroutines := ParseGoroutines([]byte(sampleDump[:len(sampleDump)-60]), 0)
+	if len(goroutines) != 3 || len(goroutines[2].Frames) != 0 {
+		t.Errorf("parsed %+v from a truncated dump", goroutines)
+	}
+}
+
+func TestCaptureGoroutines(t *testing.T) {
+	buf := make([]byte, 1<<20)
+	buf = buf[:runtime.Stack(buf, false)]
+	if goroutines := ParseGoroutines(buf, 0); len(goroutines) != 1 || goroutines[0].State != "running" || len(goroutines[0].Frames) == 0 {
+		t.Errorf("parsed %+v from the current goroutine's stack", goroutines)
+	}
+	if got := CaptureGoroutines(1); len(got) != 1 {
+		t.Errorf("CaptureGoroutines(1) returned %d goroutines", len(got))
+	}
+}
@@ -0,0 +1,117 @@
+package capture
+
+import (
+	"bufio"
+	"os"
+	"regexp"
+	"runtime"
+	"time"
+)
+
+// processStart approximates the process start time by the time this
+// package was initialized.
+var processStart = time.Now()
+
+// HostInfo describes the process and host a capture was taken on.
+type HostInfo struct {
+	PID           int     `json:"pid"`
+	Hostname      string  `json:"hostname,omitempty"`
+	Executable    string  `json:"executable,omitempty"`
+	ContainerID   string  `json:"container_id,omitempty"`
+	StartedAt     string  `json:"started_at"`
+	UptimeSeconds float64 `json:"uptime_seconds"`
+}
+
+// NewHostInfo collects the PID, executable path, container ID and start
+// time of the current process. UptimeSeconds is left for Uptime to fill
+// in per capture.
+func NewHostInfo(hostname string) *HostInfo {
+	exe, _ := os.Executable()
+	return &HostInfo{
+		PID:         os.Getpid(),
+		Hostname:    hostname,
+		Executable:  exe,
+		ContainerID: containerID(),
+		StartedAt:   processStart.UTC().Format(time.RFC3339),
+	}
+}
+
+// Uptime returns a copy of h with UptimeSeconds set to the time since the
+// process started.
+func (h *HostInfo) Uptime() *HostInfo {
+	if h == nil {
+		return nil
+	}
+	c := *h
+	c.UptimeSeconds = time.Since(processStart).Seconds()
+	return &c
+}
+
+// containerIDPattern matches the 64 hex digit IDs Docker, containerd and
+// CRI-O give containers.
+var containerIDPattern = regexp.MustCompile(`[0-9a-f]{64}`)
+
+// containerID returns the ID of the container the process runs in, or ""
+// if it is not in one or the ID cannot be found. It is read from the
+// cgroup paths under cgroup v1, and from the mount table under cgroup v2,
+// where the cgroup path is usually just "/".
+func containerID() string {
+	if runtime.GOOS != "linux" {
+		return ""
+	}
+	for _, path := range []string{"/proc/self/cgroup", "/proc/self/mountinfo"} {
+		if id := findContainerID(path); id != "" {
+			return id
+		}
+	}
+	return ""
+}
+
+func findContainerID(path string) string {
+	f, err := os.Open(path)
+	if err != nil {
+		return ""
+	}
+	defer f.Close()
+
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		if id := containerIDPattern.FindString(scanner.Text()); id != "" {
+			return id
+		}
+	}
+	return ""
+}
+
+// MemStats is a snapshot of the Go runtime's memory statistics.
+type MemStats struct {
+	Alloc        uint64  `json:"alloc"`
+	TotalAlloc   uint64  `json:"total_alloc"`
+	Sys          uint64  `json:"sys"`
+	HeapAlloc    uint64  `json:"heap_alloc"`
+	HeapInuse    uint64  `json:"heap_inuse"`
+	HeapObjects  uint64  `json:"heap_objects"`
+	StackInuse   uint64  `json:"stack_inuse"`
+	NumGC        uint32  `json:"num_gc"`
+	PauseTotalNs uint64  `json:"pause_total_ns"`
+	GCCPUFrac    float64 `json:"gc_cpu_fraction"`
+}
+
+// ReadMemStats takes a snapshot of the memory statistics. It briefly
+// stops the world, like runtime.ReadMemStats.
+func ReadMemStats() *MemStats {
+	var m runtime.MemStats
+	runtime.ReadMemStats(&m)
+	return &MemStats{
+		Alloc:        m.Alloc,
+		TotalAlloc:   m.TotalAlloc,
+		Sys:          m.Sys,
+		HeapAlloc:    m.HeapAlloc,
+		HeapInuse:    m.HeapInuse,
+		HeapObjects:  m.HeapObjects,
+		StackInuse:   m.StackInuse,
+		NumGC:        m.NumGC,
+		PauseTotalNs: m.PauseTotalNs,
+		GCCPUFrac:    m.GCCPUFraction,
+	}
+}
@@ -0,0 +1,133 @@
+package capture
+
+import (
+	"net"
+	"net/http"
+	"strings"
+)
+
+// DefaultRedactHeaders are the request headers FromHTTPRequest redacts by
+// default, whatever the redact keys.
+var DefaultRedactHeaders = []string{"Authorization", "Cookie", "Proxy-Authorization"}
+
+// defaultMaxHeaderLength bounds header and query parameter values.
+const defaultMaxHeaderLength = 1000
+
+// HTTPRequestOptions configures FromHTTPRequestWithOptions.
+type HTTPRequestOptions struct {
+	// Headers lists the headers to include, matched case-insensitively.
+	// Nil includes all headers.
+	Headers []string
+	// RedactHeaders are headers whose values are always redacted.
+	// Defaults to DefaultRedactHeaders.
+	RedactHeaders []string
+	// RedactKeys are names whose header and query parameter values are
+	// redacted, matched as by IsSensitiveName. Defaults to
+	// DefaultRedactKeys.
+	RedactKeys []string
+	// MaxValueLength caps header and query parameter values. Defaults to
+	// 1000 bytes.
+	MaxValueLength int
+}
+
+// FromHTTPRequest describes a request for the capture context: its method,
+// path, query parameters, headers, client IP and content length. Cookies,
+// credentials and values whose names match the default redact keys are
+// redacted. The body is not read.
+//
+//	agent.CaptureError(err, map[string]interface{}{
+//		"http_request": capture.FromHTTPRequest(r),
+//	})
+func FromHTTPRequest(r *http.Request) map[string]interface{} {
+	return FromHTTPRequestWithOptions(r, HTTPRequestOptions{})
+}
+
+// FromHTTPRequestWithOptions describes a request like FromHTTPRequest
+// using the given options.
+func FromHTTPRequestWithOptions(r *http.Request, opts HTTPRequestOptions) map[string]interface{} {
+	redactHeaders := opts.RedactHeaders
+	if redactHeaders == nil {
+		redactHeaders = DefaultRedactHeaders
+	}
+	redactKeys := opts.RedactKeys
+	if redactKeys == nil {
+		redactKeys = DefaultRedactKeys
+	}
+	maxLen := opts.MaxValueLength
+	if maxLen <= 0 {
+		maxLen = defaultMaxHeaderLength
+	}
+
+	headers := make(map[string]string)
+	for name, values := range r.Header {
+		if opts.Headers != nil && !containsFold(opts.Headers, name) {
+			continue
+		}
+		value := capLength(strings.Join(values, ", "), maxLen)
+		if containsFold(redactHeaders, name) || IsSensitiveName(name, redactKeys) {
+			value = RedactedValue
+		}
+		headers[name] = value
+	}
+
+	query := make(map[string]string)
+	if r.URL != nil {
+		for name, values := range r.URL.Query() {
+			value := capLength(strings.Join(values, ", "), maxLen)
+			if IsSensitiveName(name, redactKeys) {
+				value = RedactedValue
+			}
+			query[name] = value
+		}
+	}
+
+	path := ""
+	if r.URL != nil {
+		path = r.URL.Path
+	}
+
+	return map[string]interface{}{
+		"method":         r.Method,
+		"path":           path,
+		"query":          query,
+		"headers":        headers,
+		"remote_ip":      remoteIP(r),
+		"content_length": r.ContentLength,
+	}
+}
+
+// remoteIP returns the client IP of a request: the first address of
+// X-Forwarded-For, X-Real-IP, or the host of the remote address.
+func remoteIP(r *http.Request) string {
+	if forwarded := r.Header.Get("X-Forwarded-For"); forwarded != "" {
+		first, _, _ := strings.Cut(forwarded, ",")
+		if ip := strings.TrimSpace(first); ip != "" {
+			return ip
+		}
+	}
+	if ip := strings.TrimSpace(r.Header.Get("X-Real-IP")); ip != "" {
+		return ip
+	}
+	if host, _, err := net.SplitHostPort(r.RemoteAddr); err == nil {
+		return host
+	}
+	return r.RemoteAddr
+}
+
+// containsFold returns true if names contains name, ignoring case.
+func containsFold(names []string, name string) bool {
+	for _, n := range names {
+		if strings.EqualFold(n, name) {
+			return true
+		}
+	}
+	return false
+}
+
+// capLength truncates s to at most n bytes.
+func capLength(s string, n int) string {
+	if len(s) > n {
+		return s[:n]
+	}
+	return s
+}
@@ -0,0 +1,69 @@
+package capture
+
+import (
+	"fmt"
+	"reflect"
+	"runtime"
+)
+
+// PanicError is an error holding a recovered panic value that is not an
+// error itself. Captures report the dynamic type of Value as the exception
+// type, or "panic" for a string, and extract its fields like those of a
+// custom error.
+type PanicError struct {
+	Value interface{}
+}
+
+// Error formats the panic value the way the runtime prints it.
+func (e *PanicError) Error() string {
+	return fmt.Sprint(e.Value)
+}
+
+// PanicCallers returns the program counters of the stack of a goroutine
+// that is panicking, starting at the function that panicked rather than
+// at the deferred function recovering it. It must be called during the
+// deferred call; if the goroutine is not panicking, it returns the whole
+// stack like Callers.
+func PanicCallers() []uintptr {
+	pcs := make([]uintptr, maxCallers)
+	n := runtime.Callers(2, pcs) // Skip runtime.Callers and PanicCallers
+	pcs = pcs[:n]
+
+	for i, pc := range pcs {
+		if fn := runtime.FuncForPC(pc - 1); fn != nil && fn.Name() == "runtime.gopanic" {
+			return pcs[i+1:]
+		}
+	}
+	return pcs
+}
+
+// errorValue returns the value whose type and fields describe err: the
+// panic value of a PanicError, or err itself.
+func errorValue(err error) interface{} {
+	if p, ok := err.(*PanicError); ok && p.Value != nil {
+		return p.Value
+	}
+	return err
+}
+
+// isStringPanic returns true if err holds a string panic value.
+func isStringPanic(err error) bool {
+	p, ok := err.(*PanicError)
+	if !ok {
+		return false
+	}
+	_, ok = p.Value.(string)
+	return ok
+}
+
+// typeName returns the name of the dynamic type of v, without the pointer.
+func typeName(v interface{}) string {
+	t := reflect.TypeOf(v)
+	if t == nil {
+		return "error"
+	}
+	if t.Kind() == reflect.Ptr {
+		return t.Elem().String()
+	}
+	return t.String()
+}
@@ -0,0 +1,33 @@
+package capture
+
+import "strings"
+
+// trimPaths strips prefix from the file paths of frames. Paths outside the
+// prefix are left unchanged.
+func trimPaths(frames []StackFrame, prefix string) {
+	for i := range frames {
+		frames[i].FilePath = trimPath(frames[i].FilePath, prefix)
+	}
+}
+
+// trimGoroutinePaths strips prefix from the file paths of goroutine stacks.
+func trimGoroutinePaths(goroutines []GoroutineInfo, prefix string) {
+	for i := range goroutines {
+		trimPaths(goroutines[i].Frames, prefix)
+		if goroutines[i].CreatedBy != nil {
+			goroutines[i].CreatedBy.FilePath = trimPath(goroutines[i].CreatedBy.FilePath, prefix)
+		}
+	}
+}
+
+// trimPath strips prefix from path if it is a directory prefix of it.
+func trimPath(path, prefix string) string {
+	rest, ok := strings.CutPrefix(path, prefix)
+	if !ok || prefix == "" {
+		return path
+	}
+	if rest != "" && !strings.HasSuffix(prefix, "/") && rest[0] != '/' {
+		return path
+	}
+	return strings.TrimPrefix(rest, "/")
+}
@@ -0,0 +1,111 @@
+package capture
+
+import (
+	"os"
+	"regexp"
+	"strings"
+)
+
+// RedactedValue replaces values that were redacted.
+const RedactedValue = "[REDACTED]"
+
+// DefaultRedactKeys are the names treated as sensitive by default.
+var DefaultRedactKeys = []string{"password", "secret", "token", "authorization", "api_key"}
+
+// ProcessContext describes the process a capture was taken in.
+type ProcessContext struct {
+	Args       []string `json:"args"`
+	WorkingDir string   `json:"working_dir,omitempty"`
+	EUID       int      `json:"euid"`
+}
+
+// NewProcessContext collects the command line, working directory and
+// effective UID of the current process. Arguments whose flag name matches
+// one of redactKeys have their value replaced, and redact, if set, is
+// applied to every argument.
+func NewProcessContext(redactKeys []string, redact RedactFunc) *ProcessContext {
+	wd, _ := os.Getwd()
+	return &ProcessContext{
+		Args:       RedactArgs(os.Args, redactKeys, redact),
+		WorkingDir: wd,
+		EUID:       os.Geteuid(),
+	}
+}
+
+// RedactArgs returns a copy of args with the values of sensitive flags
+// replaced. Both "--password=x" and "--password x" forms are handled.
+func RedactArgs(args []string, redactKeys []string, redact RedactFunc) []string {
+	out := make([]string, len(args))
+	redactNext := false
+	for i, arg := range args {
+		switch {
+		case redactNext && !strings.HasPrefix(arg, "-"):
+			arg = RedactedValue
+			redactNext = false
+		case strings.HasPrefix(arg, "-"):
+			redactNext = false
+			name, _, hasValue := strings.Cut(strings.TrimLeft(arg, "-"), "=")
+			if IsSensitiveName(name, redactKeys) {
+				if hasValue {
+					arg = arg[:strings.Index(arg, "=")+1] + RedactedValue
+				} else {
+					redactNext = true
+				}
+			}
+		default:
+			redactNext = false
+		}
+
+		if redact != nil {
+			if value, ok := redact("args", "string", arg); ok {
+				arg = value
+			}
+		}
+		out[i] = arg
+	}
+	return out
+}
+
+// logPairPattern matches key=value and key: value pairs in a log line,
+// including JSON members with quoted keys and values.
+var logPairPattern = regexp.MustCompile(`("?)([\w.-]+)("?[ \t]*[:=][ \t]*)("(?:[^"\\]|\\.)*"|[^\s,;&}]+)`)
+
+// RedactLogLine returns line with the values of pairs whose key matches
+// one of redactKeys replaced, e.g. "password=x" or `"token": "x"`, and
+// redact, if set, applied to the result.
+func RedactLogLine(line string, redactKeys []string, redact RedactFunc) string {
+	if len(redactKeys) > 0 {
+		line = logPairPattern.ReplaceAllStringFunc(line, func(pair string) string {
+			m := logPairPattern.FindStringSubmatch(pair)
+			if !IsSensitiveName(m[2], redactKeys) {
+				return pair
+			}
+			value := RedactedValue
+			if strings.HasPrefix(m[4], `"`) {
+				value = `"` + value + `"`
+			}
+			return m[1] + m[2] + m[3] + value
+		})
+	}
+	if redact != nil {
+		if value, ok := redact("recent_logs", "string", line); ok {
+			line = value
+		}
+	}
+	return line
+}
+
+// IsSensitiveName reports whether name contains one of keys, ignoring
+// case and treating '-' and '_' alike.
+func IsSensitiveName(name string, keys []string) bool {
+	normalized := strings.ReplaceAll(strings.ToLower(name), "-", "_")
+	for _, key := range keys {
+		if key == "" {
+			continue
+		}
+		if strings.Contains(normalized, strings.ReplaceAll(strings.ToLower(key), "-", "_")) {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,34 @@
+package capture
+
+import (
+	"os"
+	"reflect"
+	"testing"
+)
+
+func TestRedactArgs(t *testing.T) {
+	keys := []string{"password", "token"}
+	args := []string{"./job", "--password=hunter2", "--token", "abc123", "-v", "--user=bob", "input.csv"}
+
+	want := []string{"./job", "--password=" + RedactedValue, "--token", RedactedValue, "-v", "--user=bob", "input.csv"}
+	if got := RedactArgs(args, keys, nil); !reflect.DeepEqual(got, want) {
+		t.Errorf("RedactArgs = %q, want %q", got, want)
+	}
+	if args[1] != "--password=hunter2" {
+		t.Error("RedactArgs modified its input")
+	}
+}
+
+func TestNewProcessContext(t *testing.T) {
+	p := NewProcessContext(nil, nil)
+
+	if !reflect.DeepEqual(p.Args, os.Args) {
+		t.Errorf("Args = %q, want %q", p.Args, os.Args)
+	}
+	if wd, _ := os.Getwd(); p.WorkingDir != wd {
+		t.Errorf("WorkingDir = %q, want %q", p.WorkingDir, wd)
+	}
+	if p.EUID != os.Geteuid() {
+		t.Errorf("EUID = %d, want %d", p.EUID, os.Geteuid())
+	}
+}
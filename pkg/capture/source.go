@@ -0,0 +1,127 @@
+package capture
+
+import (
+	"bufio"
+	"os"
+	"path/filepath"
+	"reflect"
+	"runtime"
+	"strings"
+)
+
+// sourceContextLines is the number of lines captured before and after the
+// culprit line of a frame.
+const sourceContextLines = 3
+
+// internalPrefix is the function name prefix of the agent's own packages.
+var internalPrefix = strings.TrimSuffix(reflect.TypeOf(Options{}).PkgPath(), "capture")
+
+// isAgentFunction returns true if the function belongs to one of the
+// agent's own packages. The external test packages of the agent, such as
+// agent_test, are application code.
+func isAgentFunction(function string) bool {
+	if !strings.HasPrefix(function, internalPrefix) {
+		return false
+	}
+	pkg := function
+	slash := strings.LastIndex(function, "/")
+	if dot := strings.Index(function[slash+1:], "."); dot >= 0 {
+		pkg = function[:slash+1+dot]
+	}
+	return !strings.HasSuffix(pkg, "_test")
+}
+
+// goroot is the Go installation the program was built with, used to tell
+// standard library frames apart from application frames.
+var goroot = filepath.ToSlash(runtime.GOROOT())
+
+// isInApp returns true if the frame belongs to the monitored application
+// rather than the Go runtime, the standard library, a dependency or the
+// agent itself.
+func isInApp(frame runtime.Frame, f StackFrame) bool {
+	if f.IsNative || !f.SourceAvailable || frame.File == "" {
+		return false
+	}
+	if isAgentFunction(frame.Function) {
+		return false
+	}
+	if goroot != "" && strings.HasPrefix(filepath.ToSlash(frame.File), goroot+"/") {
+		return false
+	}
+	return true
+}
+
+// attachSource reads source snippets for the top n in-app frames.
+func attachSource(frames []StackFrame, n int) {
+	cache := make(sourceCache)
+	attached := 0
+	for i := range frames {
+		if attached >= n {
+			break
+		}
+		if !frames[i].InApp {
+			continue
+		}
+		cache.attach(&frames[i])
+		attached++
+	}
+}
+
+// attachAllSource reads source snippets for every frame whose source is
+// available.
+func attachAllSource(frames []StackFrame) {
+	cache := make(sourceCache)
+	for i := range frames {
+		if frames[i].IsNative || !frames[i].SourceAvailable {
+			continue
+		}
+		cache.attach(&frames[i])
+	}
+}
+
+// sourceCache holds the lines of files read during a single capture.
+// Files that could not be read are cached as nil.
+type sourceCache map[string][]string
+
+// attach sets the source context of a frame. Frames whose file no longer
+// exists or is shorter than expected are left unchanged.
+func (sc sourceCache) attach(f *StackFrame) {
+	if f.FilePath == "" || f.LineNumber <= 0 {
+		return
+	}
+
+	lines := sc.lines(f.FilePath)
+	if f.LineNumber > len(lines) {
+		return
+	}
+
+	start := f.LineNumber - 1 - sourceContextLines
+	if start < 0 {
+		start = 0
+	}
+	end := f.LineNumber + sourceContextLines
+	if end > len(lines) {
+		end = len(lines)
+	}
+
+	f.SourceContext = append([]string(nil), lines[start:end]...)
+	f.SourceLineIndex = f.LineNumber - 1 - start
+}
+
+func (sc sourceCache) lines(path string) []string {
+	if lines, ok := sc[path]; ok {
+		return lines
+	}
+
+	var lines []string
+	if file, err := os.Open(path); err == nil {
+		scanner := bufio.NewScanner(file)
+		for scanner.Scan() {
+			lines = append(lines, scanner.Text())
+		}
+		file.Close()
+	}
+
+	sc[path] = lines
+	return lines
+}
@@ -0,0 +1,72 @@
+package capture
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestAttachSourceTopFrames(t *testing.T) {
+	dir := t.TempDir()
+	path := filepath.Join(dir, "main.go")
+	content := "line1\nline2\nline3\nline4\nline5\nline6\nline7\nline8\n"
+	if err := os.WriteFile(path, []byte(content), 0o644); err != nil {
+		t.Fatal(err)
+	}
+
+	frames := []StackFrame{
+		{FilePath: path, LineNumber: 5, InApp: true, SourceAvailable: true},
+		{FilePath: path, LineNumber: 2, SourceAvailable: true},
+		{FilePath: filepath.Join(dir, "missing.go"), LineNumber: 3, InApp: true, SourceAvailable: true},
+		{FilePath: path, LineNumber: 7, InApp: true, SourceAvailable: true},
+	}
+	attachSource(frames, 2)
+
+	want := []string{"line2", "line3", "line4", "line5", "line6", "line7", "line8"}
+	if got := frames[0].SourceContext; strings.Join(got, ",") != strings.Join(want, ",") {
+		t.Errorf("frame 0 SourceContext = %q, want %q", got, want)
+	}
+	if frames[0].SourceLineIndex != 3 {
+		t.Errorf("frame 0 SourceLineIndex = %d, want 3", frames[0].SourceLineIndex)
+	}
+	if frames[1].SourceContext != nil {
+		t.Error("frame 1 is not in-app but has source context")
+	}
+	if frames[2].SourceContext != nil {
+		t.Error("frame 2 has source context from a missing file")
+	}
+	if frames[3].SourceContext != nil {
+		t.Error("frame 3 is beyond the top 2 in-app frames but has source context")
+	}
+}
+
+func TestAttachSourceLineBeyondFile(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "short.go")
+	if err := os.WriteFile(path, []byte("only\n"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+
+	frames := []StackFrame{{FilePath: path, LineNumber: 40, InApp: true, SourceAvailable: true}}
+	attachSource(frames, 1)
+	if frames[0].SourceContext != nil {
+		t.Errorf("SourceContext = %q for a line past the end of the file", frames[0].SourceContext)
+	}
+}
+
+func TestIsAgentFunction(t *testing.T) {
+	for function, want := range map[string]bool{
+		internalPrefix + "agent.(*Agent).CaptureError":          true,
+		internalPrefix + "agent.(*Agent).Go.func1":              true,
+		internalPrefix + "capture.captureError":                 true,
+		internalPrefix + "agent/agenttest.(*Transport).Capture": true,
+		internalPrefix + "agent_test.TestCapture":               false,
+		internalPrefix + "capture_test.TestCapture.func1":       false,
+		"main.main":                          false,
+		"github.com/example/app/pkg/agent.F": false,
+	} {
+		if got := isAgentFunction(function); got != want {
+			t.Errorf("isAgentFunction(%q) = %v, want %v", function, got, want)
+		}
+	}
+}
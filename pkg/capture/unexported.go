@@ -0,0 +1,42 @@
+package capture
+
+import (
+	"reflect"
+	"unsafe"
+)
+
+// readableStruct returns s, or an addressable copy of it if unexported
+// fields are captured and s is not addressable, which reading them
+// requires.
+func (c *capturer) readableStruct(s reflect.Value) reflect.Value {
+	if !c.opts.CaptureUnexported || s.CanAddr() {
+		return s
+	}
+	copied := reflect.New(s.Type()).Elem()
+	copied.Set(s)
+	return copied
+}
+
+// fieldValue returns the value of field i of the struct s and whether it
+// may be captured. Unexported fields are read, bypassing the visibility
+// check, only if CaptureUnexported is set and s is addressable; functions
+// and unsafe pointers among them are never read.
+func (c *capturer) fieldValue(s reflect.Value, i int) (interface{}, bool) {
+	field := s.Type().Field(i)
+	value := s.Field(i)
+	if field.IsExported() {
+		if !value.CanInterface() {
+			return nil, false
+		}
+		return value.Interface(), true
+	}
+
+	if !c.opts.CaptureUnexported || !value.CanAddr() {
+		return nil, false
+	}
+	switch value.Kind() {
+	case reflect.Func, reflect.UnsafePointer:
+		return nil, false
+	}
+	return reflect.NewAt(value.Type(), unsafe.Pointer(value.UnsafeAddr())).Elem().Interface(), true
+}
@@ -0,0 +1,7 @@
+// Package hooks links the agent package to its test helpers without
+// exporting the hooks from the agent package itself.
+package hooks
+
+// ResetGlobal stops the global agent and allows Init to run again. It is
+// set by the agent package.
+var ResetGlobal func()
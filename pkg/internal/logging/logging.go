@@ -0,0 +1,179 @@
+// Package logging writes the log lines of the agent's packages, either to
+// the standard logger or to a Logger set with WithLogger, and collapses
+// repeated debug lines.
+package logging
+
+import (
+	"fmt"
+	"log"
+	"sync"
+	"time"
+)
+
+// Prefix starts every log line written to a standard logger.
+const Prefix = "[AIVory Monitor] "
+
+// Levels passed to a log function.
+const (
+	LevelDebug = "debug"
+	LevelInfo  = "info"
+	LevelWarn  = "warn"
+	LevelError = "error"
+)
+
+// repeatWindow is how long repeats of a debug line are suppressed after it
+// was logged. The next line logged after the window counts them.
+const repeatWindow = 30 * time.Second
+
+// Output receives log lines. It has the method set of the exported Logger
+// interfaces of the agent, transport and breakpoint packages.
+type Output interface {
+	Debugf(format string, args ...interface{})
+	Infof(format string, args ...interface{})
+	Warnf(format string, args ...interface{})
+	Errorf(format string, args ...interface{})
+}
+
+// Std returns an Output printing lines with Prefix to l, or to the
+// standard logger if l is nil.
+func Std(l *log.Logger) Output {
+	return stdOutput{l: l}
+}
+
+type stdOutput struct {
+	l *log.Logger
+}
+
+func (o stdOutput) Debugf(format string, args ...interface{}) { o.printf(format, args...) }
+func (o stdOutput) Infof(format string, args ...interface{})  { o.printf(format, args...) }
+func (o stdOutput) Warnf(format string, args ...interface{})  { o.printf(format, args...) }
+func (o stdOutput) Errorf(format string, args ...interface{}) { o.printf(format, args...) }
+
+func (o stdOutput) printf(format string, args ...interface{}) {
+	if o.l == nil {
+		log.Printf(Prefix+format, args...)
+		return
+	}
+	o.l.Printf(Prefix+format, args...)
+}
+
+// Func returns an Output calling fn with the level and the formatted line,
+// including Prefix.
+func Func(fn func(level, msg string)) Output {
+	return funcOutput(fn)
+}
+
+type funcOutput func(level, msg string)
+
+func (f funcOutput) Debugf(format string, args ...interface{}) { f.call(LevelDebug, format, args...) }
+func (f funcOutput) Infof(format string, args ...interface{})  { f.call(LevelInfo, format, args...) }
+func (f funcOutput) Warnf(format string, args ...interface{})  { f.call(LevelWarn, format, args...) }
+func (f funcOutput) Errorf(format string, args ...interface{}) { f.call(LevelError, format, args...) }
+
+func (f funcOutput) call(level, format string, args ...interface{}) {
+	f(level, Prefix+fmt.Sprintf(format, args...))
+}
+
+// Logger writes log lines to an Output, or to the standard logger if it
+// has none. Debug lines, and warnings logged with LimitedWarnf, logged
+// with the same format within repeatWindow are suppressed, so a flapping connection logs its errors once per window
+// with a count instead of every second. A nil Logger writes to the
+// standard logger without suppression.
+type Logger struct {
+	out Output
+
+	mu      sync.Mutex
+	repeats map[string]*repeat
+}
+
+// repeat tracks a debug line format: when it was last logged and how many
+// lines with it were suppressed since.
+type repeat struct {
+	logged     time.Time
+	suppressed int
+}
+
+// New returns a Logger writing to out, or to the standard logger if out is
+// nil.
+func New(out Output) *Logger {
+	if out == nil {
+		out = Std(nil)
+	}
+	return &Logger{out: out}
+}
+
+// Debugf logs a debug line unless one with the same format was logged
+// recently.
+func (l *Logger) Debugf(format string, args ...interface{}) {
+	if l == nil {
+		Std(nil).Debugf(format, args...)
+		return
+	}
+	if format, args, ok := l.limit(format, args); ok {
+		l.out.Debugf(format, args...)
+	}
+}
+
+// LimitedWarnf logs a warning that may repeat often, e.g. one per
+// capture, unless one with the same format was logged recently, like
+// Debugf.
+func (l *Logger) LimitedWarnf(format string, args ...interface{}) {
+	if l == nil {
+		Std(nil).Warnf(format, args...)
+		return
+	}
+	if format, args, ok := l.limit(format, args); ok {
+		l.out.Warnf(format, args...)
+	}
+}
+
+// limit returns false if a line with format was logged within
+// repeatWindow. Otherwise it returns the line to log, counting the lines
+// suppressed since the last one.
+func (l *Logger) limit(format string, args []interface{}) (string, []interface{}, bool) {
+	now := time.Now()
+	l.mu.Lock()
+	if l.repeats == nil {
+		l.repeats = make(map[string]*repeat)
+	}
+	r, ok := l.repeats[format]
+	if ok && now.Sub(r.logged) < repeatWindow {
+		r.suppressed++
+		l.mu.Unlock()
+		return "", nil, false
+	}
+	suppressed := 0
+	if ok {
+		suppressed = r.suppressed
+	}
+	l.repeats[format] = &repeat{logged: now}
+	l.mu.Unlock()
+
+	if suppressed > 0 {
+		format += " (repeated %d times)"
+		args = append(args, suppressed)
+	}
+	return format, args, true
+}
+
+// Infof logs an informational line.
+func (l *Logger) Infof(format string, args ...interface{}) {
+	l.output().Infof(format, args...)
+}
+
+// Warnf logs a warning.
+func (l *Logger) Warnf(format string, args ...interface{}) {
+	l.output().Warnf(format, args...)
+}
+
+// Errorf logs an error.
+func (l *Logger) Errorf(format string, args ...interface{}) {
+	l.output().Errorf(format, args...)
+}
+
+func (l *Logger) output() Output {
+	if l == nil {
+		return Std(nil)
+	}
+	return l.out
+}
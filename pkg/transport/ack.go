@@ -0,0 +1,257 @@
+package transport
+
+import (
+	"bytes"
+	"container/list"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/gorilla/websocket"
+)
+
+const (
+	// ackTimeout is how long a written exception waits for its ack before
+	// it is sent again.
+	ackTimeout = 30 * time.Second
+
+	// maxInflight bounds the number of unacknowledged exception messages
+	// kept for re-sending; the oldest is given up when it is exceeded.
+	maxInflight = 500
+
+	// maxDeliveryAttempts is the number of times an exception message is
+	// written before it is given up.
+	maxDeliveryAttempts = 5
+)
+
+// WithReliableDelivery tags each exception message with a message_id and
+// keeps it until the backend acknowledges it with an "ack" message naming
+// that ID. Unacknowledged messages are sent again after reconnecting and,
+// on a live connection, once they have waited 30s for their ack. The
+// backend must therefore tolerate duplicates.
+func WithReliableDelivery(enable bool) Option {
+	return func(c *Connection) {
+		c.reliable = enable
+	}
+}
+
+// inflightMessage is an exception message written but not acknowledged.
+type inflightMessage struct {
+	data     []byte
+	sentAt   time.Time
+	attempts int
+
+	// elem is the message's entry in ackTracker.order.
+	elem *list.Element
+}
+
+// ackTracker holds the unacknowledged exception messages by message ID.
+type ackTracker struct {
+	mu       sync.Mutex
+	messages map[string]*inflightMessage
+
+	// order holds the IDs of the messages in the order they were first
+	// written, oldest first.
+	order list.List
+
+	// waiters receive true when the message with their ID is acknowledged
+	// and false when it is given up.
+	waiters map[string]chan bool
+}
+
+// sent records a write of a message. It returns the ID of the oldest
+// message if it had to be given up to stay within maxInflight, or "".
+func (t *ackTracker) sent(id string, data []byte) (evicted string) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	if t.messages == nil {
+		t.messages = make(map[string]*inflightMessage)
+	}
+	if msg, ok := t.messages[id]; ok {
+		msg.sentAt = time.Now()
+		msg.attempts++
+		return ""
+	}
+
+	if len(t.messages) >= maxInflight {
+		evicted = t.order.Front().Value.(string)
+		t.remove(evicted, false)
+	}
+	t.messages[id] = &inflightMessage{
+		data:     data,
+		sentAt:   time.Now(),
+		attempts: 1,
+		elem:     t.order.PushBack(id),
+	}
+	return evicted
+}
+
+// remove removes a message and tells its waiter, if any, whether it was
+// acknowledged. t.mu must be held.
+func (t *ackTracker) remove(id string, acked bool) {
+	if waiter, ok := t.waiters[id]; ok {
+		waiter <- acked
+		delete(t.waiters, id)
+	}
+	if msg, ok := t.messages[id]; ok {
+		t.order.Remove(msg.elem)
+		delete(t.messages, id)
+	}
+}
+
+// ack removes an acknowledged message and returns true if it was in
+// flight.
+func (t *ackTracker) ack(id string) bool {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	_, inflight := t.messages[id]
+	t.remove(id, true)
+	return inflight
+}
+
+// wait returns a channel that receives true when the message with the
+// given ID is acknowledged, or false when it is given up. done must be
+// called once the caller stops waiting.
+func (t *ackTracker) wait(id string) (acked <-chan bool, done func()) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	if t.waiters == nil {
+		t.waiters = make(map[string]chan bool)
+	}
+	waiter := make(chan bool, 1)
+	t.waiters[id] = waiter
+	return waiter, func() {
+		t.mu.Lock()
+		delete(t.waiters, id)
+		t.mu.Unlock()
+	}
+}
+
+// due returns the messages written at least age ago, oldest first, giving
+// up those that have used all their delivery attempts and returning their
+// IDs.
+func (t *ackTracker) due(age time.Duration) (due [][]byte, expired []string) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	cutoff := time.Now().Add(-age)
+	var msgs []*inflightMessage
+	for id, msg := range t.messages {
+		if msg.sentAt.After(cutoff) {
+			continue
+		}
+		if msg.attempts >= maxDeliveryAttempts {
+			t.remove(id, false)
+			expired = append(expired, id)
+			continue
+		}
+		msgs = append(msgs, msg)
+	}
+
+	sort.Slice(msgs, func(i, j int) bool { return msgs[i].sentAt.Before(msgs[j].sentAt) })
+	for _, msg := range msgs {
+		due = append(due, msg.data)
+	}
+	return due, expired
+}
+
+// newMessageID returns the message ID for a message of the given type, or
+// "" if it is not tracked.
+func (c *Connection) newMessageID(msgType string) string {
+	if !c.reliable || !strings.HasPrefix(msgType, "exception") {
+		return ""
+	}
+	return uuid.New().String()
+}
+
+// messageIDField is the encoding of the message_id field, which
+// marshalMessage places right after the type.
+var messageIDField = []byte(`,"message_id":"`)
+
+// messageID returns the message ID of an encoded message, or "" if it has
+// none.
+func messageID(data []byte) string {
+	head := data
+	if len(head) > 128 {
+		head = head[:128]
+	}
+	i := bytes.Index(head, messageIDField)
+	if i < 0 {
+		return ""
+	}
+	rest := data[i+len(messageIDField):]
+	end := bytes.IndexByte(rest, '"')
+	if end < 0 {
+		return ""
+	}
+	return string(rest[:end])
+}
+
+// trackSent records a written message for acknowledgement if it carries a
+// message ID.
+func (c *Connection) trackSent(data []byte) {
+	if !c.reliable {
+		return
+	}
+	id := messageID(data)
+	if id == "" {
+		return
+	}
+	if evicted := c.inflight.sent(id, data); evicted != "" {
+		c.releaseSpooled(evicted)
+		if c.debug {
+			c.log.Debugf("Too many unacknowledged messages, giving up the oldest")
+		}
+	}
+}
+
+// handleAck removes the message named by an "ack" payload from the
+// in-flight set.
+func (c *Connection) handleAck(payload interface{}) {
+	payloadMap, ok := payload.(map[string]interface{})
+	if !ok {
+		return
+	}
+	id, _ := payloadMap["message_id"].(string)
+	if c.inflight.ack(id) {
+		c.counters.acked.Add(1)
+	}
+	c.releaseSpooled(id)
+}
+
+// resendUnacked writes the in-flight messages written at least age ago on
+// conn, oldest first. It returns false if a write failed.
+func (c *Connection) resendUnacked(conn *websocket.Conn, age time.Duration) bool {
+	if !c.reliable {
+		return true
+	}
+
+	due, expired := c.inflight.due(age)
+	for _, id := range expired {
+		c.releaseSpooled(id)
+	}
+	if len(expired) > 0 && c.debug {
+		c.log.Debugf("Giving up %d messages after %d unacknowledged attempts", len(expired), maxDeliveryAttempts)
+	}
+	if len(due) > 0 && c.debug {
+		c.log.Debugf("Re-sending %d unacknowledged messages", len(due))
+	}
+
+	for _, data := range due {
+		if err := c.write(conn, data); err != nil {
+			if c.debug {
+				c.log.Debugf("Write error re-sending message: %v", err)
+			}
+			return false
+		}
+		c.counters.retried.Add(1)
+		c.counters.lastSent.Store(time.Now().UnixNano())
+		c.trackSent(data)
+	}
+	return true
+}
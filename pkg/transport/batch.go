@@ -0,0 +1,73 @@
+package transport
+
+import (
+	"time"
+
+	"github.com/aivorynet/agent-go/pkg/capture"
+)
+
+// defaultBatchSize is the batch size used when only a batch interval is
+// configured.
+const defaultBatchSize = 50
+
+// WithBatchInterval enables batching: exceptions are accumulated for up to
+// d and then sent as a single "exception_batch" message.
+func WithBatchInterval(d time.Duration) Option {
+	return func(c *Connection) {
+		c.batchInterval = d
+	}
+}
+
+// WithBatchSize enables batching: exceptions are sent as a single
+// "exception_batch" message once n have accumulated. Combined with
+// WithBatchInterval, whichever limit is reached first sends the batch.
+func WithBatchSize(n int) Option {
+	return func(c *Connection) {
+		c.batchSize = n
+	}
+}
+
+// batching returns true if exceptions are batched.
+func (c *Connection) batching() bool {
+	return c.batchInterval > 0 || c.batchSize > 1
+}
+
+// addToBatch adds an exception to the current batch and sends the batch if
+// it is full. The first exception of a batch starts the interval timer.
+func (c *Connection) addToBatch(exc *capture.ExceptionCapture) {
+	size := c.batchSize
+	if size <= 0 {
+		size = defaultBatchSize
+	}
+
+	c.batchMu.Lock()
+	c.batch = append(c.batch, exc)
+	full := len(c.batch) >= size
+	if len(c.batch) == 1 && !full && c.batchInterval > 0 {
+		c.batchTimer = time.AfterFunc(c.batchInterval, func() { c.flushBatch() })
+	}
+	c.batchMu.Unlock()
+
+	if full {
+		c.flushBatch()
+	}
+}
+
+// flushBatch queues the current batch, if any, as one message and returns
+// true if there was one.
+func (c *Connection) flushBatch() bool {
+	c.batchMu.Lock()
+	batch := c.batch
+	c.batch = nil
+	if c.batchTimer != nil {
+		c.batchTimer.Stop()
+		c.batchTimer = nil
+	}
+	c.batchMu.Unlock()
+
+	if len(batch) == 0 {
+		return false
+	}
+	c.send("exception_batch", batch)
+	return true
+}
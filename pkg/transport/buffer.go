@@ -0,0 +1,129 @@
+package transport
+
+import (
+	"sync"
+
+	"github.com/gorilla/websocket"
+)
+
+// defaultOfflineBuffer is the default number of exception messages held
+// in memory while the agent is not registered.
+const defaultOfflineBuffer = 100
+
+// WithOfflineBuffer sets how many exception messages are held in memory
+// while the agent is not connected or not yet registered. They are written
+// in capture order once the backend accepts the agent, and the oldest is
+// dropped when the buffer is full. Messages go to the disk queue instead
+// if one is configured. Zero or less disables the buffer, so such
+// messages are dropped. The default is 100.
+func WithOfflineBuffer(n int) Option {
+	return func(c *Connection) {
+		c.offline.size = n
+	}
+}
+
+// offlineBuffer holds encoded exception messages, oldest first.
+type offlineBuffer struct {
+	mu       sync.Mutex
+	size     int
+	messages [][]byte
+}
+
+// push appends a message. It returns false if the buffer is disabled, and
+// whether the oldest message was evicted to make room.
+func (b *offlineBuffer) push(data []byte) (ok, evicted bool) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	if b.size <= 0 {
+		return false, false
+	}
+	if len(b.messages) >= b.size {
+		b.messages[0] = nil
+		b.messages = b.messages[1:]
+		evicted = true
+	}
+	b.messages = append(b.messages, data)
+	return true, evicted
+}
+
+// take removes and returns all buffered messages.
+func (b *offlineBuffer) take() [][]byte {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	msgs := b.messages
+	b.messages = nil
+	return msgs
+}
+
+// restore puts messages that could not be written back in front of those
+// buffered since, dropping the oldest beyond the buffer size. It returns
+// the number dropped.
+func (b *offlineBuffer) restore(msgs [][]byte) int {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	all := append(msgs, b.messages...)
+	dropped := 0
+	if b.size > 0 && len(all) > b.size {
+		dropped = len(all) - b.size
+		all = all[dropped:]
+	}
+	b.messages = all
+	return dropped
+}
+
+// bufferMessage holds an exception message that cannot be delivered until
+// the agent is registered. It returns false if the buffer is disabled or
+// the message is not an exception.
+func (c *Connection) bufferMessage(data []byte) bool {
+	if !spoolable(data) {
+		return false
+	}
+	ok, evicted := c.offline.push(data)
+	if !ok {
+		return false
+	}
+	c.pending.Add(1)
+	if evicted {
+		c.pending.Add(-1)
+		c.counters.droppedDisconnected.Add(1)
+	}
+	return true
+}
+
+// replayBuffered writes the buffered messages on conn, oldest first. It
+// returns false if a write failed; the unwritten messages stay buffered.
+func (c *Connection) replayBuffered(conn *websocket.Conn) bool {
+	msgs := c.offline.take()
+	if len(msgs) > 0 && c.debug {
+		c.log.Debugf("Replaying %d buffered messages", len(msgs))
+	}
+
+	for i, data := range msgs {
+		if err := c.write(conn, data); err != nil {
+			if c.debug {
+				c.log.Debugf("Write error replaying buffered messages: %v", err)
+			}
+			if dropped := c.offline.restore(msgs[i:]); dropped > 0 {
+				c.pending.Add(-int64(dropped))
+				c.counters.droppedDisconnected.Add(int64(dropped))
+			}
+			return false
+		}
+		c.pending.Add(-1)
+		c.counters.markSent()
+		c.trackSent(data)
+	}
+	return true
+}
+
+// dropBuffered discards the buffered messages when the connection is
+// closed for good.
+func (c *Connection) dropBuffered() {
+	if n := len(c.offline.take()); n > 0 {
+		c.pending.Add(-int64(n))
+		c.counters.droppedDisconnected.Add(int64(n))
+	}
+}
@@ -2,60 +2,245 @@
 package transport
 
 import (
+	"bytes"
+	"compress/gzip"
 	"context"
+	"crypto/tls"
 	"encoding/json"
-	"log"
+	"math/rand"
+	"net"
 	"net/http"
 	"sync"
+	"sync/atomic"
 	"time"
 
-	"github.com/gorilla/websocket"
 	"github.com/aivorynet/agent-go/pkg/capture"
+	"github.com/aivorynet/agent-go/pkg/internal/logging"
+	"github.com/gorilla/websocket"
 )
 
 // Connection represents a WebSocket connection to the AIVory backend.
 type Connection struct {
-	url       string
-	apiKey    string
-	debug     bool
-	conn      *websocket.Conn
-	connected bool
+	url           string
+	apiKey        string
+	debug         bool
+	conn          *websocket.Conn
+	writeMu       sync.Mutex
+	connected     bool
 	authenticated bool
-	mu        sync.RWMutex
+	mu            sync.RWMutex
 
-	reconnectAttempts    int
+	reconnectAttempts    atomic.Int64
 	maxReconnectAttempts int
 	reconnectDelay       time.Duration
+	maxReconnectDelay    time.Duration
+	reconnectDisabled    bool
 
 	messageQueue chan []byte
 	done         chan struct{}
+	closeOnce    sync.Once
+
+	// priorityQueue holds messages written ahead of messageQueue as soon
+	// as the agent is registered; wake cuts a reconnect backoff short.
+	priorityQueue chan priorityMessage
+	wake          chan struct{}
+
+	// pending counts queued messages that have not been written or
+	// dropped yet.
+	pending atomic.Int64
+
+	counters counters
+
+	// batch accumulates exceptions when batching is enabled.
+	batchInterval time.Duration
+	batchSize     int
+	batchMu       sync.Mutex
+	batch         []*capture.ExceptionCapture
+	batchTimer    *time.Timer
+
+	// registered is signalled by the read loop when the backend accepts
+	// the agent; ready is closed once the agent is fully operational.
+	registered chan struct{}
+	ready      chan struct{}
+	readyOnce  sync.Once
 
 	breakpointCallback func(string, interface{})
+
+	stateHandler func(ConnectionState)
+	stateMu      sync.Mutex
+	state        ConnectionState
+
+	registerInfo map[string]interface{}
+	sendTimeout  time.Duration
+	compress     bool
+	spool        *diskQueue
+	offline      offlineBuffer
+	tlsConfig    *tls.Config
+
+	// heartbeatInterval is the interval between heartbeats and pings;
+	// readTimeout is how long the read loop waits for any message or pong
+	// before treating the connection as dead.
+	heartbeatInterval time.Duration
+	readTimeout       time.Duration
+
+	// reliable enables message IDs and acks for exceptions; inflight
+	// holds those written but not acknowledged yet.
+	reliable bool
+	inflight ackTracker
+
+	maxMessageBytes int
+
+	// handshakeHeaders are added to the WebSocket upgrade request.
+	handshakeHeaders http.Header
+
+	log *logging.Logger
+}
+
+// priorityMessage is a queued priority message; sent is closed once it has
+// been written.
+type priorityMessage struct {
+	data []byte
+	sent chan struct{}
+}
+
+// Option configures a Connection.
+type Option func(*Connection)
+
+// WithRegisterInfo adds extra fields to the register payload sent after
+// connecting.
+func WithRegisterInfo(info map[string]interface{}) Option {
+	return func(c *Connection) {
+		c.registerInfo = info
+	}
 }
 
 // Message represents a WebSocket message.
 type Message struct {
 	Type      string      `json:"type"`
+	MessageID string      `json:"message_id,omitempty"`
 	Payload   interface{} `json:"payload"`
 	Timestamp int64       `json:"timestamp"`
 }
 
+// WithSendTimeout bounds each message write. A write that does not
+// complete in time marks the connection dead and triggers a reconnect.
+// Zero disables the timeout.
+func WithSendTimeout(d time.Duration) Option {
+	return func(c *Connection) {
+		c.sendTimeout = d
+	}
+}
+
+// WithCompression gzip-compresses messages larger than 1 KiB and sends
+// them as binary frames. Text frames always hold plain JSON, so the
+// backend tells the two apart by the frame type; compressed frames also
+// start with the gzip magic bytes 0x1f 0x8b.
+func WithCompression(enable bool) Option {
+	return func(c *Connection) {
+		c.compress = enable
+	}
+}
+
+// WithReconnect configures reconnection: the delay before the first retry,
+// doubling after each failed attempt up to max, and the number of attempts
+// before giving up. maxAttempts <= 0 retries forever. The defaults are 1s,
+// 60s and 10 attempts.
+func WithReconnect(base, max time.Duration, maxAttempts int) Option {
+	return func(c *Connection) {
+		c.reconnectDelay = base
+		c.maxReconnectDelay = max
+		c.maxReconnectAttempts = maxAttempts
+	}
+}
+
+// WithTLSConfig sets the TLS configuration used to dial the backend, e.g.
+// to trust an internal CA. By default the system trust store is used.
+func WithTLSConfig(config *tls.Config) Option {
+	return func(c *Connection) {
+		c.tlsConfig = config
+	}
+}
+
+// WithHandshakeHeaders adds headers to the WebSocket upgrade request, e.g.
+// for routing by an ingress, an auth proxy or tracing. The Authorization
+// header always carries the API key as a bearer token; an Authorization
+// header in h is ignored.
+func WithHandshakeHeaders(h http.Header) Option {
+	return func(c *Connection) {
+		c.handshakeHeaders = h.Clone()
+	}
+}
+
+// WithHeartbeatInterval sets the interval at which heartbeats and
+// WebSocket pings are sent. Defaults to 30s.
+func WithHeartbeatInterval(d time.Duration) Option {
+	return func(c *Connection) {
+		c.heartbeatInterval = d
+	}
+}
+
+// WithReadTimeout sets how long the connection waits for a message or a
+// pong from the backend before it is considered dead and reconnected.
+// This detects half-open connections that would otherwise block reads
+// forever. Defaults to twice the heartbeat interval.
+func WithReadTimeout(d time.Duration) Option {
+	return func(c *Connection) {
+		c.readTimeout = d
+	}
+}
+
 // NewConnection creates a new connection.
-func NewConnection(url, apiKey string, debug bool) *Connection {
-	return &Connection{
+func NewConnection(url, apiKey string, debug bool, opts ...Option) *Connection {
+	c := &Connection{
 		url:                  url,
 		apiKey:               apiKey,
 		debug:                debug,
 		maxReconnectAttempts: 10,
 		reconnectDelay:       time.Second,
+		maxReconnectDelay:    60 * time.Second,
+		heartbeatInterval:    defaultHeartbeatInterval,
 		messageQueue:         make(chan []byte, 100),
 		done:                 make(chan struct{}),
+		priorityQueue:        make(chan priorityMessage, 10),
+		wake:                 make(chan struct{}, 1),
+		registered:           make(chan struct{}, 1),
+		ready:                make(chan struct{}),
+		offline:              offlineBuffer{size: defaultOfflineBuffer},
+		log:                  logging.New(nil),
+	}
+
+	for _, opt := range opts {
+		opt(c)
+	}
+
+	if c.heartbeatInterval <= 0 {
+		c.heartbeatInterval = defaultHeartbeatInterval
+	}
+	if c.readTimeout <= 0 {
+		c.readTimeout = 2 * c.heartbeatInterval
 	}
+	if c.handshakeHeaders.Get("Authorization") != "" {
+		c.log.Warnf("Ignoring Authorization handshake header, the API key is sent as a bearer token")
+	}
+
+	return c
 }
 
+// defaultHeartbeatInterval is the default interval between heartbeats.
+const defaultHeartbeatInterval = 30 * time.Second
+
 // Connect establishes the WebSocket connection.
 func (c *Connection) Connect(ctx context.Context) {
-	for {
+	normalized, err := NormalizeURL(c.url)
+	if err != nil {
+		c.log.Errorf("%v", err)
+		return
+	}
+	c.mu.Lock()
+	c.url = normalized
+	c.mu.Unlock()
+
+	for attempt := 0; ; attempt++ {
 		select {
 		case <-ctx.Done():
 			return
@@ -64,43 +249,87 @@ func (c *Connection) Connect(ctx context.Context) {
 		default:
 		}
 
+		if attempt > 0 {
+			c.counters.reconnects.Add(1)
+		}
 		err := c.connect()
 		if err != nil {
 			if c.debug {
-				log.Printf("[AIVory Monitor] Connection error: %v", err)
+				c.log.Debugf("Connection error: %v", err)
 			}
 
-			c.reconnectAttempts++
-			if c.reconnectAttempts > c.maxReconnectAttempts {
-				log.Println("[AIVory Monitor] Max reconnect attempts reached")
+			c.mu.RLock()
+			disabled := c.reconnectDisabled
+			c.mu.RUnlock()
+			if disabled {
+				c.setState(StateFailed)
 				return
 			}
 
-			delay := c.reconnectDelay * time.Duration(1<<uint(c.reconnectAttempts-1))
-			if delay > 60*time.Second {
-				delay = 60 * time.Second
+			attempts := int(c.reconnectAttempts.Add(1))
+			if c.maxReconnectAttempts > 0 && attempts > c.maxReconnectAttempts {
+				c.log.Warnf("Max reconnect attempts reached")
+				c.setState(StateFailed)
+				return
 			}
 
+			delay := c.backoff(attempts)
+
 			if c.debug {
-				log.Printf("[AIVory Monitor] Reconnecting in %v (attempt %d)", delay, c.reconnectAttempts)
+				c.log.Debugf("Reconnecting in %v (attempt %d)", delay, attempts)
 			}
 
-			time.Sleep(delay)
+			select {
+			case <-time.After(delay):
+			case <-c.wake:
+			case <-ctx.Done():
+				return
+			case <-c.done:
+				return
+			}
 			continue
 		}
 
-		c.reconnectAttempts = 0
+		c.reconnectAttempts.Store(0)
 		c.runMessageLoop()
 	}
 }
 
-// Disconnect closes the connection.
+// backoff returns the delay before the given reconnect attempt: doubling
+// from reconnectDelay up to maxReconnectDelay, with the upper half
+// randomized so that agents disconnected by a backend restart do not all
+// reconnect at once.
+func (c *Connection) backoff(attempt int) time.Duration {
+	delay := c.maxReconnectDelay
+	if attempt <= 32 {
+		if d := c.reconnectDelay << uint(attempt-1); d > 0 && d < delay {
+			delay = d
+		}
+	}
+	if delay <= 0 {
+		return 0
+	}
+
+	half := delay / 2
+	return half + time.Duration(rand.Int63n(int64(delay-half)+1))
+}
+
+// Disconnect closes the connection. A pending batch is sent first, waiting
+// up to a second for it to be written, and messages held in the offline
+// buffer are dropped. It is safe to call more than once
+// and from several goroutines; calls after the first do nothing.
 func (c *Connection) Disconnect() {
+	c.closeOnce.Do(c.disconnect)
+}
+
+func (c *Connection) disconnect() {
+	if c.flushBatch() {
+		c.Flush(time.Second)
+	}
+
 	close(c.done)
 
 	c.mu.Lock()
-	defer c.mu.Unlock()
-
 	if c.conn != nil {
 		c.conn.Close()
 		c.conn = nil
@@ -108,13 +337,155 @@ func (c *Connection) Disconnect() {
 
 	c.connected = false
 	c.authenticated = false
+	c.mu.Unlock()
+
+	c.dropBuffered()
+	c.setState(StateDisconnected)
+}
+
+// Flush sends any pending batch and blocks until all queued messages have
+// been written or the timeout elapses. It returns true if the queue was
+// drained.
+func (c *Connection) Flush(timeout time.Duration) bool {
+	c.flushBatch()
+
+	deadline := time.Now().Add(timeout)
+	for c.pending.Load() > 0 {
+		if time.Now().After(deadline) {
+			return false
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	return true
 }
 
 // SendException sends an exception capture to the backend.
 func (c *Connection) SendException(exc *capture.ExceptionCapture) {
+	if c.batching() {
+		c.addToBatch(exc)
+		return
+	}
 	c.send("exception", exc)
 }
 
+// SendPriority sends an exception capture ahead of queued messages. If the
+// agent is not connected yet it cuts any reconnect backoff short so the
+// connection is attempted immediately, then waits up to timeout for the
+// capture to be written. It returns true if the capture was written in
+// time; otherwise the capture stays queued and is sent once connected.
+func (c *Connection) SendPriority(exc *capture.ExceptionCapture, timeout time.Duration) bool {
+	data, err := marshalMessage("exception", c.newMessageID("exception"), exc)
+	if err != nil {
+		if c.debug {
+			c.log.Debugf("Error marshaling message: %v", err)
+		}
+		return false
+	}
+	if c.oversized(data) {
+		if data = c.shrinkMessage("exception", exc, len(data)); data == nil {
+			return false
+		}
+	}
+
+	msg := priorityMessage{data: data, sent: make(chan struct{})}
+	c.pending.Add(1)
+	select {
+	case c.priorityQueue <- msg:
+	default:
+		c.pending.Add(-1)
+		if c.debug {
+			c.log.Debugf("Priority queue full, sending capture normally")
+		}
+		c.SendException(exc)
+		return false
+	}
+
+	if !c.IsConnected() {
+		select {
+		case c.wake <- struct{}{}:
+		default:
+		}
+	}
+
+	timer := time.NewTimer(timeout)
+	defer timer.Stop()
+
+	select {
+	case <-msg.sent:
+		return true
+	case <-timer.C:
+		if c.debug {
+			c.log.Debugf("Priority capture not sent within %v, leaving it queued", timeout)
+		}
+		return false
+	}
+}
+
+// SendSync writes an exception capture on the calling goroutine, bypassing
+// the queue, and returns true if it was written within timeout. With
+// reliable delivery it also waits, within the same timeout, for the
+// backend to acknowledge it. If the agent is not connected, the capture is
+// sent as by SendPriority and SendSync waits for it to be written.
+func (c *Connection) SendSync(exc *capture.ExceptionCapture, timeout time.Duration) bool {
+	c.mu.RLock()
+	conn := c.conn
+	connected := conn != nil && c.connected && c.authenticated
+	c.mu.RUnlock()
+
+	if !connected {
+		return c.SendPriority(exc, timeout)
+	}
+
+	data, err := marshalMessage("exception", c.newMessageID("exception"), exc)
+	if err != nil {
+		if c.debug {
+			c.log.Debugf("Error marshaling message: %v", err)
+		}
+		return false
+	}
+	if c.oversized(data) {
+		if data = c.shrinkMessage("exception", exc, len(data)); data == nil {
+			return false
+		}
+	}
+
+	deadline := time.Now().Add(timeout)
+	var acked <-chan bool
+	if id := messageID(data); id != "" {
+		var done func()
+		acked, done = c.inflight.wait(id)
+		defer done()
+	}
+
+	if err := c.writeBefore(conn, data, deadline); err != nil {
+		if c.debug {
+			c.log.Debugf("Write error: %v", err)
+		}
+		c.markDead(conn)
+		return false
+	}
+	c.counters.markSent()
+	c.trackSent(data)
+
+	if acked == nil {
+		return true
+	}
+	timer := time.NewTimer(time.Until(deadline))
+	defer timer.Stop()
+	select {
+	case ok := <-acked:
+		if !ok && c.debug {
+			c.log.Debugf("Capture %s given up before it was acknowledged", exc.ID)
+		}
+		return ok
+	case <-timer.C:
+		if c.debug {
+			c.log.Debugf("Capture %s not acknowledged within %v", exc.ID, timeout)
+		}
+		return false
+	}
+}
+
 // SendBreakpointHit sends a breakpoint hit to the backend.
 func (c *Connection) SendBreakpointHit(breakpointID string, payload map[string]interface{}) {
 	payload["breakpoint_id"] = breakpointID
@@ -126,6 +497,14 @@ func (c *Connection) SetBreakpointCallback(callback func(string, interface{})) {
 	c.breakpointCallback = callback
 }
 
+// Ready returns a channel that is closed once the connection has been
+// established, the agent has registered with the backend and any buffered
+// messages have been handed to the writer. It stays closed across later
+// reconnects.
+func (c *Connection) Ready() <-chan struct{} {
+	return c.ready
+}
+
 // IsConnected returns true if connected and authenticated.
 func (c *Connection) IsConnected() bool {
 	c.mu.RLock()
@@ -134,15 +513,20 @@ func (c *Connection) IsConnected() bool {
 }
 
 func (c *Connection) connect() error {
-	headers := http.Header{}
+	headers := c.handshakeHeaders.Clone()
+	if headers == nil {
+		headers = http.Header{}
+	}
 	headers.Set("Authorization", "Bearer "+c.apiKey)
 
 	if c.debug {
-		log.Printf("[AIVory Monitor] Connecting to %s", c.url)
+		c.log.Debugf("Connecting to %s", c.url)
 	}
 
-	conn, _, err := websocket.DefaultDialer.Dial(c.url, headers)
+	c.setState(StateConnecting)
+	conn, _, err := c.dialer().Dial(c.url, headers)
 	if err != nil {
+		c.setState(StateDisconnected)
 		return err
 	}
 
@@ -150,9 +534,10 @@ func (c *Connection) connect() error {
 	c.conn = conn
 	c.connected = true
 	c.mu.Unlock()
+	c.setState(StateConnected)
 
 	if c.debug {
-		log.Println("[AIVory Monitor] WebSocket connected")
+		c.log.Debugf("WebSocket connected")
 	}
 
 	// Authenticate
@@ -161,6 +546,16 @@ func (c *Connection) connect() error {
 	return nil
 }
 
+// dialer returns the WebSocket dialer, using the TLS configuration if set.
+func (c *Connection) dialer() *websocket.Dialer {
+	if c.tlsConfig == nil {
+		return websocket.DefaultDialer
+	}
+	d := *websocket.DefaultDialer
+	d.TLSClientConfig = c.tlsConfig
+	return &d
+}
+
 func (c *Connection) authenticate() {
 	hostname := ""
 	// Get hostname (simplified)
@@ -171,34 +566,87 @@ func (c *Connection) authenticate() {
 		"hostname":      hostname,
 		"runtime":       "go",
 	}
+	for k, v := range c.registerInfo {
+		payload[k] = v
+	}
 
 	c.sendDirect("register", payload)
 }
 
 func (c *Connection) runMessageLoop() {
 	// Start heartbeat
-	heartbeatTicker := time.NewTicker(30 * time.Second)
+	heartbeatTicker := time.NewTicker(c.heartbeatInterval)
 	defer heartbeatTicker.Stop()
 
+	c.mu.RLock()
+	conn := c.conn
+	c.mu.RUnlock()
+
+	// Any message, ping or pong from the backend extends the read
+	// deadline; a half-open connection times out the read instead.
+	extendDeadline := func() {
+		conn.SetReadDeadline(time.Now().Add(c.readTimeout))
+	}
+	conn.SetPongHandler(func(string) error {
+		extendDeadline()
+		return nil
+	})
+	conn.SetPingHandler(func(data string) error {
+		extendDeadline()
+		err := conn.WriteControl(websocket.PongMessage, []byte(data), time.Now().Add(controlWriteTimeout))
+		if err == websocket.ErrCloseSent {
+			return nil
+		}
+		if e, ok := err.(net.Error); ok && e.Timeout() {
+			return nil
+		}
+		return err
+	})
+	extendDeadline()
+
 	// Read messages
 	readDone := make(chan struct{})
 	go func() {
 		defer close(readDone)
 		for {
-			_, message, err := c.conn.ReadMessage()
+			_, message, err := conn.ReadMessage()
 			if err != nil {
 				if c.debug && !websocket.IsCloseError(err, websocket.CloseNormalClosure) {
-					log.Printf("[AIVory Monitor] Read error: %v", err)
+					c.log.Debugf("Read error: %v", err)
 				}
 				return
 			}
+			extendDeadline()
 			c.handleMessage(message)
 		}
 	}()
 
 	// Main loop
 	for {
+		// Priority messages are written first, once registered.
+		c.mu.RLock()
+		authenticated := c.authenticated
+		c.mu.RUnlock()
+
+		priority := c.priorityQueue
+		if !authenticated {
+			priority = nil
+		}
+
 		select {
+		case msg := <-priority:
+			if !c.writePriority(conn, msg) {
+				return
+			}
+			continue
+		default:
+		}
+
+		select {
+		case msg := <-priority:
+			if !c.writePriority(conn, msg) {
+				return
+			}
 		case <-c.done:
 			return
 		case <-readDone:
@@ -206,8 +654,22 @@ func (c *Connection) runMessageLoop() {
 			c.connected = false
 			c.authenticated = false
 			c.mu.Unlock()
+			c.setState(StateDisconnected)
 			return
+		case <-c.registered:
+			if !c.replaySpool(conn) || !c.replayBuffered(conn) || !c.resendUnacked(conn, 0) {
+				c.markDead(conn)
+				return
+			}
+			c.readyOnce.Do(func() {
+				close(c.ready)
+			})
 		case <-heartbeatTicker.C:
+			conn.WriteControl(websocket.PingMessage, nil, time.Now().Add(controlWriteTimeout))
+			if c.authenticated && !c.resendUnacked(conn, ackTimeout) {
+				c.markDead(conn)
+				return
+			}
 			if c.authenticated {
 				c.send("heartbeat", map[string]interface{}{
 					"timestamp": time.Now().UnixMilli(),
@@ -215,30 +677,150 @@ func (c *Connection) runMessageLoop() {
 			}
 		case msg := <-c.messageQueue:
 			c.mu.RLock()
-			if c.conn != nil && c.connected && c.authenticated {
-				c.conn.WriteMessage(websocket.TextMessage, msg)
-			}
+			ok := c.conn != nil && c.connected && c.authenticated
 			c.mu.RUnlock()
+
+			if !ok {
+				c.pending.Add(-1)
+				if !c.spoolMessage(msg) && !c.bufferMessage(msg) {
+					c.counters.droppedDisconnected.Add(1)
+				}
+				continue
+			}
+			if err := c.write(conn, msg); err != nil {
+				if c.debug {
+					c.log.Debugf("Write error: %v", err)
+				}
+				c.requeue(msg)
+				c.markDead(conn)
+				return
+			}
+			c.pending.Add(-1)
+			c.counters.markSent()
+			c.trackSent(msg)
+		}
+	}
+}
+
+// controlWriteTimeout bounds writing a ping or pong control frame.
+const controlWriteTimeout = 5 * time.Second
+
+// compressThreshold is the message size above which messages are
+// compressed when compression is enabled.
+const compressThreshold = 1024
+
+// write sends a message on conn, bounded by the send timeout if set.
+func (c *Connection) write(conn *websocket.Conn, data []byte) error {
+	var deadline time.Time
+	if c.sendTimeout > 0 {
+		deadline = time.Now().Add(c.sendTimeout)
+	}
+	return c.writeBefore(conn, data, deadline)
+}
+
+// writeBefore sends a message on conn that must be written before
+// deadline. A zero deadline does not bound the write.
+func (c *Connection) writeBefore(conn *websocket.Conn, data []byte, deadline time.Time) error {
+	messageType := websocket.TextMessage
+	if c.compress && len(data) > compressThreshold {
+		if compressed, err := gzipBytes(data); err == nil {
+			messageType, data = websocket.BinaryMessage, compressed
+		}
+	}
+
+	c.writeMu.Lock()
+	defer c.writeMu.Unlock()
+
+	conn.SetWriteDeadline(deadline)
+	return conn.WriteMessage(messageType, data)
+}
+
+// gzipBytes returns data compressed with gzip.
+func gzipBytes(data []byte) ([]byte, error) {
+	var buf bytes.Buffer
+	zw := gzip.NewWriter(&buf)
+	if _, err := zw.Write(data); err != nil {
+		return nil, err
+	}
+	if err := zw.Close(); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+// writePriority writes a priority message and signals its sender. On
+// failure the message is queued again, the connection is marked dead and
+// false is returned.
+func (c *Connection) writePriority(conn *websocket.Conn, msg priorityMessage) bool {
+	if err := c.write(conn, msg.data); err != nil {
+		if c.debug {
+			c.log.Debugf("Write error: %v", err)
+		}
+		select {
+		case c.priorityQueue <- msg:
+		default:
+			c.requeue(msg.data)
 		}
+		c.markDead(conn)
+		return false
 	}
+	c.pending.Add(-1)
+	c.counters.markSent()
+	c.trackSent(msg.data)
+	close(msg.sent)
+	return true
+}
+
+// requeue puts a message that failed to send back on the queue so it is
+// retried after reconnecting. It is dropped if the queue is full.
+func (c *Connection) requeue(data []byte) {
+	select {
+	case c.messageQueue <- data:
+	default:
+		c.pending.Add(-1)
+		if c.spoolMessage(data) {
+			return
+		}
+		c.counters.droppedQueueFull.Add(1)
+		if c.debug {
+			c.log.Debugf("Queue full, dropping message after failed write")
+		}
+	}
+}
+
+// markDead closes a connection that can no longer be written to so the
+// read loop exits and Connect re-establishes it.
+func (c *Connection) markDead(conn *websocket.Conn) {
+	c.mu.Lock()
+	if c.conn == conn {
+		c.conn = nil
+		c.connected = false
+		c.authenticated = false
+	}
+	c.mu.Unlock()
+
+	conn.Close()
+	c.setState(StateDisconnected)
 }
 
 func (c *Connection) handleMessage(data []byte) {
 	var msg Message
 	if err := json.Unmarshal(data, &msg); err != nil {
 		if c.debug {
-			log.Printf("[AIVory Monitor] Error parsing message: %v", err)
+			c.log.Debugf("Error parsing message: %v", err)
 		}
 		return
 	}
 
 	if c.debug {
-		log.Printf("[AIVory Monitor] Received: %s", msg.Type)
+		c.log.Debugf("Received: %s", msg.Type)
 	}
 
 	switch msg.Type {
 	case "registered":
 		c.handleRegistered()
+	case "ack":
+		c.handleAck(msg.Payload)
 	case "error":
 		c.handleError(msg.Payload)
 	case "set_breakpoint":
@@ -249,9 +831,31 @@ func (c *Connection) handleMessage(data []byte) {
 		if c.breakpointCallback != nil {
 			c.breakpointCallback("remove", msg.Payload)
 		}
+	case "breakpoint":
+		c.handleBreakpoint(msg.Payload)
 	default:
 		if c.debug {
-			log.Printf("[AIVory Monitor] Unhandled message type: %s", msg.Type)
+			c.log.Debugf("Unhandled message type: %s", msg.Type)
+		}
+	}
+}
+
+// handleBreakpoint dispatches a "breakpoint" message, whose payload names
+// the sub-command ("set" or "remove") in its command field, to the
+// breakpoint callback.
+func (c *Connection) handleBreakpoint(payload interface{}) {
+	payloadMap, ok := payload.(map[string]interface{})
+	if !ok || c.breakpointCallback == nil {
+		return
+	}
+
+	command, _ := payloadMap["command"].(string)
+	switch command {
+	case "set", "remove":
+		c.breakpointCallback(command, payloadMap)
+	default:
+		if c.debug {
+			c.log.Debugf("Unknown breakpoint command: %q", command)
 		}
 	}
 }
@@ -260,9 +864,15 @@ func (c *Connection) handleRegistered() {
 	c.mu.Lock()
 	c.authenticated = true
 	c.mu.Unlock()
+	c.setState(StateAuthenticated)
 
 	if c.debug {
-		log.Println("[AIVory Monitor] Agent registered")
+		c.log.Debugf("Agent registered")
+	}
+
+	select {
+	case c.registered <- struct{}{}:
+	default:
 	}
 }
 
@@ -275,56 +885,74 @@ func (c *Connection) handleError(payload interface{}) {
 	code, _ := payloadMap["code"].(string)
 	message, _ := payloadMap["message"].(string)
 
-	log.Printf("[AIVory Monitor] Backend error: %s - %s", code, message)
+	c.log.Errorf("Backend error: %s - %s", code, message)
 
 	if code == "auth_error" || code == "invalid_api_key" {
-		log.Println("[AIVory Monitor] Authentication failed, disabling reconnect")
-		c.maxReconnectAttempts = 0
+		c.log.Errorf("Authentication failed, disabling reconnect")
+		c.mu.Lock()
+		c.reconnectDisabled = true
+		c.mu.Unlock()
 		c.Disconnect()
 	}
 }
 
-func (c *Connection) send(msgType string, payload interface{}) {
-	msg := Message{
+// marshalMessage encodes a message of the given type for the wire. The
+// message ID is omitted if empty.
+func marshalMessage(msgType, id string, payload interface{}) ([]byte, error) {
+	return json.Marshal(Message{
 		Type:      msgType,
+		MessageID: id,
 		Payload:   payload,
 		Timestamp: time.Now().UnixMilli(),
-	}
+	})
+}
 
-	data, err := json.Marshal(msg)
+func (c *Connection) send(msgType string, payload interface{}) {
+	data, err := marshalMessage(msgType, c.newMessageID(msgType), payload)
 	if err != nil {
 		if c.debug {
-			log.Printf("[AIVory Monitor] Error marshaling message: %v", err)
+			c.log.Debugf("Error marshaling message: %v", err)
 		}
 		return
 	}
+	if c.oversized(data) {
+		if data = c.shrinkMessage(msgType, payload, len(data)); data == nil {
+			return
+		}
+	}
 
 	c.mu.RLock()
 	connected := c.connected && c.authenticated
 	c.mu.RUnlock()
 
-	if connected {
+	if !connected {
+		if !c.spoolMessage(data) && !c.bufferMessage(data) {
+			c.counters.droppedDisconnected.Add(1)
+		}
+		return
+	}
+
+	c.pending.Add(1)
+	select {
+	case c.messageQueue <- data:
+	default:
+		// Queue full, spool the message if possible, otherwise drop oldest
+		if c.spoolMessage(data) {
+			c.pending.Add(-1)
+			return
+		}
 		select {
-		case c.messageQueue <- data:
+		case <-c.messageQueue:
+			c.pending.Add(-1)
+			c.counters.droppedQueueFull.Add(1)
 		default:
-			// Queue full, drop oldest
-			select {
-			case <-c.messageQueue:
-			default:
-			}
-			c.messageQueue <- data
 		}
+		c.messageQueue <- data
 	}
 }
 
 func (c *Connection) sendDirect(msgType string, payload interface{}) {
-	msg := Message{
-		Type:      msgType,
-		Payload:   payload,
-		Timestamp: time.Now().UnixMilli(),
-	}
-
-	data, err := json.Marshal(msg)
+	data, err := marshalMessage(msgType, "", payload)
 	if err != nil {
 		return
 	}
@@ -334,6 +962,6 @@ func (c *Connection) sendDirect(msgType string, payload interface{}) {
 	c.mu.RUnlock()
 
 	if conn != nil {
-		conn.WriteMessage(websocket.TextMessage, data)
+		c.write(conn, data)
 	}
 }
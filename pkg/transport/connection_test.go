@@ -0,0 +1,312 @@
+package transport
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/aivorynet/agent-go/pkg/capture"
+	"github.com/gorilla/websocket"
+)
+
+// fakeBackend is a WebSocket server that registers agents and records the
+// messages they send.
+type fakeBackend struct {
+	*httptest.Server
+	messages chan Message
+}
+
+// newFakeBackend starts a backend that answers the register message once
+// accept is closed, or at once if accept is nil.
+func newFakeBackend(t *testing.T, accept <-chan struct{}) *fakeBackend {
+	t.Helper()
+
+	b := &fakeBackend{messages: make(chan Message, 100)}
+	b.Server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		conn, err := (&websocket.Upgrader{}).Upgrade(w, r, nil)
+		if err != nil {
+			return
+		}
+		defer conn.Close()
+
+		registered := false
+		for {
+			_, data, err := conn.ReadMessage()
+			if err != nil {
+				return
+			}
+			var msg Message
+			if json.Unmarshal(data, &msg) != nil {
+				continue
+			}
+			b.messages <- msg
+
+			if msg.Type == "register" && !registered {
+				registered = true
+				if accept != nil {
+					<-accept
+				}
+				conn.WriteJSON(Message{Type: "registered"})
+			}
+		}
+	}))
+	t.Cleanup(b.Close)
+	return b
+}
+
+// wsURL returns the ws:// URL of the backend.
+func (b *fakeBackend) wsURL() string {
+	return "ws" + strings.TrimPrefix(b.URL, "http")
+}
+
+// next returns the next message received by the backend.
+func (b *fakeBackend) next(t *testing.T) Message {
+	t.Helper()
+
+	select {
+	case msg := <-b.messages:
+		return msg
+	case <-time.After(5 * time.Second):
+		t.Fatal("timed out waiting for a message")
+		return Message{}
+	}
+}
+
+// connect starts c connecting in the background and disconnects it when
+// the test ends.
+func connect(t *testing.T, c *Connection) {
+	t.Helper()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	go c.Connect(ctx)
+	t.Cleanup(func() {
+		cancel()
+		c.Disconnect()
+	})
+}
+
+func TestReadyAfterReplay(t *testing.T) {
+	accept := make(chan struct{})
+	b := newFakeBackend(t, accept)
+	c := NewConnection(b.wsURL(), "key", false)
+
+	for i := 0; i < 3; i++ {
+		c.SendException(&capture.ExceptionCapture{ID: fmt.Sprint(i)})
+	}
+	connect(t, c)
+
+	if msg := b.next(t); msg.Type != "register" {
+		t.Fatalf("first message = %q, want register", msg.Type)
+	}
+	select {
+	case <-c.Ready():
+		t.Fatal("Ready closed before the agent was registered")
+	default:
+	}
+
+	close(accept)
+	select {
+	case <-c.Ready():
+	case <-time.After(5 * time.Second):
+		t.Fatal("Ready not closed after registering")
+	}
+	if n := c.pending.Load(); n != 0 {
+		t.Errorf("Ready closed with %d buffered messages not written yet", n)
+	}
+
+	for i := 0; i < 3; i++ {
+		msg := b.next(t)
+		payload, _ := msg.Payload.(map[string]interface{})
+		if msg.Type != "exception" || payload["id"] != fmt.Sprint(i) {
+			t.Errorf("replayed message %d = %s %v, want exception %d", i, msg.Type, payload["id"], i)
+		}
+	}
+}
+
+func TestSendTimeoutReconnectsStalledBackend(t *testing.T) {
+	var connections atomic.Int32
+	release := make(chan struct{})
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		conn, err := (&websocket.Upgrader{}).Upgrade(w, r, nil)
+		if err != nil {
+			return
+		}
+		defer conn.Close()
+		connections.Add(1)
+
+		// Register the agent, then stop reading so its writes block.
+		if _, _, err := conn.ReadMessage(); err != nil {
+			return
+		}
+		conn.WriteJSON(Message{Type: "registered"})
+		<-release
+	}))
+	t.Cleanup(srv.Close)
+	t.Cleanup(func() { close(release) })
+
+	c := NewConnection("ws"+strings.TrimPrefix(srv.URL, "http"), "key", false,
+		WithSendTimeout(100*time.Millisecond),
+		WithReconnect(10*time.Millisecond, 10*time.Millisecond, 0),
+	)
+	connect(t, c)
+
+	select {
+	case <-c.Ready():
+	case <-time.After(5 * time.Second):
+		t.Fatal("not ready")
+	}
+
+	blob := strings.Repeat("x", 1<<20)
+	for i := 0; i < 32; i++ {
+		c.SendException(&capture.ExceptionCapture{ID: fmt.Sprint(i), Context: map[string]interface{}{"blob": blob}})
+	}
+
+	deadline := time.Now().Add(5 * time.Second)
+	for connections.Load() < 2 {
+		if time.Now().After(deadline) {
+			t.Fatal("a write to a stalled backend did not time out and reconnect")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+func TestSendPriorityBeforeConnected(t *testing.T) {
+	b := newFakeBackend(t, nil)
+
+	// The first dial fails, leaving the connection in a long backoff that
+	// the priority capture must cut short.
+	var attempts atomic.Int32
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if attempts.Add(1) == 1 {
+			http.Error(w, "starting", http.StatusServiceUnavailable)
+			return
+		}
+		b.Config.Handler.ServeHTTP(w, r)
+	}))
+	t.Cleanup(srv.Close)
+
+	c := NewConnection("ws"+strings.TrimPrefix(srv.URL, "http"), "key", false, WithReconnect(time.Hour, time.Hour, 0))
+	connect(t, c)
+	for attempts.Load() == 0 {
+		time.Sleep(time.Millisecond)
+	}
+
+	start := time.Now()
+	if !c.SendPriority(&capture.ExceptionCapture{ID: "startup"}, 5*time.Second) {
+		t.Fatal("SendPriority returned false")
+	}
+	if elapsed := time.Since(start); elapsed > 4*time.Second {
+		t.Errorf("SendPriority took %v, the reconnect backoff was not cut short", elapsed)
+	}
+
+	if msg := b.next(t); msg.Type != "register" {
+		t.Fatalf("first message = %q, want register", msg.Type)
+	}
+	msg := b.next(t)
+	if payload, _ := msg.Payload.(map[string]interface{}); msg.Type != "exception" || payload["id"] != "startup" {
+		t.Errorf("second message = %s %v, want the priority capture", msg.Type, msg.Payload)
+	}
+}
+
+func TestSendPriorityTimeout(t *testing.T) {
+	srv := httptest.NewServer(http.NotFoundHandler())
+	url := "ws" + strings.TrimPrefix(srv.URL, "http")
+	srv.Close()
+
+	c := NewConnection(url, "key", false, WithReconnect(time.Hour, time.Hour, 0))
+	connect(t, c)
+
+	start := time.Now()
+	if c.SendPriority(&capture.ExceptionCapture{ID: "startup"}, 100*time.Millisecond) {
+		t.Fatal("SendPriority returned true without a backend")
+	}
+	if elapsed := time.Since(start); elapsed > 2*time.Second {
+		t.Errorf("SendPriority blocked for %v, want about the timeout", elapsed)
+	}
+	if n := c.pending.Load(); n != 1 {
+		t.Errorf("pending = %d, want the capture to stay queued", n)
+	}
+}
+
+func TestDisconnectTwice(t *testing.T) {
+	b := newFakeBackend(t, nil)
+	c := NewConnection(b.wsURL(), "key", false)
+	connect(t, c)
+	b.next(t)
+
+	c.Disconnect()
+	c.Disconnect()
+
+	var wg sync.WaitGroup
+	for i := 0; i < 4; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			c.Disconnect()
+		}()
+	}
+	wg.Wait()
+
+	if c.IsConnected() {
+		t.Error("IsConnected = true after Disconnect")
+	}
+}
+
+func TestHandleBreakpointCommands(t *testing.T) {
+	tests := []struct {
+		message     string
+		wantCommand string
+		wantID      string
+	}{
+		{`{"type":"breakpoint","payload":{"command":"set","id":"bp-1","line":42}}`, "set", "bp-1"},
+		{`{"type":"breakpoint","payload":{"command":"remove","id":"bp-1"}}`, "remove", "bp-1"},
+		{`{"type":"set_breakpoint","payload":{"id":"bp-2"}}`, "set", "bp-2"},
+		{`{"type":"remove_breakpoint","payload":{"id":"bp-2"}}`, "remove", "bp-2"},
+		{`{"type":"breakpoint","payload":{"command":"pause","id":"bp-3"}}`, "", ""},
+		{`{"type":"breakpoint","payload":"set"}`, "", ""},
+	}
+	for _, tt := range tests {
+		c := NewConnection("ws://localhost", "key", false)
+		var command, id string
+		c.SetBreakpointCallback(func(cmd string, payload interface{}) {
+			command = cmd
+			id, _ = payload.(map[string]interface{})["id"].(string)
+		})
+
+		c.handleMessage([]byte(tt.message))
+		if command != tt.wantCommand || id != tt.wantID {
+			t.Errorf("%s routed as %q %q, want %q %q", tt.message, command, id, tt.wantCommand, tt.wantID)
+		}
+	}
+}
+
+func TestSendBreakpointHit(t *testing.T) {
+	accept := make(chan struct{})
+	close(accept)
+	b := newFakeBackend(t, accept)
+	c := NewConnection(b.wsURL(), "key", false)
+	connect(t, c)
+
+	if msg := b.next(t); msg.Type != "register" {
+		t.Fatalf("first message = %q, want register", msg.Type)
+	}
+	select {
+	case <-c.Ready():
+	case <-time.After(5 * time.Second):
+		t.Fatal("not ready after registering")
+	}
+	c.SendBreakpointHit("bp-1", map[string]interface{}{"hit_count": 1})
+
+	msg := b.next(t)
+	payload, _ := msg.Payload.(map[string]interface{})
+	if msg.Type != "breakpoint_hit" || payload["breakpoint_id"] != "bp-1" || payload["hit_count"] != float64(1) {
+		t.Errorf("received %s %v, want a breakpoint_hit of bp-1", msg.Type, msg.Payload)
+	}
+}
@@ -0,0 +1,150 @@
+package transport
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+
+	"github.com/aivorynet/agent-go/pkg/capture"
+	"github.com/aivorynet/agent-go/pkg/internal/logging"
+)
+
+// FileSink writes captures to a local file as newline-delimited JSON, one
+// ExceptionCapture per line, e.g. to inspect captures while debugging
+// without a backend. Captures are written on the calling goroutine.
+type FileSink struct {
+	path     string
+	maxBytes int64
+	log      *logging.Logger
+	counters counters
+
+	mu     sync.Mutex
+	f      *os.File
+	size   int64
+	closed bool
+}
+
+var _ Transport = (*FileSink)(nil)
+
+// NewFileSink opens path for appending, creating it and its directory if
+// needed. When a write would grow the file beyond maxBytes, the file is
+// renamed to path + ".1", replacing the previous one, and a new file is
+// started. Zero or less disables rotation.
+func NewFileSink(path string, maxBytes int64) (*FileSink, error) {
+	s := &FileSink{
+		path:     path,
+		maxBytes: maxBytes,
+		log:      logging.New(nil),
+	}
+	if err := s.open(os.O_APPEND); err != nil {
+		return nil, err
+	}
+	return s, nil
+}
+
+// SetLogger sends the sink's log lines to l instead of the standard
+// logger. It must be called before any capture is sent.
+func (s *FileSink) SetLogger(l Logger) {
+	s.log = logging.New(l)
+}
+
+// open opens the file with the given extra flag, O_APPEND or O_TRUNC.
+func (s *FileSink) open(flag int) error {
+	if err := os.MkdirAll(filepath.Dir(s.path), 0o700); err != nil {
+		return fmt.Errorf("file sink: %w", err)
+	}
+	f, err := os.OpenFile(s.path, os.O_CREATE|os.O_WRONLY|flag, 0o600)
+	if err != nil {
+		return fmt.Errorf("file sink: %w", err)
+	}
+	info, err := f.Stat()
+	if err != nil {
+		f.Close()
+		return fmt.Errorf("file sink: %w", err)
+	}
+	s.f = f
+	s.size = info.Size()
+	return nil
+}
+
+// SendException appends a capture to the file.
+func (s *FileSink) SendException(exc *capture.ExceptionCapture) {
+	line, err := json.Marshal(exc)
+	if err != nil {
+		s.log.Errorf("File sink: error marshaling capture: %v", err)
+		return
+	}
+	line = append(line, '\n')
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.closed {
+		s.counters.droppedDisconnected.Add(1)
+		return
+	}
+	if s.maxBytes > 0 && s.size > 0 && s.size+int64(len(line)) > s.maxBytes {
+		if err := s.rotate(); err != nil {
+			s.log.Errorf("File sink: %v", err)
+			return
+		}
+	}
+
+	n, err := s.f.Write(line)
+	s.size += int64(n)
+	if err != nil {
+		s.log.Errorf("File sink: error writing capture: %v", err)
+		return
+	}
+	s.counters.markSent()
+}
+
+// rotate moves the current file aside and starts a new one.
+func (s *FileSink) rotate() error {
+	s.f.Close()
+	if err := os.Rename(s.path, s.path+".1"); err != nil {
+		// Keep writing to the current file rather than losing captures.
+		s.log.Warnf("File sink: cannot rotate %s: %v", s.path, err)
+		return s.open(os.O_APPEND)
+	}
+	return s.open(os.O_TRUNC)
+}
+
+// IsConnected returns true until the sink is disconnected.
+func (s *FileSink) IsConnected() bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return !s.closed
+}
+
+// Flush commits the written captures to stable storage.
+func (s *FileSink) Flush(timeout time.Duration) bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.closed {
+		return true
+	}
+	return s.f.Sync() == nil
+}
+
+// Disconnect closes the file. Later captures are dropped.
+func (s *FileSink) Disconnect() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.closed {
+		return
+	}
+	s.closed = true
+	s.f.Close()
+}
+
+// Stats returns a snapshot of the sink's counters: Sent counts captures
+// written and DroppedDisconnected those sent after Disconnect.
+func (s *FileSink) Stats() Stats {
+	return s.counters.snapshot()
+}
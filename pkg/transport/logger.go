@@ -0,0 +1,21 @@
+package transport
+
+import "github.com/aivorynet/agent-go/pkg/internal/logging"
+
+// Logger receives the log lines of a transport. Lines are passed without
+// the "[AIVory Monitor]" prefix the default logger adds. Debug lines
+// repeated within 30s are suppressed before they reach it.
+type Logger interface {
+	Debugf(format string, args ...interface{})
+	Infof(format string, args ...interface{})
+	Warnf(format string, args ...interface{})
+	Errorf(format string, args ...interface{})
+}
+
+// WithLogger sends the connection's log lines to l instead of the standard
+// logger.
+func WithLogger(l Logger) Option {
+	return func(c *Connection) {
+		c.log = logging.New(l)
+	}
+}
@@ -0,0 +1,95 @@
+package transport
+
+import (
+	"github.com/aivorynet/agent-go/pkg/capture"
+)
+
+// WithMaxMessageBytes bounds the encoded size of a single message, before
+// compression, so that one huge capture cannot make the backend close the
+// connection. An oversized exception is replaced by a placeholder that
+// keeps its ID, fingerprint, message and top stack frames, an oversized
+// batch is split into single exceptions, and other oversized messages are
+// dropped. Zero disables the limit.
+func WithMaxMessageBytes(n int) Option {
+	return func(c *Connection) {
+		c.maxMessageBytes = n
+	}
+}
+
+const (
+	// placeholderFrames and placeholderMessageBytes bound the stack trace
+	// and message kept in the placeholder of an oversized exception.
+	placeholderFrames       = 10
+	placeholderMessageBytes = 1024
+)
+
+// oversized returns true if an encoded message exceeds the message size
+// limit.
+func (c *Connection) oversized(data []byte) bool {
+	return c.maxMessageBytes > 0 && len(data) > c.maxMessageBytes
+}
+
+// shrinkMessage handles a message of size bytes that exceeds the message
+// size limit. It returns the encoding of a placeholder for an exception,
+// or nil if the message was split into smaller ones or dropped.
+func (c *Connection) shrinkMessage(msgType string, payload interface{}, size int) []byte {
+	c.counters.oversized.Add(1)
+
+	switch p := payload.(type) {
+	case *capture.ExceptionCapture:
+		data, err := marshalMessage(msgType, c.newMessageID(msgType), oversizedPlaceholder(p, size))
+		if err == nil && !c.oversized(data) {
+			c.log.LimitedWarnf("Capture %s is %d bytes, over the %d byte message limit, sending a placeholder", p.ID, size, c.maxMessageBytes)
+			return data
+		}
+	case []*capture.ExceptionCapture:
+		for _, exc := range p {
+			c.send("exception", exc)
+		}
+		return nil
+	}
+
+	c.log.LimitedWarnf("Dropping %s message of %d bytes, over the %d byte message limit", msgType, size, c.maxMessageBytes)
+	return nil
+}
+
+// oversizedPlaceholder returns a small capture standing in for one that
+// was too large to send. It keeps the identity of the original, so it is
+// grouped with other occurrences, and records the original size in its
+// context.
+func oversizedPlaceholder(exc *capture.ExceptionCapture, size int) *capture.ExceptionCapture {
+	frames := exc.StackTrace
+	if len(frames) > placeholderFrames {
+		frames = frames[:placeholderFrames]
+	}
+	stack := make([]capture.StackFrame, len(frames))
+	for i, f := range frames {
+		f.SourceContext = nil
+		f.SourceLineIndex = 0
+		stack[i] = f
+	}
+
+	return &capture.ExceptionCapture{
+		ID:              exc.ID,
+		ExceptionType:   exc.ExceptionType,
+		Message:         capture.TruncateString(exc.Message, placeholderMessageBytes),
+		Level:           exc.Level,
+		Fingerprint:     exc.Fingerprint,
+		FingerprintMode: exc.FingerprintMode,
+		OccurrenceCount: exc.OccurrenceCount,
+		StackTrace:      stack,
+		Context: map[string]interface{}{
+			"message_oversized": true,
+			"original_bytes":    size,
+		},
+		CapturedAt:       exc.CapturedAt,
+		AgentID:          exc.AgentID,
+		Environment:      exc.Environment,
+		Release:          exc.Release,
+		Runtime:          exc.Runtime,
+		RuntimeInfo:      exc.RuntimeInfo,
+		Host:             exc.Host,
+		Tags:             exc.Tags,
+		MatchedSentinels: exc.MatchedSentinels,
+	}
+}
@@ -0,0 +1,397 @@
+package transport
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/aivorynet/agent-go/pkg/capture"
+	"github.com/aivorynet/agent-go/pkg/internal/logging"
+	"github.com/google/uuid"
+)
+
+const sentryClient = "aivory-go/1.0.0"
+
+// SentryTransport posts captures to a Sentry-compatible endpoint as event
+// envelopes. Captures are sent in the background from a bounded queue;
+// captures are dropped when the queue is full.
+type SentryTransport struct {
+	dsn       string
+	endpoint  string
+	publicKey string
+	debug     bool
+	client    *http.Client
+	log       *logging.Logger
+
+	queue     chan *capture.ExceptionCapture
+	pending   atomic.Int64
+	counters  counters
+	done      chan struct{}
+	closeOnce sync.Once
+}
+
+// NewSentryTransport creates a transport for a Sentry DSN of the form
+// https://<public_key>@<host>[/<path>]/<project_id>.
+func NewSentryTransport(dsn string, debug bool) (*SentryTransport, error) {
+	endpoint, publicKey, err := parseSentryDSN(dsn)
+	if err != nil {
+		return nil, err
+	}
+
+	t := &SentryTransport{
+		dsn:       dsn,
+		endpoint:  endpoint,
+		publicKey: publicKey,
+		debug:     debug,
+		client:    &http.Client{Timeout: 10 * time.Second},
+		log:       logging.New(nil),
+		queue:     make(chan *capture.ExceptionCapture, 100),
+		done:      make(chan struct{}),
+	}
+	go t.run()
+
+	return t, nil
+}
+
+// SetLogger sends the transport's log lines to l instead of the standard
+// logger. It must be called before any capture is sent.
+func (t *SentryTransport) SetLogger(l Logger) {
+	t.log = logging.New(l)
+}
+
+// parseSentryDSN returns the envelope endpoint and public key of a DSN.
+func parseSentryDSN(dsn string) (endpoint, publicKey string, err error) {
+	u, err := url.Parse(dsn)
+	if err != nil {
+		return "", "", fmt.Errorf("invalid Sentry DSN: %w", err)
+	}
+	if u.Scheme != "http" && u.Scheme != "https" {
+		return "", "", fmt.Errorf("invalid Sentry DSN: unsupported scheme %q", u.Scheme)
+	}
+	if u.User == nil || u.User.Username() == "" {
+		return "", "", fmt.Errorf("invalid Sentry DSN: missing public key")
+	}
+
+	path := strings.TrimSuffix(u.Path, "/")
+	slash := strings.LastIndex(path, "/")
+	projectID := path[slash+1:]
+	if projectID == "" {
+		return "", "", fmt.Errorf("invalid Sentry DSN: missing project ID")
+	}
+
+	endpoint = fmt.Sprintf("%s://%s%s/api/%s/envelope/", u.Scheme, u.Host, path[:slash], projectID)
+	return endpoint, u.User.Username(), nil
+}
+
+// SendException queues a capture for sending.
+func (t *SentryTransport) SendException(exc *capture.ExceptionCapture) {
+	t.pending.Add(1)
+	select {
+	case t.queue <- exc:
+	default:
+		t.pending.Add(-1)
+		t.counters.droppedQueueFull.Add(1)
+		if t.debug {
+			t.log.Debugf("Sentry queue full, dropping capture")
+		}
+	}
+}
+
+// Flush blocks until all queued captures have been posted or the timeout
+// elapses, and returns true if the queue was drained.
+func (t *SentryTransport) Flush(timeout time.Duration) bool {
+	deadline := time.Now().Add(timeout)
+	for t.pending.Load() > 0 {
+		if time.Now().After(deadline) {
+			return false
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	return true
+}
+
+// IsConnected returns true until the transport is disconnected.
+func (t *SentryTransport) IsConnected() bool {
+	select {
+	case <-t.done:
+		return false
+	default:
+		return true
+	}
+}
+
+// Disconnect stops the background sender. Queued captures are discarded.
+func (t *SentryTransport) Disconnect() {
+	t.closeOnce.Do(func() {
+		close(t.done)
+	})
+}
+
+func (t *SentryTransport) run() {
+	for {
+		select {
+		case <-t.done:
+			return
+		case exc := <-t.queue:
+			if err := t.post(exc); err != nil && t.debug {
+				t.log.Debugf("Failed to send Sentry envelope: %v", err)
+			}
+			t.pending.Add(-1)
+		}
+	}
+}
+
+// SendSync posts a capture on the calling goroutine, bypassing the queue,
+// and returns true if the endpoint accepted it within timeout.
+func (t *SentryTransport) SendSync(exc *capture.ExceptionCapture, timeout time.Duration) bool {
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+
+	if err := t.postContext(ctx, exc); err != nil {
+		if t.debug {
+			t.log.Debugf("Failed to send Sentry envelope: %v", err)
+		}
+		return false
+	}
+	return true
+}
+
+func (t *SentryTransport) post(exc *capture.ExceptionCapture) error {
+	return t.postContext(context.Background(), exc)
+}
+
+func (t *SentryTransport) postContext(ctx context.Context, exc *capture.ExceptionCapture) error {
+	body, err := SentryEnvelope(exc, t.dsn)
+	if err != nil {
+		return err
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, t.endpoint, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/x-sentry-envelope")
+	req.Header.Set("X-Sentry-Auth", fmt.Sprintf("Sentry sentry_version=7, sentry_key=%s, sentry_client=%s", t.publicKey, sentryClient))
+
+	resp, err := t.client.Do(req)
+	if err != nil {
+		return err
+	}
+	resp.Body.Close()
+
+	if resp.StatusCode >= 300 {
+		return fmt.Errorf("unexpected status %s", resp.Status)
+	}
+	t.counters.markSent()
+	if t.debug {
+		t.log.Debugf("Sent Sentry envelope for %s", exc.ID)
+	}
+	return nil
+}
+
+// SentryEnvelope serializes a capture as a Sentry envelope holding a
+// single event item.
+func SentryEnvelope(exc *capture.ExceptionCapture, dsn string) ([]byte, error) {
+	event := SentryEvent(exc)
+
+	payload, err := json.Marshal(event)
+	if err != nil {
+		return nil, err
+	}
+	header, err := json.Marshal(map[string]interface{}{
+		"event_id": event["event_id"],
+		"sent_at":  time.Now().UTC().Format(time.RFC3339),
+		"dsn":      dsn,
+	})
+	if err != nil {
+		return nil, err
+	}
+	item, err := json.Marshal(map[string]interface{}{
+		"type":   "event",
+		"length": len(payload),
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	var buf bytes.Buffer
+	buf.Write(header)
+	buf.WriteByte('\n')
+	buf.Write(item)
+	buf.WriteByte('\n')
+	buf.Write(payload)
+	buf.WriteByte('\n')
+	return buf.Bytes(), nil
+}
+
+// SentryEvent maps a capture onto Sentry's event schema. Stack frames are
+// reversed into Sentry's oldest-first order, local variables become the
+// vars of the innermost frame, and the capture context is split into the
+// user, contexts (runtime, os, device, trace, build) and extra sections.
+func SentryEvent(exc *capture.ExceptionCapture) map[string]interface{} {
+	event := map[string]interface{}{
+		"event_id":    sentryEventID(exc.ID),
+		"timestamp":   exc.CapturedAt,
+		"platform":    "go",
+		"level":       sentryLevel(exc.Level),
+		"logger":      "aivory",
+		"environment": exc.Environment,
+		"exception": map[string]interface{}{
+			"values": []interface{}{sentryException(exc)},
+		},
+	}
+
+	tags := map[string]string{
+		"agent_id": exc.AgentID,
+		"runtime":  exc.Runtime,
+	}
+	for k, v := range exc.Tags {
+		tags[k] = v
+	}
+	event["tags"] = tags
+
+	if exc.Fingerprint != "" {
+		event["fingerprint"] = []string{exc.Fingerprint}
+	}
+	switch {
+	case exc.Release != "":
+		event["release"] = exc.Release
+	case exc.Build != nil && exc.Build.Version != "":
+		event["release"] = exc.Build.Version
+	}
+
+	contexts := map[string]interface{}{
+		"runtime": map[string]interface{}{
+			"name":    "go",
+			"version": exc.RuntimeInfo.RuntimeVersion,
+		},
+		"os": map[string]interface{}{
+			"name": exc.RuntimeInfo.Platform,
+		},
+		"device": map[string]interface{}{
+			"arch":            exc.RuntimeInfo.Arch,
+			"processor_count": exc.RuntimeInfo.NumCPU,
+		},
+	}
+	if exc.Build != nil {
+		contexts["build"] = exc.Build
+	}
+
+	extra := make(map[string]interface{})
+	for k, v := range exc.Context {
+		switch k {
+		case "user":
+			event["user"] = v
+		case "trace_id", "span_id":
+			trace, _ := contexts["trace"].(map[string]interface{})
+			if trace == nil {
+				trace = make(map[string]interface{})
+				contexts["trace"] = trace
+			}
+			trace[k] = v
+		default:
+			extra[k] = v
+		}
+	}
+	if exc.Process != nil {
+		extra["process"] = exc.Process
+	}
+	if exc.Host != nil {
+		extra["host"] = exc.Host
+	}
+	if exc.Memory != nil {
+		extra["memory"] = exc.Memory
+	}
+	if len(exc.RecentLogs) > 0 {
+		extra["recent_logs"] = exc.RecentLogs
+	}
+	event["contexts"] = contexts
+	if len(extra) > 0 {
+		event["extra"] = extra
+	}
+
+	if len(exc.Breadcrumbs) > 0 {
+		values := make([]interface{}, 0, len(exc.Breadcrumbs))
+		for _, b := range exc.Breadcrumbs {
+			crumb := map[string]interface{}{
+				"timestamp": b.Timestamp,
+				"message":   b.Message,
+			}
+			if b.Category != "" {
+				crumb["category"] = b.Category
+			}
+			if len(b.Data) > 0 {
+				crumb["data"] = b.Data
+			}
+			values = append(values, crumb)
+		}
+		event["breadcrumbs"] = map[string]interface{}{"values": values}
+	}
+
+	return event
+}
+
+func sentryException(exc *capture.ExceptionCapture) map[string]interface{} {
+	frames := make([]interface{}, 0, len(exc.StackTrace))
+	for i := len(exc.StackTrace) - 1; i >= 0; i-- {
+		f := exc.StackTrace[i]
+		frame := map[string]interface{}{
+			"function": f.MethodName,
+			"module":   f.PackageName,
+			"filename": f.FileName,
+			"abs_path": f.FilePath,
+			"lineno":   f.LineNumber,
+			"in_app":   f.InApp,
+		}
+		if idx := f.SourceLineIndex; idx < len(f.SourceContext) {
+			frame["pre_context"] = f.SourceContext[:idx]
+			frame["context_line"] = f.SourceContext[idx]
+			frame["post_context"] = f.SourceContext[idx+1:]
+		}
+		if i == 0 && len(exc.LocalVariables) > 0 {
+			vars := make(map[string]string, len(exc.LocalVariables))
+			for name, v := range exc.LocalVariables {
+				vars[name] = v.Value
+			}
+			frame["vars"] = vars
+		}
+		frames = append(frames, frame)
+	}
+
+	return map[string]interface{}{
+		"type":  exc.ExceptionType,
+		"value": exc.Message,
+		"stacktrace": map[string]interface{}{
+			"frames": frames,
+		},
+		"mechanism": map[string]interface{}{
+			"type":    "generic",
+			"handled": exc.Level != capture.LevelFatal,
+		},
+	}
+}
+
+// sentryEventID returns the capture ID as 32 hex digits, the format Sentry
+// requires, generating a new ID if the capture ID is not a UUID.
+func sentryEventID(id string) string {
+	if u, err := uuid.Parse(id); err == nil {
+		return strings.ReplaceAll(u.String(), "-", "")
+	}
+	return strings.ReplaceAll(uuid.New().String(), "-", "")
+}
+
+// sentryLevel maps a capture level onto Sentry's levels, which use the
+// same names.
+func sentryLevel(level capture.Level) string {
+	if level == "" {
+		return string(capture.LevelError)
+	}
+	return string(level)
+}
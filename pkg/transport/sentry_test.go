@@ -0,0 +1,219 @@
+package transport
+
+import (
+	"bytes"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"regexp"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/aivorynet/agent-go/pkg/capture"
+)
+
+func sentryTestCapture() *capture.ExceptionCapture {
+	return &capture.ExceptionCapture{
+		ID:            "6f9619ff-8b86-4d01-b42d-00cf4fc964ff",
+		ExceptionType: "*errors.errorString",
+		Message:       "checkout failed",
+		Level:         capture.LevelFatal,
+		Fingerprint:   "abc123",
+		CapturedAt:    "2026-03-04T05:06:07Z",
+		AgentID:       "agent-1",
+		Environment:   "production",
+		Release:       "1.2.3",
+		Runtime:       "go",
+		StackTrace: []capture.StackFrame{
+			{
+				MethodName: "charge", PackageName: "shop", FileName: "pay.go", FilePath: "/src/shop/pay.go",
+				LineNumber: 20, InApp: true,
+				SourceContext: []string{"a", "b", "fail()", "c"}, SourceLineIndex: 2,
+			},
+			{MethodName: "main", PackageName: "main", FileName: "main.go", FilePath: "/src/main.go", LineNumber: 5, InApp: true},
+		},
+		LocalVariables: map[string]capture.Variable{"amount": {Name: "amount", Type: "int", Value: "42"}},
+		Context: map[string]interface{}{
+			"user":     map[string]interface{}{"id": "u1"},
+			"trace_id": "4bf92f3577b34da6a3ce929d0e0e4736",
+			"order":    "o-9",
+		},
+		Tags:        map[string]string{"team": "payments"},
+		Breadcrumbs: []capture.Breadcrumb{{Timestamp: "2026-03-04T05:06:00Z", Category: "http", Message: "POST /pay"}},
+	}
+}
+
+func TestSentryEnvelopeShape(t *testing.T) {
+	dsn := "https://key@sentry.example.com/42"
+	body, err := SentryEnvelope(sentryTestCapture(), dsn)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	lines := strings.Split(strings.TrimSuffix(string(body), "\n"), "\n")
+	if len(lines) != 3 {
+		t.Fatalf("envelope has %d lines, want header, item header and payload", len(lines))
+	}
+
+	var header, item struct {
+		EventID string `json:"event_id"`
+		DSN     string `json:"dsn"`
+		SentAt  string `json:"sent_at"`
+		Type    string `json:"type"`
+		Length  int    `json:"length"`
+	}
+	if err := json.Unmarshal([]byte(lines[0]), &header); err != nil {
+		t.Fatalf("envelope header: %v", err)
+	}
+	if err := json.Unmarshal([]byte(lines[1]), &item); err != nil {
+		t.Fatalf("item header: %v", err)
+	}
+	if !regexp.MustCompile(`^[0-9a-f]{32}$`).MatchString(header.EventID) || header.DSN != dsn || header.SentAt == "" {
+		t.Errorf("envelope header = %+v", header)
+	}
+	if item.Type != "event" || item.Length != len(lines[2]) {
+		t.Errorf("item header = %+v, want type event and length %d", item, len(lines[2]))
+	}
+
+	var event struct {
+		EventID     string            `json:"event_id"`
+		Timestamp   string            `json:"timestamp"`
+		Platform    string            `json:"platform"`
+		Level       string            `json:"level"`
+		Environment string            `json:"environment"`
+		Release     string            `json:"release"`
+		Fingerprint []string          `json:"fingerprint"`
+		Tags        map[string]string `json:"tags"`
+		User        map[string]string `json:"user"`
+		Contexts    struct {
+			Trace   map[string]string `json:"trace"`
+			Runtime map[string]string `json:"runtime"`
+		} `json:"contexts"`
+		Extra     map[string]interface{} `json:"extra"`
+		Exception struct {
+			Values []struct {
+				Type       string `json:"type"`
+				Value      string `json:"value"`
+				Stacktrace struct {
+					Frames []struct {
+						Function    string            `json:"function"`
+						Filename    string            `json:"filename"`
+						Lineno      int               `json:"lineno"`
+						InApp       bool              `json:"in_app"`
+						ContextLine string            `json:"context_line"`
+						PreContext  []string          `json:"pre_context"`
+						Vars        map[string]string `json:"vars"`
+					} `json:"frames"`
+				} `json:"stacktrace"`
+				Mechanism struct {
+					Type    string `json:"type"`
+					Handled bool   `json:"handled"`
+				} `json:"mechanism"`
+			} `json:"values"`
+		} `json:"exception"`
+		Breadcrumbs struct {
+			Values []map[string]string `json:"values"`
+		} `json:"breadcrumbs"`
+	}
+	if err := json.Unmarshal([]byte(lines[2]), &event); err != nil {
+		t.Fatalf("event payload: %v", err)
+	}
+
+	if event.EventID != header.EventID || event.EventID != "6f9619ff8b864d01b42d00cf4fc964ff" {
+		t.Errorf("event_id = %q, header event_id = %q", event.EventID, header.EventID)
+	}
+	if event.Platform != "go" || event.Level != "fatal" || event.Timestamp != "2026-03-04T05:06:07Z" {
+		t.Errorf("platform = %q, level = %q, timestamp = %q", event.Platform, event.Level, event.Timestamp)
+	}
+	if event.Environment != "production" || event.Release != "1.2.3" {
+		t.Errorf("environment = %q, release = %q", event.Environment, event.Release)
+	}
+	if len(event.Fingerprint) != 1 || event.Fingerprint[0] != "abc123" {
+		t.Errorf("fingerprint = %q", event.Fingerprint)
+	}
+	if event.Tags["team"] != "payments" || event.Tags["agent_id"] != "agent-1" {
+		t.Errorf("tags = %v", event.Tags)
+	}
+	if event.User["id"] != "u1" || event.Contexts.Trace["trace_id"] != "4bf92f3577b34da6a3ce929d0e0e4736" {
+		t.Errorf("user = %v, trace = %v", event.User, event.Contexts.Trace)
+	}
+	if event.Contexts.Runtime["name"] != "go" || event.Extra["order"] != "o-9" {
+		t.Errorf("runtime = %v, extra = %v", event.Contexts.Runtime, event.Extra)
+	}
+	if len(event.Breadcrumbs.Values) != 1 || event.Breadcrumbs.Values[0]["message"] != "POST /pay" {
+		t.Errorf("breadcrumbs = %v", event.Breadcrumbs.Values)
+	}
+
+	if len(event.Exception.Values) != 1 {
+		t.Fatalf("exception has %d values, want 1", len(event.Exception.Values))
+	}
+	exc := event.Exception.Values[0]
+	if exc.Type != "*errors.errorString" || exc.Value != "checkout failed" {
+		t.Errorf("exception type = %q, value = %q", exc.Type, exc.Value)
+	}
+	if exc.Mechanism.Type != "generic" || exc.Mechanism.Handled {
+		t.Errorf("mechanism = %+v, want an unhandled generic mechanism for a fatal capture", exc.Mechanism)
+	}
+	frames := exc.Stacktrace.Frames
+	if len(frames) != 2 || frames[0].Function != "main" || frames[1].Function != "charge" {
+		t.Fatalf("frames = %+v, want them oldest first", frames)
+	}
+	if f := frames[1]; f.ContextLine != "fail()" || len(f.PreContext) != 2 || f.Vars["amount"] != "42" || !f.InApp || f.Lineno != 20 {
+		t.Errorf("innermost frame = %+v", f)
+	}
+	if frames[0].Vars != nil {
+		t.Error("local variables attached to an outer frame")
+	}
+}
+
+func TestParseSentryDSN(t *testing.T) {
+	for _, tt := range []struct {
+		dsn, endpoint, key string
+		ok                 bool
+	}{
+		{"https://key@sentry.example.com/42", "https://sentry.example.com/api/42/envelope/", "key", true},
+		{"http://key@localhost:9000/sentry/7/", "http://localhost:9000/sentry/api/7/envelope/", "key", true},
+		{"https://sentry.example.com/42", "", "", false},
+		{"https://key@sentry.example.com/", "", "", false},
+		{"wss://key@sentry.example.com/42", "", "", false},
+	} {
+		endpoint, key, err := parseSentryDSN(tt.dsn)
+		if (err == nil) != tt.ok || endpoint != tt.endpoint || key != tt.key {
+			t.Errorf("parseSentryDSN(%q) = %q, %q, %v", tt.dsn, endpoint, key, err)
+		}
+	}
+}
+
+func TestSentryTransportPostsEnvelope(t *testing.T) {
+	requests := make(chan *http.Request, 1)
+	bodies := make(chan []byte, 1)
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := io.ReadAll(r.Body)
+		requests <- r
+		bodies <- body
+	}))
+	defer srv.Close()
+
+	dsn := strings.Replace(srv.URL, "http://", "http://key@", 1) + "/42"
+	tr, err := NewSentryTransport(dsn, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer tr.Disconnect()
+
+	if !tr.SendSync(sentryTestCapture(), 5*time.Second) {
+		t.Fatal("SendSync returned false")
+	}
+	r := <-requests
+	if r.URL.Path != "/api/42/envelope/" || r.Header.Get("Content-Type") != "application/x-sentry-envelope" {
+		t.Errorf("posted to %s with Content-Type %q", r.URL.Path, r.Header.Get("Content-Type"))
+	}
+	if auth := r.Header.Get("X-Sentry-Auth"); !strings.Contains(auth, "sentry_key=key") {
+		t.Errorf("X-Sentry-Auth = %q", auth)
+	}
+	if body := <-bodies; bytes.Count(body, []byte("\n")) != 3 {
+		t.Errorf("posted body is not an envelope: %s", body)
+	}
+}
@@ -0,0 +1,232 @@
+package transport
+
+import (
+	"bytes"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gorilla/websocket"
+)
+
+// WithDiskQueue spools exceptions that cannot be delivered, because the
+// agent is not connected or the queue is full, to JSON files in dir. They
+// are replayed oldest first once the agent is registered, also by a later
+// process using the same directory. With WithReliableDelivery, a replayed
+// file is deleted once the backend acknowledges its message, so a message
+// survives a restart until then; otherwise it is deleted once written. The
+// oldest files are evicted to keep the spool under maxBytes.
+func WithDiskQueue(dir string, maxBytes int64) Option {
+	return func(c *Connection) {
+		c.spool = &diskQueue{dir: dir, maxBytes: maxBytes}
+	}
+}
+
+// diskQueue stores messages as files named so that lexical order is
+// arrival order.
+type diskQueue struct {
+	dir      string
+	maxBytes int64
+
+	mu  sync.Mutex
+	seq int
+
+	// unacked maps the IDs of replayed messages awaiting their ack to
+	// their file names. Their files are kept, and skipped by later
+	// replays, until the message is acknowledged or given up.
+	unacked map[string]string
+}
+
+// push writes a message to the spool and evicts the oldest messages if the
+// spool exceeds its size limit.
+func (q *diskQueue) push(data []byte) error {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	if err := os.MkdirAll(q.dir, 0o700); err != nil {
+		return err
+	}
+
+	q.seq++
+	name := fmt.Sprintf("%020d-%06d.json", time.Now().UnixNano(), q.seq%1000000)
+
+	// Write to a temporary file and rename it so a crash never leaves a
+	// partial message to be replayed.
+	tmp := filepath.Join(q.dir, name+".tmp")
+	if err := os.WriteFile(tmp, data, 0o600); err != nil {
+		return err
+	}
+	if err := os.Rename(tmp, filepath.Join(q.dir, name)); err != nil {
+		os.Remove(tmp)
+		return err
+	}
+
+	q.evict()
+	return nil
+}
+
+// evict deletes the oldest messages until the spool fits maxBytes.
+func (q *diskQueue) evict() {
+	if q.maxBytes <= 0 {
+		return
+	}
+
+	names := q.names()
+	sizes := make([]int64, len(names))
+	var total int64
+	for i, name := range names {
+		if info, err := os.Stat(filepath.Join(q.dir, name)); err == nil {
+			sizes[i] = info.Size()
+			total += sizes[i]
+		}
+	}
+
+	for i := 0; i < len(names) && total > q.maxBytes; i++ {
+		os.Remove(filepath.Join(q.dir, names[i]))
+		total -= sizes[i]
+	}
+}
+
+// pending returns the spooled message files not awaiting an ack, oldest
+// first.
+func (q *diskQueue) pending() []string {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	names := q.names()
+	if len(q.unacked) == 0 {
+		return names
+	}
+	waiting := make(map[string]bool, len(q.unacked))
+	for _, name := range q.unacked {
+		waiting[name] = true
+	}
+	kept := names[:0]
+	for _, name := range names {
+		if !waiting[name] {
+			kept = append(kept, name)
+		}
+	}
+	return kept
+}
+
+// written handles a replayed file: it is kept until the message with the
+// given ID is acknowledged, or deleted right away if id is "".
+func (q *diskQueue) written(name, id string) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	if id == "" {
+		os.Remove(filepath.Join(q.dir, name))
+		return
+	}
+	if q.unacked == nil {
+		q.unacked = make(map[string]string)
+	}
+	q.unacked[id] = name
+}
+
+// release deletes the file of a replayed message once it is acknowledged
+// or given up. It does nothing for messages not replayed from the spool.
+func (q *diskQueue) release(id string) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	name, ok := q.unacked[id]
+	if !ok {
+		return
+	}
+	delete(q.unacked, id)
+	os.Remove(filepath.Join(q.dir, name))
+}
+
+// names returns the spooled message files, oldest first.
+func (q *diskQueue) names() []string {
+	entries, err := os.ReadDir(q.dir)
+	if err != nil {
+		return nil
+	}
+
+	var names []string
+	for _, entry := range entries {
+		if !entry.IsDir() && strings.HasSuffix(entry.Name(), ".json") {
+			names = append(names, entry.Name())
+		}
+	}
+	sort.Strings(names)
+	return names
+}
+
+// spoolable returns true for encoded messages that are kept on disk when
+// they cannot be delivered: exceptions and exception batches. Messages are
+// encoded by marshalMessage, which always puts the type first.
+func spoolable(data []byte) bool {
+	return bytes.HasPrefix(data, []byte(`{"type":"exception`))
+}
+
+// replaySpool writes the spooled messages on conn, oldest first. Each file
+// is deleted once written, or with reliable delivery once its message is
+// acknowledged. It returns false if a write failed; the remaining messages
+// stay on disk. The spool is not locked while writing, so exceptions can
+// be spooled meanwhile.
+func (c *Connection) replaySpool(conn *websocket.Conn) bool {
+	if c.spool == nil {
+		return true
+	}
+
+	names := c.spool.pending()
+	if len(names) > 0 && c.debug {
+		c.log.Debugf("Replaying %d spooled messages", len(names))
+	}
+
+	for _, name := range names {
+		path := filepath.Join(c.spool.dir, name)
+		data, err := os.ReadFile(path)
+		if err != nil {
+			continue
+		}
+		if err := c.write(conn, data); err != nil {
+			if c.debug {
+				c.log.Debugf("Write error replaying spool: %v", err)
+			}
+			return false
+		}
+		c.counters.markSent()
+		c.trackSent(data)
+
+		id := ""
+		if c.reliable {
+			id = messageID(data)
+		}
+		c.spool.written(name, id)
+	}
+	return true
+}
+
+// releaseSpooled deletes the spool file of a message that is acknowledged
+// or given up.
+func (c *Connection) releaseSpooled(id string) {
+	if c.spool != nil && id != "" {
+		c.spool.release(id)
+	}
+}
+
+// spoolMessage stores an exception message that could not be queued. It
+// returns false if there is no spool, the message is not an exception or
+// it could not be written.
+func (c *Connection) spoolMessage(data []byte) bool {
+	if c.spool == nil || !spoolable(data) {
+		return false
+	}
+	if err := c.spool.push(data); err != nil {
+		if c.debug {
+			c.log.Debugf("Failed to spool message: %v", err)
+		}
+		return false
+	}
+	return true
+}
@@ -0,0 +1,109 @@
+package transport
+
+import "time"
+
+// ConnectionState is the state of the connection to the backend.
+type ConnectionState int
+
+// Connection states.
+const (
+	// StateDisconnected means there is no connection, e.g. after a read
+	// error or a failed dial, or after Disconnect.
+	StateDisconnected ConnectionState = iota
+	// StateConnecting means a connection is being dialed.
+	StateConnecting
+	// StateConnected means the WebSocket is open and the agent is
+	// registering.
+	StateConnected
+	// StateAuthenticated means the backend accepted the agent.
+	StateAuthenticated
+	// StateFailed means the connection gave up: reconnect attempts are
+	// exhausted or authentication was rejected.
+	StateFailed
+)
+
+// String returns the name of the state.
+func (s ConnectionState) String() string {
+	switch s {
+	case StateDisconnected:
+		return "disconnected"
+	case StateConnecting:
+		return "connecting"
+	case StateConnected:
+		return "connected"
+	case StateAuthenticated:
+		return "authenticated"
+	case StateFailed:
+		return "failed"
+	}
+	return "unknown"
+}
+
+// MarshalText encodes the state as its name.
+func (s ConnectionState) MarshalText() ([]byte, error) {
+	return []byte(s.String()), nil
+}
+
+// WithConnectionStateHandler sets a function called on every connection
+// state transition. It is called without holding any connection lock, from
+// the connection's goroutines, so it should return quickly.
+func WithConnectionStateHandler(fn func(ConnectionState)) Option {
+	return func(c *Connection) {
+		c.stateHandler = fn
+	}
+}
+
+// setState records a state transition and notifies the handler if the
+// state changed.
+func (c *Connection) setState(state ConnectionState) {
+	c.stateMu.Lock()
+	changed := c.state != state
+	c.state = state
+	c.stateMu.Unlock()
+
+	if changed && c.stateHandler != nil {
+		c.stateHandler(state)
+	}
+}
+
+// ConnectionInfo describes the connection to the backend for diagnostics,
+// e.g. in readiness probes.
+type ConnectionInfo struct {
+	// URL is the backend URL, empty for transports without one.
+	URL string `json:"url,omitempty"`
+
+	// State is the current connection state.
+	State ConnectionState `json:"state"`
+
+	// Connected is true while the WebSocket is open and Authenticated
+	// once the backend has accepted the agent on it.
+	Connected     bool `json:"connected"`
+	Authenticated bool `json:"authenticated"`
+
+	// ReconnectAttempt is the number of failed attempts since the last
+	// successful connection; zero while connected.
+	ReconnectAttempt int `json:"reconnect_attempt"`
+
+	// LastSentAt is the time of the last message successfully written to
+	// the backend; zero if none was.
+	LastSentAt time.Time `json:"last_sent_at"`
+}
+
+// Info returns a snapshot of the connection's diagnostics.
+func (c *Connection) Info() ConnectionInfo {
+	c.mu.RLock()
+	info := ConnectionInfo{
+		URL:           c.url,
+		Connected:     c.connected,
+		Authenticated: c.connected && c.authenticated,
+	}
+	c.mu.RUnlock()
+
+	c.stateMu.Lock()
+	info.State = c.state
+	c.stateMu.Unlock()
+
+	info.ReconnectAttempt = int(c.reconnectAttempts.Load())
+	info.LastSentAt = c.counters.lastSentAt()
+	return info
+}
@@ -0,0 +1,88 @@
+package transport
+
+import (
+	"sync/atomic"
+	"time"
+)
+
+// Stats is a snapshot of a transport's message counters.
+type Stats struct {
+	// Sent counts messages written to the backend.
+	Sent int64 `json:"sent"`
+
+	// DroppedQueueFull counts messages dropped because the send queue was
+	// full and they could not be spooled.
+	DroppedQueueFull int64 `json:"dropped_queue_full"`
+
+	// DroppedDisconnected counts messages dropped because the agent was
+	// not connected and they could not be spooled or buffered, including
+	// those evicted from a full offline buffer.
+	DroppedDisconnected int64 `json:"dropped_disconnected"`
+
+	// Reconnects counts connection attempts after the first.
+	Reconnects int64 `json:"reconnects"`
+
+	// Acked counts exception messages acknowledged by the backend and
+	// Retried counts re-sends of unacknowledged ones. Both stay zero
+	// unless reliable delivery is enabled.
+	Acked   int64 `json:"acked"`
+	Retried int64 `json:"retried"`
+
+	// Oversized counts messages over the message size limit, which are
+	// replaced by a placeholder, split or dropped.
+	Oversized int64 `json:"oversized"`
+}
+
+// counters holds the message counters of a transport. They are updated
+// with atomics so the send path does not contend on the connection lock.
+type counters struct {
+	sent                atomic.Int64
+	droppedQueueFull    atomic.Int64
+	droppedDisconnected atomic.Int64
+	reconnects          atomic.Int64
+	acked               atomic.Int64
+	retried             atomic.Int64
+	oversized           atomic.Int64
+
+	// lastSent is the time of the last successful write in Unix
+	// nanoseconds, or zero.
+	lastSent atomic.Int64
+}
+
+// markSent counts a message written to the backend.
+func (c *counters) markSent() {
+	c.sent.Add(1)
+	c.lastSent.Store(time.Now().UnixNano())
+}
+
+// lastSentAt returns the time of the last successful write, or the zero
+// time if nothing was written yet.
+func (c *counters) lastSentAt() time.Time {
+	if ns := c.lastSent.Load(); ns != 0 {
+		return time.Unix(0, ns)
+	}
+	return time.Time{}
+}
+
+// snapshot returns the current counter values.
+func (c *counters) snapshot() Stats {
+	return Stats{
+		Sent:                c.sent.Load(),
+		DroppedQueueFull:    c.droppedQueueFull.Load(),
+		DroppedDisconnected: c.droppedDisconnected.Load(),
+		Reconnects:          c.reconnects.Load(),
+		Acked:               c.acked.Load(),
+		Retried:             c.retried.Load(),
+		Oversized:           c.oversized.Load(),
+	}
+}
+
+// Stats returns a snapshot of the connection's message counters.
+func (c *Connection) Stats() Stats {
+	return c.counters.snapshot()
+}
+
+// Stats returns a snapshot of the transport's message counters.
+func (t *SentryTransport) Stats() Stats {
+	return t.counters.snapshot()
+}
@@ -0,0 +1,23 @@
+package transport
+
+import "github.com/aivorynet/agent-go/pkg/capture"
+
+// Transport delivers captures to a backend. Connection, the WebSocket
+// connection to the AIVory backend, is the default implementation;
+// SentryTransport and custom sinks, e.g. one writing NDJSON to stdout or an
+// in-memory one for tests, implement it too. A Transport that also has a
+// Flush(time.Duration) bool method is flushed by the agent on shutdown.
+type Transport interface {
+	// SendException delivers a capture. It must not block for long.
+	SendException(exc *capture.ExceptionCapture)
+	// IsConnected returns true if captures can currently be delivered.
+	IsConnected() bool
+	// Disconnect releases the transport's resources. It may be called
+	// more than once.
+	Disconnect()
+}
+
+var (
+	_ Transport = (*Connection)(nil)
+	_ Transport = (*SentryTransport)(nil)
+)
@@ -0,0 +1,33 @@
+package transport
+
+import (
+	"fmt"
+	"net/url"
+	"strings"
+)
+
+// NormalizeURL validates a backend URL and rewrites http:// and https://
+// to the equivalent ws:// and wss:// schemes. It returns an error that
+// explains the expected format if the URL cannot be used.
+func NormalizeURL(raw string) (string, error) {
+	u, err := url.Parse(raw)
+	if err != nil {
+		return "", fmt.Errorf("invalid backend URL %q: %v", raw, err)
+	}
+
+	switch strings.ToLower(u.Scheme) {
+	case "ws", "wss":
+	case "http":
+		u.Scheme = "ws"
+	case "https":
+		u.Scheme = "wss"
+	default:
+		return "", fmt.Errorf("invalid backend URL %q: scheme must be wss:// or ws:// (e.g. wss://api.aivory.net/monitor/agent)", raw)
+	}
+
+	if u.Host == "" {
+		return "", fmt.Errorf("invalid backend URL %q: missing host", raw)
+	}
+
+	return u.String(), nil
+}
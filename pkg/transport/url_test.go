@@ -0,0 +1,30 @@
+package transport
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestNormalizeURLHTTPSchemes(t *testing.T) {
+	for raw, want := range map[string]string{
+		"http://localhost:19999/ws":            "ws://localhost:19999/ws",
+		"https://api.aivory.net/monitor/agent": "wss://api.aivory.net/monitor/agent",
+		"ws://localhost:19999/ws":              "ws://localhost:19999/ws",
+		"wss://api.aivory.net/monitor/agent":   "wss://api.aivory.net/monitor/agent",
+	} {
+		got, err := NormalizeURL(raw)
+		if err != nil || got != want {
+			t.Errorf("NormalizeURL(%q) = %q, %v; want %q", raw, got, err, want)
+		}
+	}
+}
+
+func TestNormalizeURLInvalid(t *testing.T) {
+	_, err := NormalizeURL("api.aivory.net:443")
+	if err == nil {
+		t.Fatal("NormalizeURL accepted a URL without a scheme")
+	}
+	if !strings.Contains(err.Error(), "wss://") {
+		t.Errorf("error %q does not explain the expected scheme", err)
+	}
+}